@@ -0,0 +1,72 @@
+// Package bandwidth decides how much bandwidth butler may use.
+//
+// The user picks a throttle (with the global --throttle flag, or with
+// Network.SetBandwidthThrottle when running butlerd), and some features
+// temporarily cap it further, like the download schedule's windows.
+// Whichever is lowest is applied to timeout.ThrottlerPool.
+package bandwidth
+
+import (
+	"sync"
+
+	"github.com/efarrer/iothrottler"
+	"github.com/itchio/httpkit/timeout"
+)
+
+// Unlimited means no throttle, or no cap
+const Unlimited int64 = 0
+
+var (
+	lock     sync.Mutex
+	throttle int64
+	limit    int64
+)
+
+// SetThrottle sets the user's throttle, in Kbps (kilobits per second).
+// It stays in effect until it's changed again, caps only ever lower it.
+func SetThrottle(kbps int64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	throttle = kbps
+	apply()
+}
+
+// SetCap temporarily caps the user's throttle, in Kbps. Setting it to
+// Unlimited restores the user's throttle.
+func SetCap(kbps int64) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	limit = kbps
+	apply()
+}
+
+// Effective returns the rate currently applied, in Kbps
+func Effective() int64 {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return effective(throttle, limit)
+}
+
+func effective(throttle int64, limit int64) int64 {
+	switch {
+	case throttle <= 0:
+		return max(limit, 0)
+	case limit <= 0:
+		return throttle
+	default:
+		return min(throttle, limit)
+	}
+}
+
+// caller must hold lock
+func apply() {
+	rate := effective(throttle, limit)
+	if rate == Unlimited {
+		timeout.ThrottlerPool.SetBandwidth(iothrottler.Unlimited)
+	} else {
+		timeout.ThrottlerPool.SetBandwidth(iothrottler.Bandwidth(rate) * iothrottler.Kbps)
+	}
+}
@@ -0,0 +1,31 @@
+package bandwidth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffective(t *testing.T) {
+	assert.Equal(t, Unlimited, effective(Unlimited, Unlimited))
+	assert.Equal(t, int64(800), effective(800, Unlimited))
+	assert.Equal(t, int64(400), effective(Unlimited, 400))
+	assert.Equal(t, int64(400), effective(800, 400))
+	assert.Equal(t, int64(800), effective(800, 1600), "caps never raise the throttle")
+	assert.Equal(t, Unlimited, effective(-1, Unlimited), "negative means unlimited")
+}
+
+func TestCapRestoresThrottle(t *testing.T) {
+	defer SetThrottle(Unlimited)
+
+	SetThrottle(800)
+	SetCap(400)
+	assert.Equal(t, int64(400), Effective())
+
+	SetThrottle(200)
+	assert.Equal(t, int64(200), Effective())
+
+	SetThrottle(800)
+	SetCap(Unlimited)
+	assert.Equal(t, int64(800), Effective())
+}
@@ -50,56 +50,79 @@ func (s *Server) ServeStdio(ctx context.Context, params ServeStdioParams, rwc js
 }
 
 func (s *Server) ServeTCP(ctx context.Context, params ServeTCPParams) error {
-	if params.KeepAlive {
-		return s.serveTCPKeepAlive(ctx, params)
-	} else {
-		return s.serveTCPClose(ctx, params)
-	}
+	return serveConns(ctx, serveConnsParams{
+		Kind:         "TCP",
+		Listener:     params.Listener,
+		KeepAlive:    params.KeepAlive,
+		ShutdownChan: params.ShutdownChan,
+		Handle: func(ctx context.Context, conn net.Conn) error {
+			return s.handleTCPConn(ctx, params, conn)
+		},
+	})
 }
 
-func (s *Server) serveTCPClose(ctx context.Context, params ServeTCPParams) error {
-	tcpConn, err := params.Listener.Accept()
-	if err != nil {
-		return err
-	}
+type serveConnsParams struct {
+	// for logging, "TCP" for example
+	Kind      string
+	Listener  net.Listener
+	KeepAlive bool
+	Handle    func(ctx context.Context, conn net.Conn) error
 
-	return s.handleTCPConn(ctx, params, tcpConn)
+	ShutdownChan chan struct{}
 }
 
-func (s *Server) serveTCPKeepAlive(ctx context.Context, params ServeTCPParams) error {
+// serveConns hands connections accepted from a listener to a handler:
+// only the first one, or with KeepAlive, all of them until shutdown.
+func serveConns(ctx context.Context, params serveConnsParams) error {
+	if !params.KeepAlive {
+		conn, err := params.Listener.Accept()
+		if err != nil {
+			return err
+		}
+		return params.Handle(ctx, conn)
+	}
+
 	var wg sync.WaitGroup
 	conns := make(chan net.Conn)
+	acceptDone := make(chan struct{})
 	go func() {
+		defer close(acceptDone)
 		for {
-			tcpConn, err := params.Listener.Accept()
+			conn, err := params.Listener.Accept()
 			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
 				log.Printf("While accepting connection: %+v", err)
+				continue
 			}
-			conns <- tcpConn
+			conns <- conn
 		}
 	}()
 
 	for {
 		select {
-		case tcpConn := <-conns:
+		case conn := <-conns:
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				err := s.handleTCPConn(ctx, params, tcpConn)
+				err := params.Handle(ctx, conn)
 				if err != nil {
-					log.Printf("While handling TCP connection: %+v", err)
+					log.Printf("While handling %s connection: %+v", params.Kind, err)
 				}
 			}()
+		case <-acceptDone:
+			return nil
 		case <-params.ShutdownChan:
-			log.Printf("Closing TCP listener...")
+			log.Printf("Closing %s listener...", params.Kind)
 			err := params.Listener.Close()
 			if err != nil {
-				log.Printf("While closing TCP listener: %+v", err)
+				log.Printf("While closing %s listener: %+v", params.Kind, err)
 			}
 
-			log.Printf("Waiting for TCP connections to close...")
+			log.Printf("Waiting for %s connections to close...", params.Kind)
 			wg.Wait()
-			log.Printf("All TCP connections closed")
+			log.Printf("All %s connections closed", params.Kind)
 
 			return nil
 		case <-ctx.Done():
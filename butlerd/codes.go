@@ -29,6 +29,10 @@ var codeMessages = map[Code]string{
 	CodeCantRemoveLocationBecauseOfActiveDownloads: "An install location could not be removed because it has active downloads",
 
 	CodeSandboxNotAvailable: "The selected sandbox is not available on this system.",
+
+	CodeDownloadsDriveAlreadyRunning: "Downloads are already being driven elsewhere.",
+
+	CodeNoRollbackSnapshot: "No previous build was kept for this install.",
 }
 
 func (code Code) RpcErrorMessage() string {
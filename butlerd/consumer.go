@@ -1,6 +1,7 @@
 package butlerd
 
 import (
+	"log/slog"
 	"os"
 
 	"github.com/itchio/butler/butlerd/jsonrpc2"
@@ -15,6 +16,8 @@ type NewStateConsumerParams struct {
 
 	// Optional
 	LogFile *os.File
+	// Optional, also receives all messages (see RequestLog)
+	Logger *slog.Logger
 }
 
 func NewStateConsumer(params *NewStateConsumerParams) (*state.Consumer, error) {
@@ -31,6 +34,7 @@ func NewStateConsumer(params *NewStateConsumerParams) (*state.Consumer, error) {
 			if err != nil {
 				comm.Warnf("Failed to notify: %#v", err)
 			}
+			logMessage(params.Logger, level, msg)
 		},
 	}
 
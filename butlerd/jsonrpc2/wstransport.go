@@ -0,0 +1,65 @@
+package jsonrpc2
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsTransport sends each JSON-RPC message as a WebSocket text message,
+// so browsers can talk to butlerd without a bridge.
+type wsTransport struct {
+	conn       *websocket.Conn
+	closed     bool
+	closeChan  chan struct{}
+	closeMutex sync.Mutex
+}
+
+func NewWebSocketTransport(conn *websocket.Conn) Transport {
+	return &wsTransport{
+		conn:      conn,
+		closed:    false,
+		closeChan: make(chan struct{}),
+	}
+}
+
+func (ws *wsTransport) Read() ([]byte, error) {
+	select {
+	case <-ws.closeChan:
+		return nil, io.EOF
+	default:
+		// continue
+	}
+
+	var msg []byte
+	err := websocket.Message.Receive(ws.conn, &msg)
+	if err != nil {
+		select {
+		case <-ws.closeChan:
+			// reading from a connection we closed
+			return nil, io.EOF
+		default:
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func (ws *wsTransport) Write(msg []byte) error {
+	// strings are sent as text messages, byte slices as binary ones
+	return websocket.Message.Send(ws.conn, string(msg))
+}
+
+func (ws *wsTransport) Close() error {
+	ws.closeMutex.Lock()
+	defer ws.closeMutex.Unlock()
+
+	if ws.closed {
+		return nil
+	}
+
+	close(ws.closeChan)
+	ws.closed = true
+	return ws.conn.Close()
+}
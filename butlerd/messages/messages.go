@@ -140,6 +140,126 @@ func (r *MetaShutdownType) TestCall(rc *butlerd.RequestContext, params butlerd.M
 
 var MetaShutdown *MetaShutdownType
 
+// Meta.Subscribe (Request)
+
+type MetaSubscribeType struct {}
+
+var _ RequestMessage = (*MetaSubscribeType)(nil)
+
+func (r *MetaSubscribeType) Method() string {
+  return "Meta.Subscribe"
+}
+
+func (r *MetaSubscribeType) Register(router router, f func(*butlerd.RequestContext, butlerd.MetaSubscribeParams) (*butlerd.MetaSubscribeResult, error)) {
+  router.Register("Meta.Subscribe", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.MetaSubscribeParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Meta.Subscribe")
+    }
+    return res, nil
+  })
+}
+
+func (r *MetaSubscribeType) TestCall(rc *butlerd.RequestContext, params butlerd.MetaSubscribeParams) (*butlerd.MetaSubscribeResult, error) {
+  var result butlerd.MetaSubscribeResult
+  err := rc.Call("Meta.Subscribe", params, &result)
+  return &result, err
+}
+
+var MetaSubscribe *MetaSubscribeType
+
+// Meta.Inspect (Request)
+
+type MetaInspectType struct {}
+
+var _ RequestMessage = (*MetaInspectType)(nil)
+
+func (r *MetaInspectType) Method() string {
+  return "Meta.Inspect"
+}
+
+func (r *MetaInspectType) Register(router router, f func(*butlerd.RequestContext, butlerd.MetaInspectParams) (*butlerd.MetaInspectResult, error)) {
+  router.Register("Meta.Inspect", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.MetaInspectParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Meta.Inspect")
+    }
+    return res, nil
+  })
+}
+
+func (r *MetaInspectType) TestCall(rc *butlerd.RequestContext, params butlerd.MetaInspectParams) (*butlerd.MetaInspectResult, error) {
+  var result butlerd.MetaInspectResult
+  err := rc.Call("Meta.Inspect", params, &result)
+  return &result, err
+}
+
+var MetaInspect *MetaInspectType
+
+// Meta.Cancel (Request)
+
+type MetaCancelType struct {}
+
+var _ RequestMessage = (*MetaCancelType)(nil)
+
+func (r *MetaCancelType) Method() string {
+  return "Meta.Cancel"
+}
+
+func (r *MetaCancelType) Register(router router, f func(*butlerd.RequestContext, butlerd.MetaCancelParams) (*butlerd.MetaCancelResult, error)) {
+  router.Register("Meta.Cancel", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.MetaCancelParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Meta.Cancel")
+    }
+    return res, nil
+  })
+}
+
+func (r *MetaCancelType) TestCall(rc *butlerd.RequestContext, params butlerd.MetaCancelParams) (*butlerd.MetaCancelResult, error) {
+  var result butlerd.MetaCancelResult
+  err := rc.Call("Meta.Cancel", params, &result)
+  return &result, err
+}
+
+var MetaCancel *MetaCancelType
+
 // MetaFlowEstablished (Notification)
 
 type MetaFlowEstablishedType struct {}
@@ -468,6 +588,35 @@ func (r *DownloadsDriveNetworkStatusType) Register(router router, f func(butlerd
 
 var DownloadsDriveNetworkStatus *DownloadsDriveNetworkStatusType
 
+// Downloads.Drive.ScheduleWindowChanged (Notification)
+
+type DownloadsDriveScheduleWindowChangedType struct {}
+
+var _ NotificationMessage = (*DownloadsDriveScheduleWindowChangedType)(nil)
+
+func (r *DownloadsDriveScheduleWindowChangedType) Method() string {
+  return "Downloads.Drive.ScheduleWindowChanged"
+}
+
+func (r *DownloadsDriveScheduleWindowChangedType) Notify(rc *butlerd.RequestContext, params butlerd.DownloadsDriveScheduleWindowChangedNotification) (error) {
+  return rc.Notify("Downloads.Drive.ScheduleWindowChanged", params)
+}
+
+func (r *DownloadsDriveScheduleWindowChangedType) Register(router router, f func(butlerd.DownloadsDriveScheduleWindowChangedNotification)) {
+  router.RegisterNotification("Downloads.Drive.ScheduleWindowChanged", func (notif jsonrpc2.Notification) {
+    var params butlerd.DownloadsDriveScheduleWindowChangedNotification
+    if notif.Params != nil {
+      err := json.Unmarshal(*notif.Params, &params)
+      if err != nil {
+        return
+      }
+    }
+    f(params)
+  })
+}
+
+var DownloadsDriveScheduleWindowChanged *DownloadsDriveScheduleWindowChangedType
+
 // Log (Notification)
 
 type LogType struct {}
@@ -1712,6 +1861,46 @@ func (r *FetchCommonsType) TestCall(rc *butlerd.RequestContext, params butlerd.F
 
 var FetchCommons *FetchCommonsType
 
+// Fetch.PlayTime (Request)
+
+type FetchPlayTimeType struct {}
+
+var _ RequestMessage = (*FetchPlayTimeType)(nil)
+
+func (r *FetchPlayTimeType) Method() string {
+  return "Fetch.PlayTime"
+}
+
+func (r *FetchPlayTimeType) Register(router router, f func(*butlerd.RequestContext, butlerd.FetchPlayTimeParams) (*butlerd.FetchPlayTimeResult, error)) {
+  router.Register("Fetch.PlayTime", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.FetchPlayTimeParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Fetch.PlayTime")
+    }
+    return res, nil
+  })
+}
+
+func (r *FetchPlayTimeType) TestCall(rc *butlerd.RequestContext, params butlerd.FetchPlayTimeParams) (*butlerd.FetchPlayTimeResult, error) {
+  var result butlerd.FetchPlayTimeResult
+  err := rc.Call("Fetch.PlayTime", params, &result)
+  return &result, err
+}
+
+var FetchPlayTime *FetchPlayTimeType
+
 // Fetch.Caves (Request)
 
 type FetchCavesType struct {}
@@ -2157,6 +2346,46 @@ func (r *CavesSetPinnedType) TestCall(rc *butlerd.RequestContext, params butlerd
 
 var CavesSetPinned *CavesSetPinnedType
 
+// Caves.Rollback (Request)
+
+type CavesRollbackType struct {}
+
+var _ RequestMessage = (*CavesRollbackType)(nil)
+
+func (r *CavesRollbackType) Method() string {
+  return "Caves.Rollback"
+}
+
+func (r *CavesRollbackType) Register(router router, f func(*butlerd.RequestContext, butlerd.CavesRollbackParams) (*butlerd.CavesRollbackResult, error)) {
+  router.Register("Caves.Rollback", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.CavesRollbackParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Caves.Rollback")
+    }
+    return res, nil
+  })
+}
+
+func (r *CavesRollbackType) TestCall(rc *butlerd.RequestContext, params butlerd.CavesRollbackParams) (*butlerd.CavesRollbackResult, error) {
+  var result butlerd.CavesRollbackResult
+  err := rc.Call("Caves.Rollback", params, &result)
+  return &result, err
+}
+
+var CavesRollback *CavesRollbackType
+
 // Install.CreateShortcut (Request)
 
 type InstallCreateShortcutType struct {}
@@ -2644,6 +2873,46 @@ func (r *InstallLocationsRemoveType) TestCall(rc *butlerd.RequestContext, params
 
 var InstallLocationsRemove *InstallLocationsRemoveType
 
+// Install.Locations.SetRollbackRetention (Request)
+
+type InstallLocationsSetRollbackRetentionType struct {}
+
+var _ RequestMessage = (*InstallLocationsSetRollbackRetentionType)(nil)
+
+func (r *InstallLocationsSetRollbackRetentionType) Method() string {
+  return "Install.Locations.SetRollbackRetention"
+}
+
+func (r *InstallLocationsSetRollbackRetentionType) Register(router router, f func(*butlerd.RequestContext, butlerd.InstallLocationsSetRollbackRetentionParams) (*butlerd.InstallLocationsSetRollbackRetentionResult, error)) {
+  router.Register("Install.Locations.SetRollbackRetention", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.InstallLocationsSetRollbackRetentionParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Install.Locations.SetRollbackRetention")
+    }
+    return res, nil
+  })
+}
+
+func (r *InstallLocationsSetRollbackRetentionType) TestCall(rc *butlerd.RequestContext, params butlerd.InstallLocationsSetRollbackRetentionParams) (*butlerd.InstallLocationsSetRollbackRetentionResult, error) {
+  var result butlerd.InstallLocationsSetRollbackRetentionResult
+  err := rc.Call("Install.Locations.SetRollbackRetention", params, &result)
+  return &result, err
+}
+
+var InstallLocationsSetRollbackRetention *InstallLocationsSetRollbackRetentionType
+
 // Install.Locations.GetByID (Request)
 
 type InstallLocationsGetByIDType struct {}
@@ -2958,6 +3227,46 @@ func (r *DownloadsClearFinishedType) TestCall(rc *butlerd.RequestContext, params
 
 var DownloadsClearFinished *DownloadsClearFinishedType
 
+// Downloads.History (Request)
+
+type DownloadsHistoryType struct {}
+
+var _ RequestMessage = (*DownloadsHistoryType)(nil)
+
+func (r *DownloadsHistoryType) Method() string {
+  return "Downloads.History"
+}
+
+func (r *DownloadsHistoryType) Register(router router, f func(*butlerd.RequestContext, butlerd.DownloadsHistoryParams) (*butlerd.DownloadsHistoryResult, error)) {
+  router.Register("Downloads.History", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.DownloadsHistoryParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Downloads.History")
+    }
+    return res, nil
+  })
+}
+
+func (r *DownloadsHistoryType) TestCall(rc *butlerd.RequestContext, params butlerd.DownloadsHistoryParams) (*butlerd.DownloadsHistoryResult, error) {
+  var result butlerd.DownloadsHistoryResult
+  err := rc.Call("Downloads.History", params, &result)
+  return &result, err
+}
+
+var DownloadsHistory *DownloadsHistoryType
+
 // Downloads.Drive (Request)
 
 type DownloadsDriveType struct {}
@@ -3118,6 +3427,86 @@ func (r *DownloadsDiscardType) TestCall(rc *butlerd.RequestContext, params butle
 
 var DownloadsDiscard *DownloadsDiscardType
 
+// Downloads.Schedule.Get (Request)
+
+type DownloadsScheduleGetType struct {}
+
+var _ RequestMessage = (*DownloadsScheduleGetType)(nil)
+
+func (r *DownloadsScheduleGetType) Method() string {
+  return "Downloads.Schedule.Get"
+}
+
+func (r *DownloadsScheduleGetType) Register(router router, f func(*butlerd.RequestContext, butlerd.DownloadsScheduleGetParams) (*butlerd.DownloadsScheduleGetResult, error)) {
+  router.Register("Downloads.Schedule.Get", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.DownloadsScheduleGetParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Downloads.Schedule.Get")
+    }
+    return res, nil
+  })
+}
+
+func (r *DownloadsScheduleGetType) TestCall(rc *butlerd.RequestContext, params butlerd.DownloadsScheduleGetParams) (*butlerd.DownloadsScheduleGetResult, error) {
+  var result butlerd.DownloadsScheduleGetResult
+  err := rc.Call("Downloads.Schedule.Get", params, &result)
+  return &result, err
+}
+
+var DownloadsScheduleGet *DownloadsScheduleGetType
+
+// Downloads.Schedule.Set (Request)
+
+type DownloadsScheduleSetType struct {}
+
+var _ RequestMessage = (*DownloadsScheduleSetType)(nil)
+
+func (r *DownloadsScheduleSetType) Method() string {
+  return "Downloads.Schedule.Set"
+}
+
+func (r *DownloadsScheduleSetType) Register(router router, f func(*butlerd.RequestContext, butlerd.DownloadsScheduleSetParams) (*butlerd.DownloadsScheduleSetResult, error)) {
+  router.Register("Downloads.Schedule.Set", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.DownloadsScheduleSetParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Downloads.Schedule.Set")
+    }
+    return res, nil
+  })
+}
+
+func (r *DownloadsScheduleSetType) TestCall(rc *butlerd.RequestContext, params butlerd.DownloadsScheduleSetParams) (*butlerd.DownloadsScheduleSetResult, error) {
+  var result butlerd.DownloadsScheduleSetResult
+  err := rc.Call("Downloads.Schedule.Set", params, &result)
+  return &result, err
+}
+
+var DownloadsScheduleSet *DownloadsScheduleSetType
+
 
 //==============================
 // Update
@@ -4299,6 +4688,9 @@ func EnsureAllRequests(router *butlerd.Router) {
   if _, ok := router.Handlers["Meta.Authenticate"]; !ok { panic("missing request handler for (Meta.Authenticate)") }
   if _, ok := router.Handlers["Meta.Flow"]; !ok { panic("missing request handler for (Meta.Flow)") }
   if _, ok := router.Handlers["Meta.Shutdown"]; !ok { panic("missing request handler for (Meta.Shutdown)") }
+  if _, ok := router.Handlers["Meta.Subscribe"]; !ok { panic("missing request handler for (Meta.Subscribe)") }
+  if _, ok := router.Handlers["Meta.Inspect"]; !ok { panic("missing request handler for (Meta.Inspect)") }
+  if _, ok := router.Handlers["Meta.Cancel"]; !ok { panic("missing request handler for (Meta.Cancel)") }
   if _, ok := router.Handlers["Version.Get"]; !ok { panic("missing request handler for (Version.Get)") }
   if _, ok := router.Handlers["Network.SetSimulateOffline"]; !ok { panic("missing request handler for (Network.SetSimulateOffline)") }
   if _, ok := router.Handlers["Network.SetBandwidthThrottle"]; !ok { panic("missing request handler for (Network.SetBandwidthThrottle)") }
@@ -4330,6 +4722,7 @@ func EnsureAllRequests(router *butlerd.Router) {
   if _, ok := router.Handlers["Fetch.GameOwnership"]; !ok { panic("missing request handler for (Fetch.GameOwnership)") }
   if _, ok := router.Handlers["Fetch.ProfileBundleOwnerships"]; !ok { panic("missing request handler for (Fetch.ProfileBundleOwnerships)") }
   if _, ok := router.Handlers["Fetch.Commons"]; !ok { panic("missing request handler for (Fetch.Commons)") }
+  if _, ok := router.Handlers["Fetch.PlayTime"]; !ok { panic("missing request handler for (Fetch.PlayTime)") }
   if _, ok := router.Handlers["Fetch.Caves"]; !ok { panic("missing request handler for (Fetch.Caves)") }
   if _, ok := router.Handlers["Fetch.Cave"]; !ok { panic("missing request handler for (Fetch.Cave)") }
   if _, ok := router.Handlers["Fetch.ExpireAll"]; !ok { panic("missing request handler for (Fetch.ExpireAll)") }
@@ -4341,6 +4734,7 @@ func EnsureAllRequests(router *butlerd.Router) {
   if _, ok := router.Handlers["Caves.GetSettings"]; !ok { panic("missing request handler for (Caves.GetSettings)") }
   if _, ok := router.Handlers["Caves.SetSettings"]; !ok { panic("missing request handler for (Caves.SetSettings)") }
   if _, ok := router.Handlers["Caves.SetPinned"]; !ok { panic("missing request handler for (Caves.SetPinned)") }
+  if _, ok := router.Handlers["Caves.Rollback"]; !ok { panic("missing request handler for (Caves.Rollback)") }
   if _, ok := router.Handlers["Install.CreateShortcut"]; !ok { panic("missing request handler for (Install.CreateShortcut)") }
   if _, ok := router.Handlers["Install.Perform"]; !ok { panic("missing request handler for (Install.Perform)") }
   if _, ok := router.Handlers["Install.Cancel"]; !ok { panic("missing request handler for (Install.Cancel)") }
@@ -4349,16 +4743,20 @@ func EnsureAllRequests(router *butlerd.Router) {
   if _, ok := router.Handlers["Install.Locations.List"]; !ok { panic("missing request handler for (Install.Locations.List)") }
   if _, ok := router.Handlers["Install.Locations.Add"]; !ok { panic("missing request handler for (Install.Locations.Add)") }
   if _, ok := router.Handlers["Install.Locations.Remove"]; !ok { panic("missing request handler for (Install.Locations.Remove)") }
+  if _, ok := router.Handlers["Install.Locations.SetRollbackRetention"]; !ok { panic("missing request handler for (Install.Locations.SetRollbackRetention)") }
   if _, ok := router.Handlers["Install.Locations.GetByID"]; !ok { panic("missing request handler for (Install.Locations.GetByID)") }
   if _, ok := router.Handlers["Install.Locations.Scan"]; !ok { panic("missing request handler for (Install.Locations.Scan)") }
   if _, ok := router.Handlers["Downloads.Queue"]; !ok { panic("missing request handler for (Downloads.Queue)") }
   if _, ok := router.Handlers["Downloads.Prioritize"]; !ok { panic("missing request handler for (Downloads.Prioritize)") }
   if _, ok := router.Handlers["Downloads.List"]; !ok { panic("missing request handler for (Downloads.List)") }
   if _, ok := router.Handlers["Downloads.ClearFinished"]; !ok { panic("missing request handler for (Downloads.ClearFinished)") }
+  if _, ok := router.Handlers["Downloads.History"]; !ok { panic("missing request handler for (Downloads.History)") }
   if _, ok := router.Handlers["Downloads.Drive"]; !ok { panic("missing request handler for (Downloads.Drive)") }
   if _, ok := router.Handlers["Downloads.Drive.Cancel"]; !ok { panic("missing request handler for (Downloads.Drive.Cancel)") }
   if _, ok := router.Handlers["Downloads.Retry"]; !ok { panic("missing request handler for (Downloads.Retry)") }
   if _, ok := router.Handlers["Downloads.Discard"]; !ok { panic("missing request handler for (Downloads.Discard)") }
+  if _, ok := router.Handlers["Downloads.Schedule.Get"]; !ok { panic("missing request handler for (Downloads.Schedule.Get)") }
+  if _, ok := router.Handlers["Downloads.Schedule.Set"]; !ok { panic("missing request handler for (Downloads.Schedule.Set)") }
   if _, ok := router.Handlers["CheckUpdate"]; !ok { panic("missing request handler for (CheckUpdate)") }
   if _, ok := router.Handlers["SnoozeCave"]; !ok { panic("missing request handler for (SnoozeCave)") }
   if _, ok := router.Handlers["Launch.GetTargets"]; !ok { panic("missing request handler for (Launch.GetTargets)") }
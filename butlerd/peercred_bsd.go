@@ -0,0 +1,35 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package butlerd
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// PeerCredentialsSupported is true on platforms where the unix socket
+// transport can tell which user is on the other end.
+const PeerCredentialsSupported = true
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, errors.WithStack(err)
+	}
+
+	var xucred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		xucred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return -1, errors.WithStack(err)
+	}
+	if credErr != nil {
+		return -1, errors.WithStack(credErr)
+	}
+	return int(xucred.Uid), nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package butlerd
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// PeerCredentialsSupported is true on platforms where the unix socket
+// transport can tell which user is on the other end.
+const PeerCredentialsSupported = false
+
+func peerUID(conn *net.UnixConn) (int, error) {
+	return -1, errors.New("peer credentials aren't supported on this platform")
+}
@@ -0,0 +1,287 @@
+package butlerd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRequestLogMaxSize is how large the request log gets
+	// before it's rotated
+	DefaultRequestLogMaxSize = 10 * 1024 * 1024
+	// DefaultRequestLogMaxBackups is how many rotated request logs
+	// are kept around, as `.1`, `.2`, etc.
+	DefaultRequestLogMaxBackups = 3
+)
+
+// RequestLog records requests, background tasks, their log messages,
+// the API calls they make and the calls they make back to the client,
+// as JSON lines, each tagged with the traceId of the request they're
+// part of.
+type RequestLog struct {
+	file   *rotatingFile
+	logger *slog.Logger
+}
+
+func NewRequestLog(path string, maxSize int64, maxBackups int) (*RequestLog, error) {
+	file, err := openRotatingFile(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestLog{
+		file: file,
+		logger: slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})),
+	}, nil
+}
+
+// TraceLogger returns a logger whose records are all tagged with traceID.
+// It's safe to call on a nil RequestLog, which returns a nil logger.
+func (rl *RequestLog) TraceLogger(traceID string) *slog.Logger {
+	if rl == nil {
+		return nil
+	}
+	return rl.logger.With("traceId", traceID)
+}
+
+func (rl *RequestLog) Close() error {
+	return rl.file.Close()
+}
+
+func newTraceID() string {
+	buf := make([]byte, 8)
+	_, err := rand.Read(buf)
+	if err != nil {
+		// only used to correlate log lines, uniqueness is nice-to-have
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// requestStats are shared between a request context and its forks
+type requestStats struct {
+	dbWait atomic.Int64
+}
+
+func (rs *requestStats) addDBWait(d time.Duration) {
+	rs.dbWait.Add(int64(d))
+}
+
+func (rs *requestStats) dbWaitTime() time.Duration {
+	return time.Duration(rs.dbWait.Load())
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000.0
+}
+
+// logMessage logs a message from a state.Consumer
+func logMessage(logger *slog.Logger, level string, msg string) {
+	if logger == nil {
+		return
+	}
+	logger.Log(context.Background(), consumerLevelToSlogLevel(level), msg)
+}
+
+func consumerLevelToSlogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// logTraced logs a request, background task or call to the client
+// that has completed
+func logTraced(logger *slog.Logger, kind string, name string, duration time.Duration, code int64, stats *requestStats) {
+	if logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", name),
+		slog.Float64("durationMs", durationMs(duration)),
+		slog.Int64("errorCode", code),
+	}
+	if stats != nil {
+		attrs = append(attrs, slog.Float64("dbWaitMs", durationMs(stats.dbWaitTime())))
+	}
+	level := slog.LevelInfo
+	if code != 0 {
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(context.Background(), level, kind, attrs...)
+}
+
+// teeHandler sends log records to several handlers
+type teeHandler []slog.Handler
+
+var _ slog.Handler = teeHandler(nil)
+
+func (th teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range th {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (th teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, h := range th {
+		if !h.Enabled(ctx, record.Level) {
+			continue
+		}
+		err := h.Handle(ctx, record.Clone())
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (th teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := make(teeHandler, len(th))
+	for i, h := range th {
+		res[i] = h.WithAttrs(attrs)
+	}
+	return res
+}
+
+func (th teeHandler) WithGroup(name string) slog.Handler {
+	res := make(teeHandler, len(th))
+	for i, h := range th {
+		res[i] = h.WithGroup(name)
+	}
+	return res
+}
+
+// rotatingFile is an append-only file that's renamed to `path.1` (and
+// previous backups shifted) whenever it grows past maxSize.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	lock sync.Mutex
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	stats, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.WithStack(err)
+	}
+
+	rf.file = file
+	rf.size = stats.Size()
+	return nil
+}
+
+func (rf *rotatingFile) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", rf.path, index)
+}
+
+// caller must hold lock
+func (rf *rotatingFile) rotate() error {
+	err := errors.WithStack(rf.file.Close())
+	rf.file = nil
+	if err == nil {
+		err = rf.shift()
+	}
+
+	// even if closing or shifting failed, keep logging (to the same file)
+	openErr := rf.open()
+	if err != nil {
+		return err
+	}
+	return openErr
+}
+
+func (rf *rotatingFile) shift() error {
+	if rf.maxBackups <= 0 {
+		return errors.WithStack(os.Remove(rf.path))
+	}
+
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(os.Rename(rf.path, rf.backupPath(1)))
+}
+
+// Write writes p in one go, so a line is never split between two files.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			// keep going in the same file, and only try again once
+			// it has grown by maxSize, rather than on every write
+			rf.size = 0
+		}
+	}
+
+	if rf.file == nil {
+		// it couldn't be reopened after rotating
+		err := rf.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Close()
+}
@@ -0,0 +1,200 @@
+package butlerd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+	itchio "github.com/itchio/go-itchio"
+)
+
+func readLogLines(t *testing.T, path string) []map[string]interface{} {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening log: %v", err)
+	}
+	defer f.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func Test_RotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := rf.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+	}
+
+	for suffix, expected := range map[string]string{
+		"":   "fourth\n",
+		".1": "third\n",
+		".2": "second\n",
+	} {
+		contents, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path+suffix, err)
+		}
+		if string(contents) != expected {
+			t.Fatalf("expected %s to contain %q, got %q", path+suffix, expected, string(contents))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 backups to be kept")
+	}
+}
+
+func Test_RotatingFileRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.log")
+	rf, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("opening: %v", err)
+	}
+	defer rf.Close()
+
+	// something is in the way of the backup
+	err = os.MkdirAll(filepath.Join(path+".1", "in-the-way"), 0o755)
+	if err != nil {
+		t.Fatalf("making directory: %v", err)
+	}
+
+	write := func(line string) {
+		_, err := rf.Write([]byte(line))
+		if err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+	}
+	for _, line := range []string{"aaa\n", "bbb\n", "ccc\n"} {
+		write(line)
+	}
+
+	// nothing is lost, and rotating isn't retried until the file has
+	// grown by maxSize again
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading: %v", err)
+	}
+	if string(contents) != "aaa\nbbb\nccc\n" {
+		t.Fatalf("expected every line to be written, got %q", string(contents))
+	}
+	if rf.size != 4 {
+		t.Fatalf("expected size to be reset after rotating failed, got %d", rf.size)
+	}
+
+	err = os.RemoveAll(path + ".1")
+	if err != nil {
+		t.Fatalf("removing directory: %v", err)
+	}
+	write("ddd\n")
+	write("eee\n")
+
+	contents, err = os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+	if string(contents) != "aaa\nbbb\nccc\nddd\n" {
+		t.Fatalf("expected rotating to be retried, got %q in backup", string(contents))
+	}
+}
+
+func Test_RouterRequestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.log")
+	requestLog, err := NewRequestLog(path, DefaultRequestLogMaxSize, DefaultRequestLogMaxBackups)
+	if err != nil {
+		t.Fatalf("opening request log: %v", err)
+	}
+	defer requestLog.Close()
+
+	r := NewRouter(nil, nil, nil, nil)
+	r.RequestLog = requestLog
+
+	var traceID string
+	r.Register("Test.Work", func(rc *RequestContext) (interface{}, error) {
+		traceID = rc.TraceID
+		rc.Consumer.Infof("Working hard")
+		return nil, nil
+	})
+
+	conn, _ := connPair(t)
+	_, err = r.HandleRequest(conn, jsonrpc2.Request{ID: 1, Method: "Test.Work"})
+	if err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	_, err = r.HandleRequest(conn, jsonrpc2.Request{ID: 2, Method: "Test.Missing"})
+	if err == nil {
+		t.Fatalf("expected unknown method to fail")
+	}
+	if !strings.Contains(string(*err.(*jsonrpc2.Error).Data), "traceId") {
+		t.Fatalf("expected error data to include the trace ID")
+	}
+
+	lines := readLogLines(t, path)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %d", len(lines))
+	}
+
+	if lines[0]["msg"] != "Working hard" || lines[0]["traceId"] != traceID {
+		t.Fatalf("unexpected consumer log line: %v", lines[0])
+	}
+	if lines[1]["msg"] != "request" || lines[1]["traceId"] != traceID || lines[1]["method"] != "Test.Work" || lines[1]["errorCode"] != 0.0 {
+		t.Fatalf("unexpected request log line: %v", lines[1])
+	}
+	for _, key := range []string{"durationMs", "dbWaitMs"} {
+		if _, ok := lines[1][key]; !ok {
+			t.Fatalf("expected request log line to have %s: %v", key, lines[1])
+		}
+	}
+	if lines[2]["method"] != "Test.Missing" || lines[2]["errorCode"] != float64(jsonrpc2.CodeMethodNotFound) || lines[2]["traceId"] == traceID {
+		t.Fatalf("unexpected failed request log line: %v", lines[2])
+	}
+}
+
+func Test_TracedClientKeepsExistingLogger(t *testing.T) {
+	var httpLog bytes.Buffer
+	r := NewRouter(nil, func(key string) *itchio.Client {
+		client := &itchio.Client{}
+		client.Logger = slog.New(slog.NewTextHandler(&httpLog, nil))
+		return client
+	}, nil, nil)
+
+	path := filepath.Join(t.TempDir(), "requests.log")
+	requestLog, err := NewRequestLog(path, DefaultRequestLogMaxSize, DefaultRequestLogMaxBackups)
+	if err != nil {
+		t.Fatalf("opening request log: %v", err)
+	}
+	defer requestLog.Close()
+
+	client := r.tracedClient(requestLog.TraceLogger("abc"))("key")
+	client.Logger.Info("api call")
+
+	if !strings.Contains(httpLog.String(), "api call") {
+		t.Fatalf("expected the client's own logger to still get records, got %q", httpLog.String())
+	}
+	lines := readLogLines(t, path)
+	if len(lines) != 1 || lines[0]["msg"] != "api call" || lines[0]["traceId"] != "abc" {
+		t.Fatalf("unexpected request log lines: %v", lines)
+	}
+}
@@ -3,7 +3,9 @@ package butlerd
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -28,16 +30,31 @@ import (
 	"github.com/pkg/errors"
 )
 
+type RequestID int64
+
+func (id RequestID) String() string {
+	return fmt.Sprintf("req-%d", int64(id))
+}
+
 type InFlightRequest struct {
 	DispatchedAt time.Time
 	Desc         string
+	Method       string
+
+	cancel context.CancelFunc
 }
 
 type BackgroundTaskID int64
 
+func (id BackgroundTaskID) String() string {
+	return fmt.Sprintf("task-%d", int64(id))
+}
+
 type InFlightBackgroundTask struct {
 	QueuedAt time.Time
 	Desc     string
+
+	cancel context.CancelFunc
 }
 
 type BackgroundTask struct {
@@ -54,11 +71,21 @@ type Router struct {
 	Handlers             map[string]RequestHandler
 	NotificationHandlers map[string]NotificationHandler
 	CancelFuncs          *CancelFuncs
+	Subscriptions        *Subscriptions
 	dbPool               *sqlitex.Pool
 	getClient            GetClientFunc
 	httpClient           *http.Client
 	httpTransport        *http.Transport
 
+	// WebAddress and APIAddress are the itch.io instance
+	// butler was started with (see `--address`)
+	WebAddress string
+	APIAddress string
+
+	// When set, requests and background tasks are logged there
+	// (see `--log`)
+	RequestLog *RequestLog
+
 	Group                *singleflight.Group
 	ShutdownChan         chan struct{}
 	initiateShutdownOnce sync.Once
@@ -67,10 +94,12 @@ type Router struct {
 	backgroundContext    context.Context
 	backgroundCancel     context.CancelFunc
 
-	inflightRequests        map[jsonrpc2.ID]InFlightRequest
+	// keyed by our own IDs, since JSON-RPC IDs are only unique per connection
+	inflightRequests        map[RequestID]InFlightRequest
 	inflightBackgroundTasks map[BackgroundTaskID]InFlightBackgroundTask
 	inflightLock            sync.Mutex
 
+	requestIDSeed        RequestID
 	backgroundTaskIDSeed BackgroundTaskID
 
 	globalConsumer *state.Consumer
@@ -83,6 +112,7 @@ func NewRouter(dbPool *sqlitex.Pool, getClient GetClientFunc, httpClient *http.C
 		Handlers:             make(map[string]RequestHandler),
 		NotificationHandlers: make(map[string]NotificationHandler),
 		CancelFuncs:          NewCancelFuncs(),
+		Subscriptions:        NewSubscriptions(),
 		dbPool:               dbPool,
 		getClient:            getClient,
 		httpClient:           httpClient,
@@ -91,12 +121,13 @@ func NewRouter(dbPool *sqlitex.Pool, getClient GetClientFunc, httpClient *http.C
 		backgroundContext: backgroundContext,
 		backgroundCancel:  backgroundCancel,
 
-		inflightRequests:        make(map[jsonrpc2.ID]InFlightRequest),
+		inflightRequests:        make(map[RequestID]InFlightRequest),
 		inflightBackgroundTasks: make(map[BackgroundTaskID]InFlightBackgroundTask),
 
 		Group:        &singleflight.Group{},
 		ShutdownChan: make(chan struct{}),
 
+		requestIDSeed:        0,
 		backgroundTaskIDSeed: 0,
 
 		globalConsumer: &state.Consumer{
@@ -132,12 +163,19 @@ func (r *Router) numInflightItems() int {
 }
 
 // caller must hold inflightLock
-func (r *Router) onRequestStarted(id jsonrpc2.ID, req InFlightRequest) {
+func (r *Router) generateRequestID() RequestID {
+	id := r.requestIDSeed
+	r.requestIDSeed += 1
+	return id
+}
+
+// caller must hold inflightLock
+func (r *Router) onRequestStarted(id RequestID, req InFlightRequest) {
 	r.inflightRequests[id] = req
 }
 
 // caller must hold inflightLock
-func (r *Router) onRequestFinished(id jsonrpc2.ID) {
+func (r *Router) onRequestFinished(id RequestID) {
 	delete(r.inflightRequests, id)
 	if r.shuttingDown {
 		r.globalConsumer.Infof("While shutting down, request %v has completed", id)
@@ -207,24 +245,38 @@ func (r *Router) HandleNotification(conn jsonrpc2.Conn, notif jsonrpc2.Notificat
 }
 
 func (r *Router) HandleRequest(conn jsonrpc2.Conn, req jsonrpc2.Request) (interface{}, error) {
+	// each request can be cancelled on its own, see Meta.Cancel. Handlers
+	// that leave goroutines behind must derive their own context.
+	ctx, cancel := context.WithCancel(conn.Context())
+	defer cancel()
+
 	r.inflightLock.Lock()
-	r.onRequestStarted(req.ID, InFlightRequest{
+	inflightID := r.generateRequestID()
+	r.onRequestStarted(inflightID, InFlightRequest{
 		DispatchedAt: time.Now().UTC(),
 		Desc:         fmt.Sprintf("[req %v] %s", req.ID, req.Method),
+		Method:       req.Method,
+		cancel:       cancel,
 	})
 	r.inflightLock.Unlock()
 
 	defer func() {
 		r.inflightLock.Lock()
-		r.onRequestFinished(req.ID)
+		r.onRequestFinished(inflightID)
 		r.inflightLock.Unlock()
 	}()
 
 	method := req.Method
 	var res interface{}
 
+	traceID := newTraceID()
+	traceLogger := r.RequestLog.TraceLogger(traceID)
+	stats := &requestStats{}
+	startedAt := time.Now()
+
 	consumer, cErr := NewStateConsumer(&NewStateConsumerParams{
-		Conn: conn,
+		Conn:   conn,
+		Logger: traceLogger,
 	})
 	if cErr != nil {
 		return nil, cErr
@@ -242,65 +294,36 @@ func (r *Router) HandleRequest(conn jsonrpc2.Conn, req jsonrpc2.Request) (interf
 		}()
 
 		rc := &RequestContext{
-			Ctx:         conn.Context(),
+			Ctx:         ctx,
 			Consumer:    consumer,
 			Params:      req.Params,
 			Conn:        conn,
 			CancelFuncs: r.CancelFuncs,
 			dbPool:      r.dbPool,
-			Client:      r.getClient,
+			Client:      r.tracedClient(traceLogger),
+			TraceID:     traceID,
+
+			Subscriptions: r.Subscriptions,
 
 			HTTPClient:    r.httpClient,
 			HTTPTransport: r.httpTransport,
+			WebAddress:    r.WebAddress,
+			APIAddress:    r.APIAddress,
 
 			Group:    r.Group,
 			Shutdown: r.initiateShutdown,
 
-			method: method,
+			method:      method,
+			inflightID:  inflightID.String(),
+			traceLogger: traceLogger,
+			stats:       stats,
 
 			QueueBackgroundTask: r.QueueBackgroundTask,
 		}
 
 		{
 			if h, ok := r.Handlers[method]; ok {
-				rc.Consumer.OnProgress = func(alpha float64) {
-					if rc.tracker == nil {
-						// skip
-						return
-					}
-
-					rc.tracker.SetProgress(alpha)
-					notif := ProgressNotification{
-						Progress: alpha,
-					}
-					stats := rc.tracker.Stats()
-					if stats != nil {
-						if stats.TimeLeft() != nil {
-							notif.ETA = stats.TimeLeft().Seconds()
-						}
-						if stats.BPS() != nil {
-							notif.BPS = stats.BPS().Value
-						} else {
-							notif.BPS = timeout.GetBPS()
-						}
-					}
-					// cannot use autogenerated wrappers to avoid import cycles
-					rc.Notify("Progress", notif)
-				}
-				rc.Consumer.OnProgressLabel = func(label string) {
-					// muffin
-				}
-				rc.Consumer.OnPauseProgress = func() {
-					if rc.tracker != nil {
-						rc.tracker.Pause()
-					}
-				}
-				rc.Consumer.OnResumeProgress = func() {
-					if rc.tracker != nil {
-						rc.tracker.Resume()
-					}
-				}
-
+				rc.bindProgress()
 				res, err = h(rc)
 			} else {
 				err = &RpcError{
@@ -313,6 +336,7 @@ func (r *Router) HandleRequest(conn jsonrpc2.Conn, req jsonrpc2.Request) (interf
 	}()
 
 	if err == nil {
+		logTraced(traceLogger, "request", method, time.Since(startedAt), 0, stats)
 		return res, nil
 	}
 
@@ -347,6 +371,9 @@ func (r *Router) HandleRequest(conn jsonrpc2.Conn, req jsonrpc2.Request) (interf
 		code = int64(CodeAPIError)
 		data["apiError"] = ae
 	}
+	data["traceId"] = traceID
+
+	logTraced(traceLogger, "request", method, time.Since(startedAt), code, stats)
 
 	var rpcErr = &jsonrpc2.Error{
 		Code:    code,
@@ -360,7 +387,7 @@ func (r *Router) HandleRequest(conn jsonrpc2.Conn, req jsonrpc2.Request) (interf
 	return nil, rpcErr
 }
 
-func (r *Router) doBackgroundTask(id BackgroundTaskID, bt BackgroundTask) {
+func (r *Router) doBackgroundTask(ctx context.Context, id BackgroundTaskID, bt BackgroundTask) {
 	defer func() {
 		router := r
 		if r := recover(); r != nil {
@@ -374,23 +401,45 @@ func (r *Router) doBackgroundTask(id BackgroundTaskID, bt BackgroundTask) {
 		r.inflightLock.Unlock()
 	}()
 
+	traceID := newTraceID()
+	traceLogger := r.RequestLog.TraceLogger(traceID)
+	stats := &requestStats{}
+	startedAt := time.Now()
+
 	consumer := r.globalConsumer
+	if traceLogger != nil {
+		consumer = &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				r.globalConsumer.OnMessage(lvl, msg)
+				logMessage(traceLogger, lvl, msg)
+			},
+		}
+	}
+
 	rc := &RequestContext{
-		Ctx:         r.backgroundContext,
+		Ctx:         ctx,
 		Consumer:    consumer,
 		Params:      nil,
 		Conn:        nil,
 		CancelFuncs: r.CancelFuncs,
 		dbPool:      r.dbPool,
-		Client:      r.getClient,
+		Client:      r.tracedClient(traceLogger),
+		TraceID:     traceID,
+
+		Subscriptions: r.Subscriptions,
 
 		HTTPClient:    r.httpClient,
 		HTTPTransport: r.httpTransport,
+		WebAddress:    r.WebAddress,
+		APIAddress:    r.APIAddress,
 
 		Group:    r.Group,
 		Shutdown: r.initiateShutdown,
 
-		method: "",
+		method:      "",
+		inflightID:  id.String(),
+		traceLogger: traceLogger,
+		stats:       stats,
 
 		QueueBackgroundTask: r.QueueBackgroundTask,
 	}
@@ -400,21 +449,116 @@ func (r *Router) doBackgroundTask(id BackgroundTaskID, bt BackgroundTask) {
 		consumer.Debugf("Executing background task %d: %s", id, bt.Desc)
 		return bt.Do(rc)
 	}()
+	var code int64
 	if err != nil {
 		consumer.Warnf("Background task error: %+v", err)
+		code = jsonrpc2.CodeInternalError
+	}
+	logTraced(traceLogger, "task", bt.Desc, time.Since(startedAt), code, stats)
+}
+
+// tracedClient returns a GetClientFunc whose clients log their API calls
+// to logger, if any, on top of whatever logger they already had (when
+// --log-http is passed, for example).
+func (r *Router) tracedClient(logger *slog.Logger) GetClientFunc {
+	if logger == nil {
+		return r.getClient
+	}
+
+	return func(key string) *itchio.Client {
+		client := r.getClient(key)
+		if client.Logger == nil {
+			client.Logger = logger
+		} else {
+			client.Logger = slog.New(teeHandler{client.Logger.Handler(), logger.Handler()})
+		}
+		return client
 	}
 }
 
 func (r *Router) QueueBackgroundTask(bt BackgroundTask) {
+	ctx, cancel := context.WithCancel(r.backgroundContext)
+
 	r.inflightLock.Lock()
 	id := r.generateBackgroundTaskID()
 	r.onBackgroundTaskQueued(id, InFlightBackgroundTask{
 		QueuedAt: time.Now().UTC(),
 		Desc:     fmt.Sprintf("[task %d] %s", id, bt.Desc),
+		cancel:   cancel,
 	})
 	r.inflightLock.Unlock()
 
-	go r.doBackgroundTask(id, bt)
+	go func() {
+		defer cancel()
+		r.doBackgroundTask(ctx, id, bt)
+	}()
+}
+
+// Inspect lists in-flight requests and background tasks, oldest first,
+// along with the cancel IDs they registered.
+func (r *Router) Inspect() *MetaInspectResult {
+	now := time.Now().UTC()
+	cancelIDs := r.CancelFuncs.idsByOwner()
+
+	r.inflightLock.Lock()
+	defer r.inflightLock.Unlock()
+
+	res := &MetaInspectResult{
+		Requests:        []*InFlightRequestInfo{},
+		BackgroundTasks: []*InFlightBackgroundTaskInfo{},
+	}
+	for id, req := range r.inflightRequests {
+		res.Requests = append(res.Requests, &InFlightRequestInfo{
+			ID:        id.String(),
+			Method:    req.Method,
+			StartedAt: req.DispatchedAt,
+			Age:       now.Sub(req.DispatchedAt).Seconds(),
+			CancelIDs: cancelIDs[id.String()],
+		})
+	}
+	for id, task := range r.inflightBackgroundTasks {
+		res.BackgroundTasks = append(res.BackgroundTasks, &InFlightBackgroundTaskInfo{
+			ID:        id.String(),
+			Desc:      task.Desc,
+			QueuedAt:  task.QueuedAt,
+			Age:       now.Sub(task.QueuedAt).Seconds(),
+			CancelIDs: cancelIDs[id.String()],
+		})
+	}
+
+	sort.Slice(res.Requests, func(i, j int) bool {
+		return res.Requests[i].StartedAt.Before(res.Requests[j].StartedAt)
+	})
+	sort.Slice(res.BackgroundTasks, func(i, j int) bool {
+		return res.BackgroundTasks[i].QueuedAt.Before(res.BackgroundTasks[j].QueuedAt)
+	})
+	return res
+}
+
+// CancelInFlight cancels a request or background task, given its ID as
+// listed by Inspect, or one of the cancel IDs registered in CancelFuncs.
+// It returns false if nothing matched.
+func (r *Router) CancelInFlight(id string) bool {
+	if r.CancelFuncs.Call(id) {
+		return true
+	}
+
+	r.inflightLock.Lock()
+	defer r.inflightLock.Unlock()
+
+	for reqID, req := range r.inflightRequests {
+		if reqID.String() == id {
+			req.cancel()
+			return true
+		}
+	}
+	for taskID, task := range r.inflightBackgroundTasks {
+		if taskID.String() == id {
+			task.cancel()
+			return true
+		}
+	}
+	return false
 }
 
 func (r *Router) Logf(format string, args ...interface{}) {
@@ -431,12 +575,21 @@ type RequestContext struct {
 
 	HTTPClient    *http.Client
 	HTTPTransport *http.Transport
+	WebAddress    string
+	APIAddress    string
 
 	Params      *json.RawMessage
 	Conn        jsonrpc2.Conn
 	CancelFuncs *CancelFuncs
 	dbPool      *sqlitex.Pool
 
+	// Tags everything logged on behalf of this request in the request log,
+	// and is included in error data
+	TraceID string
+
+	// Subscriptions receive notifications sent from any connection
+	Subscriptions *Subscriptions
+
 	Group    *singleflight.Group
 	Shutdown func()
 
@@ -444,14 +597,114 @@ type RequestContext struct {
 	tracker                  tracker.Tracker
 
 	method string
+	// the request or background task this context belongs to,
+	// see Router.Inspect
+	inflightID string
+
+	// nil unless the request log is enabled
+	traceLogger *slog.Logger
+	stats       *requestStats
 }
 
 type WithParamsFunc func() (interface{}, error)
 
+// bindProgress routes the consumer's progress callbacks through
+// this context's tracker, as "Progress" notifications.
+func (rc *RequestContext) bindProgress() {
+	rc.Consumer.OnProgress = func(alpha float64) {
+		if rc.tracker == nil {
+			// skip
+			return
+		}
+
+		rc.tracker.SetProgress(alpha)
+		notif := ProgressNotification{
+			Progress: alpha,
+		}
+		stats := rc.tracker.Stats()
+		if stats != nil {
+			if stats.TimeLeft() != nil {
+				notif.ETA = stats.TimeLeft().Seconds()
+			}
+			if stats.BPS() != nil {
+				notif.BPS = stats.BPS().Value
+			} else {
+				notif.BPS = timeout.GetBPS()
+			}
+		}
+		// cannot use autogenerated wrappers to avoid import cycles
+		rc.Notify("Progress", notif)
+	}
+	rc.Consumer.OnProgressLabel = func(label string) {
+		// muffin
+	}
+	rc.Consumer.OnPauseProgress = func() {
+		if rc.tracker != nil {
+			rc.tracker.Pause()
+		}
+	}
+	rc.Consumer.OnResumeProgress = func() {
+		if rc.tracker != nil {
+			rc.tracker.Resume()
+		}
+	}
+}
+
+// Fork returns a copy of this request context bound to ctx, with its own
+// consumer, progress tracker and notification interceptors, so that several
+// operations can run concurrently on behalf of the same request.
+func (rc *RequestContext) Fork(ctx context.Context) *RequestContext {
+	fork := &RequestContext{
+		Ctx: ctx,
+		Consumer: &state.Consumer{
+			OnMessage: rc.Consumer.OnMessage,
+		},
+		Client:              rc.Client,
+		QueueBackgroundTask: rc.QueueBackgroundTask,
+
+		HTTPClient:    rc.HTTPClient,
+		HTTPTransport: rc.HTTPTransport,
+		WebAddress:    rc.WebAddress,
+		APIAddress:    rc.APIAddress,
+
+		Params:      rc.Params,
+		Conn:        rc.Conn,
+		CancelFuncs: rc.CancelFuncs,
+		dbPool:      rc.dbPool,
+		TraceID:     rc.TraceID,
+
+		Subscriptions: rc.Subscriptions,
+
+		Group:    rc.Group,
+		Shutdown: rc.Shutdown,
+
+		method:      rc.method,
+		inflightID:  rc.inflightID,
+		traceLogger: rc.traceLogger,
+		stats:       rc.stats,
+	}
+	fork.bindProgress()
+	return fork
+}
+
 type NotificationInterceptor func(method string, params interface{}) error
 
 func (rc *RequestContext) Call(method string, params interface{}, res interface{}) error {
-	return rc.Conn.Call(method, params, res)
+	startedAt := time.Now()
+	err := rc.Conn.Call(method, params, res)
+
+	if rc.traceLogger != nil {
+		var code int64
+		if err != nil {
+			code = jsonrpc2.CodeInternalError
+			var rpcErr *jsonrpc2.Error
+			if errors.As(err, &rpcErr) {
+				code = rpcErr.Code
+			}
+		}
+		logTraced(rc.traceLogger, "call", method, time.Since(startedAt), code, nil)
+	}
+	return err
 }
 
 func (rc *RequestContext) InterceptNotification(method string, interceptor NotificationInterceptor) {
@@ -474,6 +727,14 @@ func (rc *RequestContext) Notify(method string, params interface{}) error {
 			return ni(method, params)
 		}
 	}
+
+	if rc.Subscriptions != nil {
+		rc.Subscriptions.Broadcast(rc.Conn, method, params)
+	}
+	if rc.Conn == nil {
+		// background tasks have no connection of their own
+		return nil
+	}
 	return rc.Conn.Notify(method, params)
 }
 
@@ -535,7 +796,11 @@ func (rc *RequestContext) EndProgress() {
 func (rc *RequestContext) GetConn() *sqlite.Conn {
 	getCtx, cancel := context.WithTimeout(rc.Ctx, 3*time.Second)
 	defer cancel()
+	waitStartedAt := time.Now()
 	conn := rc.dbPool.Get(getCtx)
+	if rc.stats != nil {
+		rc.stats.addDBWait(time.Since(waitStartedAt))
+	}
 	if conn == nil {
 		panic(errors.WithStack(CodeDatabaseBusy))
 	}
@@ -574,7 +839,7 @@ func (rc *RequestContext) MakeCancelable(id string) (context.Context, func()) {
 	rc.Ctx = ctx
 
 	if id != "" && rc.CancelFuncs != nil {
-		rc.CancelFuncs.Add(id, cancelFunc)
+		rc.CancelFuncs.add(id, rc.inflightID, cancelFunc)
 	}
 
 	var once sync.Once
@@ -592,20 +857,46 @@ func (rc *RequestContext) MakeCancelable(id string) (context.Context, func()) {
 }
 
 type CancelFuncs struct {
-	funcs map[string]context.CancelFunc
+	funcs map[string]cancelFunc
 	lock  sync.Mutex
 }
 
+type cancelFunc struct {
+	// the request or background task that registered it, if known
+	owner  string
+	cancel context.CancelFunc
+}
+
 func NewCancelFuncs() *CancelFuncs {
 	return &CancelFuncs{
-		funcs: make(map[string]context.CancelFunc),
+		funcs: make(map[string]cancelFunc),
 	}
 }
 
 func (cf *CancelFuncs) Add(id string, f context.CancelFunc) {
+	cf.add(id, "", f)
+}
+
+func (cf *CancelFuncs) add(id string, owner string, f context.CancelFunc) {
 	cf.lock.Lock()
 	defer cf.lock.Unlock()
-	cf.funcs[id] = f
+	cf.funcs[id] = cancelFunc{owner: owner, cancel: f}
+}
+
+// idsByOwner returns registered cancel IDs, sorted, grouped by the
+// request or background task that registered them.
+func (cf *CancelFuncs) idsByOwner() map[string][]string {
+	cf.lock.Lock()
+	defer cf.lock.Unlock()
+
+	res := make(map[string][]string)
+	for id, f := range cf.funcs {
+		res[f.owner] = append(res[f.owner], id)
+	}
+	for _, ids := range res {
+		sort.Strings(ids)
+	}
+	return res
 }
 
 func (cf *CancelFuncs) Remove(id string) {
@@ -626,6 +917,6 @@ func (cf *CancelFuncs) Call(id string) bool {
 		return false
 	}
 
-	f()
+	f.cancel()
 	return true
 }
@@ -0,0 +1,127 @@
+package butlerd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+)
+
+func Test_RouterInspectAndCancel(t *testing.T) {
+	r := NewRouter(nil, nil, nil, nil)
+
+	started := make(chan struct{}, 2)
+	r.Register("Test.Block", func(rc *RequestContext) (interface{}, error) {
+		_, cleanup := rc.MakeCancelable("my-install")
+		defer cleanup()
+
+		started <- struct{}{}
+		<-rc.Ctx.Done()
+		return nil, nil
+	})
+	r.Register("Test.BlockUncancelable", func(rc *RequestContext) (interface{}, error) {
+		started <- struct{}{}
+		<-rc.Ctx.Done()
+		return nil, nil
+	})
+
+	conn, _ := connPair(t)
+	done := make(chan string, 2)
+	for _, method := range []string{"Test.Block", "Test.BlockUncancelable"} {
+		method := method
+		go func() {
+			r.HandleRequest(conn, jsonrpc2.Request{ID: 0, Method: method})
+			done <- method
+		}()
+		<-started
+	}
+
+	taskStarted := make(chan struct{})
+	r.QueueBackgroundTask(BackgroundTask{
+		Desc: "Block forever",
+		Do: func(rc *RequestContext) error {
+			close(taskStarted)
+			<-rc.Ctx.Done()
+			return nil
+		},
+	})
+	<-taskStarted
+
+	res := r.Inspect()
+	if len(res.Requests) != 2 {
+		t.Fatalf("expected 2 in-flight requests (despite sharing a JSON-RPC ID), got %d", len(res.Requests))
+	}
+	first, second := res.Requests[0], res.Requests[1]
+	if first.ID != "req-0" || first.Method != "Test.Block" || len(first.CancelIDs) != 1 || first.CancelIDs[0] != "my-install" {
+		t.Fatalf("unexpected first request: %+v", first)
+	}
+	if second.ID != "req-1" || second.Method != "Test.BlockUncancelable" || len(second.CancelIDs) != 0 {
+		t.Fatalf("unexpected second request: %+v", second)
+	}
+	if len(res.BackgroundTasks) != 1 || res.BackgroundTasks[0].ID != "task-0" {
+		t.Fatalf("unexpected background tasks: %+v", res.BackgroundTasks)
+	}
+
+	expectDone := func(method string) {
+		select {
+		case got := <-done:
+			if got != method {
+				t.Fatalf("expected %s to finish, got %s", method, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s to be cancelled", method)
+		}
+	}
+
+	if r.CancelInFlight("nope") {
+		t.Fatalf("expected cancelling an unknown ID to fail")
+	}
+
+	// by cancel ID
+	if !r.CancelInFlight("my-install") {
+		t.Fatalf("expected cancelling by cancel ID to succeed")
+	}
+	expectDone("Test.Block")
+
+	// by request ID
+	if !r.CancelInFlight("req-1") {
+		t.Fatalf("expected cancelling by request ID to succeed")
+	}
+	expectDone("Test.BlockUncancelable")
+
+	// by task ID
+	if !r.CancelInFlight("task-0") {
+		t.Fatalf("expected cancelling by task ID to succeed")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.Inspect().BackgroundTasks) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for background task to be cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(r.Inspect().Requests) != 0 {
+		t.Fatalf("expected no in-flight requests left")
+	}
+}
+
+func Test_RouterCancelsRequestContextOnReturn(t *testing.T) {
+	r := NewRouter(nil, nil, nil, nil)
+
+	var ctx context.Context
+	r.Register("Test.Quick", func(rc *RequestContext) (interface{}, error) {
+		ctx = rc.Ctx
+		return nil, nil
+	})
+
+	conn, _ := connPair(t)
+	_, err := r.HandleRequest(conn, jsonrpc2.Request{ID: 0, Method: "Test.Quick"})
+	if err != nil {
+		t.Fatalf("handling request: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatalf("expected request context to be cancelled once the request returned")
+	}
+}
@@ -0,0 +1,83 @@
+package butlerd
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+)
+
+// Subscriptions keeps track of which connections asked (via Meta.Subscribe)
+// to receive which notifications, no matter which connection the request
+// that sends them came from.
+type Subscriptions struct {
+	topics map[jsonrpc2.Conn]map[string]bool
+	lock   sync.Mutex
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{
+		topics: make(map[jsonrpc2.Conn]map[string]bool),
+	}
+}
+
+// Set replaces the topics conn is subscribed to. Passing no topics
+// unsubscribes it entirely. Subscriptions end when the connection closes.
+func (s *Subscriptions) Set(conn jsonrpc2.Conn, topics []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(topics) == 0 {
+		delete(s.topics, conn)
+		return
+	}
+
+	if _, ok := s.topics[conn]; !ok {
+		go func() {
+			<-conn.Context().Done()
+			s.lock.Lock()
+			delete(s.topics, conn)
+			s.lock.Unlock()
+		}()
+	}
+
+	set := make(map[string]bool)
+	for _, topic := range topics {
+		set[topic] = true
+	}
+	s.topics[conn] = set
+}
+
+// Topics returns the topics conn is subscribed to, sorted.
+func (s *Subscriptions) Topics(conn jsonrpc2.Conn) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	topics := []string{}
+	for topic := range s.topics[conn] {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Broadcast sends a notification to all connections subscribed to it,
+// except origin, which already received it.
+func (s *Subscriptions) Broadcast(origin jsonrpc2.Conn, method string, params interface{}) {
+	var conns []jsonrpc2.Conn
+	s.lock.Lock()
+	for conn, set := range s.topics {
+		if conn != origin && set[method] {
+			conns = append(conns, conn)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, conn := range conns {
+		err := conn.Notify(method, params)
+		if err != nil {
+			log.Printf("Could not broadcast %s notification: %+v", method, err)
+		}
+	}
+}
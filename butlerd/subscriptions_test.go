@@ -0,0 +1,96 @@
+package butlerd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+)
+
+// connPair returns the server side of a connection, and a channel
+// receiving the methods of notifications the client side gets.
+func connPair(t *testing.T) (jsonrpc2.Conn, chan string) {
+	serverSide, clientSide := net.Pipe()
+	notifs := make(chan string, 16)
+
+	server := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewRwcTransport(serverSide), &testHandler{})
+	client := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewRwcTransport(clientSide), &testHandler{
+		handleNotification: func(conn jsonrpc2.Conn, notif jsonrpc2.Notification) {
+			notifs <- notif.Method
+		},
+	})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, notifs
+}
+
+func expectNotification(t *testing.T, notifs chan string, method string) {
+	select {
+	case got := <-notifs:
+		if got != method {
+			t.Fatalf("expected %s notification, got %s", method, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s notification", method)
+	}
+}
+
+func expectNoNotification(t *testing.T, notifs chan string) {
+	select {
+	case got := <-notifs:
+		t.Fatalf("expected no notification, got %s", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_SubscriptionsBroadcast(t *testing.T) {
+	subs := NewSubscriptions()
+
+	origin, originNotifs := connPair(t)
+	tray, trayNotifs := connPair(t)
+	other, otherNotifs := connPair(t)
+
+	subs.Set(origin, []string{"LaunchRunning"})
+	subs.Set(tray, []string{"LaunchRunning", "Downloads.Drive.Progress"})
+
+	topics := subs.Topics(tray)
+	if len(topics) != 2 || topics[0] != "Downloads.Drive.Progress" || topics[1] != "LaunchRunning" {
+		t.Fatalf("unexpected topics: %v", topics)
+	}
+
+	// the origin connection gets the notification directly, not twice
+	subs.Broadcast(origin, "LaunchRunning", struct{}{})
+	expectNotification(t, trayNotifs, "LaunchRunning")
+	expectNoNotification(t, originNotifs)
+	expectNoNotification(t, otherNotifs)
+
+	// only subscribed topics are broadcast
+	subs.Broadcast(other, "GameUpdateAvailable", struct{}{})
+	expectNoNotification(t, trayNotifs)
+
+	// background tasks have no connection
+	subs.Broadcast(nil, "Downloads.Drive.Progress", struct{}{})
+	expectNotification(t, trayNotifs, "Downloads.Drive.Progress")
+
+	subs.Set(tray, nil)
+	if len(subs.Topics(tray)) != 0 {
+		t.Fatalf("expected empty topic list to unsubscribe")
+	}
+	subs.Broadcast(other, "LaunchRunning", struct{}{})
+	expectNotification(t, originNotifs, "LaunchRunning")
+	expectNoNotification(t, trayNotifs)
+
+	// closing a connection ends its subscriptions
+	origin.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(subs.Topics(origin)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for subscriptions of closed connection to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,6 +2,7 @@ package butlerd
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/itchio/hush"
@@ -11,7 +12,7 @@ import (
 	itchio "github.com/itchio/go-itchio"
 )
 
-// When using TCP transport, must be the first message sent
+// When using the TCP or WebSocket transports, must be the first message sent
 //
 // @name Meta.Authenticate
 // @category Utilities
@@ -67,6 +68,108 @@ func (p MetaShutdownParams) Validate() error {
 type MetaShutdownResult struct {
 }
 
+// Subscribe this connection to notifications, no matter which
+// connection sent the request they're about. For example, a tray icon
+// can subscribe to `Downloads.Drive.Progress`, `LaunchRunning` and
+// `GameUpdateAvailable` while another client drives downloads and
+// launches.
+//
+// Each call replaces the previous set of topics. Subscriptions end
+// when the connection closes. Most useful with `--keep-alive`.
+//
+// @name Meta.Subscribe
+// @category Utilities
+// @caller client
+type MetaSubscribeParams struct {
+	// Notification names (for example `LaunchRunning`) to receive. An
+	// empty list unsubscribes from everything.
+	Topics []string `json:"topics"`
+}
+
+func (p MetaSubscribeParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Topics, validation.Each(validation.Required)),
+	)
+}
+
+type MetaSubscribeResult struct {
+	// The topics this connection is now subscribed to
+	Topics []string `json:"topics"`
+}
+
+// Lists the requests (from all connections) and background tasks
+// butlerd is currently working on, to help debug clients that seem
+// stuck. Any of them can be cancelled with @@MetaCancelParams.
+//
+// @name Meta.Inspect
+// @category Utilities
+// @caller client
+type MetaInspectParams struct {
+}
+
+func (p MetaInspectParams) Validate() error {
+	return nil
+}
+
+type MetaInspectResult struct {
+	// Requests being handled, oldest first
+	Requests []*InFlightRequestInfo `json:"requests"`
+	// Background tasks queued or running, oldest first
+	BackgroundTasks []*InFlightBackgroundTaskInfo `json:"backgroundTasks"`
+}
+
+// A request butlerd is currently handling
+type InFlightRequestInfo struct {
+	// Identifies the request for @@MetaCancelParams, like `req-12`
+	ID string `json:"id"`
+	// The JSON-RPC method, like `Install.Perform`
+	Method string `json:"method"`
+	// When butlerd started handling the request
+	StartedAt time.Time `json:"startedAt"`
+	// How long ago the request started, in seconds
+	Age float64 `json:"age"`
+	// IDs the request registered for cancellation, like the
+	// one passed to @@InstallCancelParams
+	CancelIDs []string `json:"cancelIds"`
+}
+
+// A background task butlerd is currently running
+type InFlightBackgroundTaskInfo struct {
+	// Identifies the task for @@MetaCancelParams, like `task-3`
+	ID string `json:"id"`
+	// What the task is doing
+	Desc string `json:"desc"`
+	// When the task was queued
+	QueuedAt time.Time `json:"queuedAt"`
+	// How long ago the task was queued, in seconds
+	Age float64 `json:"age"`
+	// IDs the task registered for cancellation
+	CancelIDs []string `json:"cancelIds"`
+}
+
+// Cancels a request or background task listed by @@MetaInspectParams.
+// Cancelling `Meta.Flow` shuts down the daemon, as usual.
+//
+// @name Meta.Cancel
+// @category Utilities
+// @caller client
+type MetaCancelParams struct {
+	// A request or task ID (like `req-12` or `task-3`), or one
+	// of their cancel IDs
+	ID string `json:"id"`
+}
+
+func (p MetaCancelParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ID, validation.Required),
+	)
+}
+
+type MetaCancelResult struct {
+	// False if nothing in flight had that ID
+	DidCancel bool `json:"didCancel"`
+}
+
 // The first notification sent when @@MetaFlowParams is called.
 //
 // @category Utilities
@@ -450,6 +553,12 @@ type SearchUsersResult struct {
 // collections are scoped to the given profile: only bundles the profile
 // owns and collections in the profile's collection list are returned.
 //
+// By default, returns a few title matches of each kind. When `ranked`
+// is set, performs a full-text search over game titles, short texts,
+// classifications and types, developer names and collection names
+// instead, and returns a single paginated list in `items`, best matches
+// first.
+//
 // @name Search.Local
 // @category Search
 // @caller client
@@ -458,15 +567,40 @@ type SearchLocalParams struct {
 	ProfileID int64 `json:"profileId"`
 
 	Query string `json:"query"`
+
+	// Return a ranked, paginated list of matches in `items`
+	// @optional
+	Ranked bool `json:"ranked"`
+
+	// Maximum number of items to return at a time, when ranked.
+	// Defaults to 20.
+	// @optional
+	Limit int64 `json:"limit"`
+
+	// Used for pagination, when ranked
+	// @optional
+	Cursor Cursor `json:"cursor"`
 }
 
 func (p SearchLocalParams) Validate() error {
 	return validation.ValidateStruct(&p,
 		validation.Field(&p.ProfileID, validation.Required),
 		validation.Field(&p.Query, validation.Required),
+		validation.Field(&p.Limit, validation.Min(int64(0))),
 	)
 }
 
+func (p SearchLocalParams) GetCursor() Cursor {
+	return p.Cursor
+}
+
+func (p SearchLocalParams) GetLimit() int64 {
+	if p.Limit == 0 {
+		return 20
+	}
+	return p.Limit
+}
+
 type SearchLocalResult struct {
 	// Locally-cached games matching the query
 	Games []*itchio.Game `json:"games"`
@@ -476,6 +610,27 @@ type SearchLocalResult struct {
 
 	// Collections in the profile's collection list matching the query
 	Collections []*itchio.Collection `json:"collections"`
+
+	// Matches of any kind, best first. Only set when ranked.
+	// @optional
+	Items []*SearchLocalItem `json:"items,omitempty"`
+
+	// Used to fetch the next page, when ranked
+	// @optional
+	NextCursor Cursor `json:"nextCursor,omitempty"`
+}
+
+// A single match of a ranked @@SearchLocalParams search.
+// Exactly one of `game`, `bundle` or `collection` is set.
+type SearchLocalItem struct {
+	// @optional
+	Game *itchio.Game `json:"game,omitempty"`
+
+	// @optional
+	Bundle *itchio.Bundle `json:"bundle,omitempty"`
+
+	// @optional
+	Collection *itchio.Collection `json:"collection,omitempty"`
 }
 
 //----------------------------------------------------------------------
@@ -1614,6 +1769,111 @@ type CaveSummary struct {
 	InstalledSize int64      `json:"installedSize"`
 }
 
+// Aggregates play time from the local database: per game, per day
+// or week, and per profile. Does not perform any API requests.
+//
+// Per-game totals are the larger of what itch.io last reported for
+// the game's caves, and the sum of play sessions recorded locally.
+// Per-period and per-profile breakdowns only include play sessions
+// recorded locally, since reported totals don't say when, or under
+// which profile, they were played. Reported play time no session
+// accounts for is returned as `unattributedSecondsRun`.
+//
+// Play sessions are only recorded from this version of butler on:
+// in existing libraries, periods and profiles stay empty until games
+// are played again, and all earlier play time is in
+// `unattributedSecondsRun`.
+//
+// @name Fetch.PlayTime
+// @category Fetch
+// @caller client
+type FetchPlayTimeParams struct {
+	// Only count play sessions recorded under this profile.
+	// When set, per-game totals only include those sessions,
+	// `unattributedSecondsRun` is still for all games.
+	// @optional
+	ProfileID int64 `json:"profileId"`
+
+	// Length of the periods in `periods`. Defaults to `day`.
+	// @optional
+	Period PlayTimePeriod `json:"period"`
+
+	// How many days back `periods` go, counting today. Defaults to 28.
+	// @optional
+	Days int64 `json:"days"`
+
+	// Maximum number of games in `mostPlayed` and `recentlyPlayed`.
+	// Defaults to all games.
+	// @optional
+	Limit int64 `json:"limit"`
+}
+
+func (p FetchPlayTimeParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Period, validation.In(PlayTimePeriodDay, PlayTimePeriodWeek)),
+		validation.Field(&p.Days, validation.Min(int64(0)), validation.Max(int64(3660))),
+		validation.Field(&p.Limit, validation.Min(int64(0))),
+	)
+}
+
+type PlayTimePeriod string
+
+const (
+	PlayTimePeriodDay PlayTimePeriod = "day"
+	// Weeks start on Monday
+	PlayTimePeriodWeek PlayTimePeriod = "week"
+)
+
+type FetchPlayTimeResult struct {
+	// Play time across all games, in seconds
+	TotalSecondsRun int64 `json:"totalSecondsRun"`
+
+	// Play time itch.io reported for games installed here that no
+	// play session recorded locally accounts for, in seconds: it was
+	// played before sessions were recorded, or on another computer.
+	// It's part of per-game totals (unless `profileId` is set), but
+	// not of periods or profiles.
+	UnattributedSecondsRun int64 `json:"unattributedSecondsRun"`
+
+	// Games that were ever played, most played first
+	MostPlayed []*GamePlayTime `json:"mostPlayed"`
+
+	// Games that were ever played, most recently played first
+	RecentlyPlayed []*GamePlayTime `json:"recentlyPlayed"`
+
+	// Play time for each period, oldest first, including periods
+	// during which nothing was played.
+	Periods []*PeriodPlayTime `json:"periods"`
+
+	// Play time per profile, most played first
+	Profiles []*ProfilePlayTime `json:"profiles"`
+}
+
+type GamePlayTime struct {
+	GameID int64 `json:"gameId"`
+
+	// Null if the game isn't in the local database
+	// @optional
+	Game *itchio.Game `json:"game,omitempty"`
+
+	SecondsRun int64 `json:"secondsRun"`
+
+	// @optional
+	LastPlayedAt *time.Time `json:"lastPlayedAt,omitempty"`
+}
+
+type PeriodPlayTime struct {
+	// Local midnight at the start of the period
+	StartsAt time.Time `json:"startsAt"`
+
+	SecondsRun int64 `json:"secondsRun"`
+}
+
+type ProfilePlayTime struct {
+	ProfileID  int64 `json:"profileId"`
+	SecondsRun int64 `json:"secondsRun"`
+}
+
 // A Cave corresponds to an "installed item" for a game.
 //
 // It maps one-to-one with an upload. There might be 0, 1, or several
@@ -1696,6 +1956,38 @@ type CaveSettings struct {
 	// after a game update), the normal selection behavior applies.
 	// @optional
 	LaunchTarget string `json:"launchTarget,omitempty"`
+
+	// What to do when an update is available for this cave.
+	// Defaults to `notify`. Pinned caves are never updated.
+	// @optional
+	AutoUpdate AutoUpdatePolicy `json:"autoUpdate,omitempty"`
+
+	// Wharf channel to follow for updates (e.g. `linux-beta`). When set,
+	// update checks offer the head build of that channel's upload instead
+	// of guessing from upload names, switching uploads if needed.
+	// @optional
+	Channel string `json:"channel,omitempty"`
+}
+
+type AutoUpdatePolicy string
+
+const (
+	// Skip this cave when checking for updates, unless it's
+	// explicitly listed in @@CheckUpdateParams
+	AutoUpdatePolicyNever AutoUpdatePolicy = "never"
+	// Report updates from @@CheckUpdateParams, and let the client decide
+	AutoUpdatePolicyNotify AutoUpdatePolicy = "notify"
+	// Have butlerd check for updates periodically, and queue them as
+	// downloads (see @@DownloadsDriveParams). Updates are only queued
+	// when they're unambiguous: a newer build on the same (or followed)
+	// channel, or a single replacement upload with a matching name.
+	AutoUpdatePolicyDownloadAndApply AutoUpdatePolicy = "download-and-apply"
+)
+
+var AutoUpdatePolicyList = []interface{}{
+	AutoUpdatePolicyNever,
+	AutoUpdatePolicyNotify,
+	AutoUpdatePolicyDownloadAndApply,
 }
 
 type InstallLocationSummary struct {
@@ -1706,6 +1998,12 @@ type InstallLocationSummary struct {
 	// Information about the size used and available at this install location.
 	// Sizes that could not be determined are -1.
 	SizeInfo *InstallLocationSizeInfo `json:"sizeInfo"`
+	// How many previous builds are kept per cave for rollback.
+	// 0 if rollback is disabled.
+	RollbackKeep int64 `json:"rollbackKeep"`
+	// Installs bigger than this many bytes aren't snapshotted
+	// for rollback. 0 means no limit.
+	RollbackMaxSize int64 `json:"rollbackMaxSize"`
 }
 
 type InstallLocationSizeInfo struct {
@@ -2134,6 +2432,40 @@ func (p CavesSetPinnedParams) Validate() error {
 
 type CavesSetPinnedResult struct{}
 
+// Restore the build a cave had before its last upgrade, from a rollback
+// snapshot kept on disk. This doesn't need a network connection.
+//
+// Snapshots are only kept for install locations that opt into them,
+// see @@InstallLocationsSetRollbackRetentionParams.
+//
+// The cave is pinned afterwards, so the upgrade isn't applied again
+// right away. Unpin it with @@CavesSetPinnedParams.
+//
+// Fails with code 21000 if no matching snapshot exists.
+//
+// @name Caves.Rollback
+// @category Install
+// @caller client
+type CavesRollbackParams struct {
+	// ID of the cave to roll back
+	CaveID string `json:"caveId"`
+
+	// Build to roll back to. Defaults to the most recent snapshot.
+	// @optional
+	BuildID int64 `json:"buildId,omitempty"`
+}
+
+func (p CavesRollbackParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.CaveID, validation.Required),
+	)
+}
+
+type CavesRollbackResult struct {
+	// The cave, as restored
+	Cave *Cave `json:"cave"`
+}
+
 // Create a shortcut for an existing cave .
 //
 // @name Install.CreateShortcut
@@ -2451,6 +2783,39 @@ func (p InstallLocationsRemoveParams) Validate() error {
 type InstallLocationsRemoveResult struct {
 }
 
+// Configure how many previous builds are kept around for each cave
+// of an install location, so upgrades can be rolled back with
+// @@CavesRollbackParams. Existing snapshots beyond the new limit
+// are removed.
+//
+// @name Install.Locations.SetRollbackRetention
+// @category Install
+// @caller client
+type InstallLocationsSetRollbackRetentionParams struct {
+	// identifier of the install location to configure
+	ID string `json:"id"`
+
+	// How many previous builds to keep per cave. 0 disables rollback.
+	Keep int64 `json:"keep"`
+
+	// Installs bigger than this many bytes aren't snapshotted.
+	// 0 means no limit.
+	// @optional
+	MaxSize int64 `json:"maxSize,omitempty"`
+}
+
+func (p InstallLocationsSetRollbackRetentionParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.ID, validation.Required),
+		validation.Field(&p.Keep, validation.Min(int64(0))),
+		validation.Field(&p.MaxSize, validation.Min(int64(0))),
+	)
+}
+
+type InstallLocationsSetRollbackRetentionResult struct {
+	InstallLocation *InstallLocationSummary `json:"installLocation"`
+}
+
 // @name Install.Locations.GetByID
 // @category Install
 // @caller client
@@ -2588,20 +2953,222 @@ func (p DownloadsClearFinishedParams) Validate() error {
 type DownloadsClearFinishedResult struct {
 }
 
-// Drive downloads, which is: perform them one at a time,
-// until they're all finished.
+// Query the history of past downloads, most recent first. Unlike
+// @@DownloadsListParams, entries survive @@DownloadsClearFinishedParams
+// and discards.
+//
+// @name Downloads.History
+// @category Downloads
+// @caller client
+type DownloadsHistoryParams struct {
+	// Only show downloads for this game
+	// @optional
+	GameID int64 `json:"gameId,omitempty"`
+
+	// Only show downloads for this cave
+	// @optional
+	CaveID string `json:"caveId,omitempty"`
+
+	// Only show downloads started after this time
+	// @optional
+	Since *time.Time `json:"since,omitempty"`
+
+	// Maximum number of entries to return (defaults to 50)
+	// @optional
+	Limit int64 `json:"limit,omitempty"`
+
+	// Used for pagination, if specified
+	// @optional
+	Cursor Cursor `json:"cursor,omitempty"`
+}
+
+func (p DownloadsHistoryParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Limit, validation.Min(int64(0))),
+	)
+}
+
+func (p DownloadsHistoryParams) GetCursor() Cursor {
+	return p.Cursor
+}
+
+func (p DownloadsHistoryParams) GetLimit() int64 {
+	if p.Limit == 0 {
+		return 50
+	}
+	return p.Limit
+}
+
+type DownloadsHistoryResult struct {
+	// A page of history entries
+	Entries []*DownloadHistoryEntry `json:"entries"`
+
+	// Totals over all entries matching the filters, not just this page
+	Totals *DownloadHistoryTotals `json:"totals"`
+
+	// Used to fetch the next page
+	// @optional
+	NextCursor Cursor `json:"nextCursor,omitempty"`
+}
+
+// Statistics about a single download, across all its attempts.
+type DownloadHistoryEntry struct {
+	// Same as the @@Download's ID
+	ID     string         `json:"id"`
+	Reason DownloadReason `json:"reason"`
+	CaveID string         `json:"caveId"`
+	// Null if the game is no longer in the local database
+	// @optional
+	Game     *itchio.Game `json:"game,omitempty"`
+	UploadID int64        `json:"uploadId"`
+	// @optional
+	BuildID int64 `json:"buildId,omitempty"`
+
+	// When the first attempt started
+	StartedAt *time.Time `json:"startedAt"`
+	// Null until the download reaches an outcome
+	// @optional
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// Empty while the download may still be attempted
+	// @optional
+	Outcome DownloadOutcome `json:"outcome,omitempty"`
+
+	// Bytes transferred, across attempts, from the progress
+	// made on the upload or build while downloading.
+	Bytes int64 `json:"bytes"`
+	// Time spent on attempts, in seconds
+	Duration float64 `json:"duration"`
+	// Average transfer speed, in bytes per second
+	AverageBPS float64 `json:"averageBps"`
+	// Fastest transfer speed seen, in bytes per second
+	PeakBPS float64 `json:"peakBps"`
+	// How many times the download was attempted again,
+	// after errors or losing connectivity
+	RetryCount int64 `json:"retryCount"`
+
+	// butlerd error code of the last error, if any
+	// @optional
+	ErrorCode *int64 `json:"errorCode,omitempty"`
+	// Message of the last error, if any
+	// @optional
+	ErrorMessage *string `json:"errorMessage,omitempty"`
+}
+
+type DownloadOutcome string
+
+const (
+	DownloadOutcomeFinished  DownloadOutcome = "finished"
+	DownloadOutcomeErrored   DownloadOutcome = "errored"
+	DownloadOutcomeAborted   DownloadOutcome = "aborted"
+	DownloadOutcomeDiscarded DownloadOutcome = "discarded"
+)
+
+type DownloadHistoryTotals struct {
+	// Number of downloads
+	Count int64 `json:"count"`
+	// Bytes transferred
+	Bytes int64 `json:"bytes"`
+	// Time spent downloading, in seconds
+	Duration float64 `json:"duration"`
+	// Average transfer speed, in bytes per second
+	AverageBPS float64 `json:"averageBps"`
+	// Number of downloads that ended in an error
+	Errored int64 `json:"errored"`
+	// Retries, over all downloads
+	Retries int64 `json:"retries"`
+}
+
+// Drive downloads, which is: perform them in order of position,
+// up to `slots` at a time, until they're all finished.
+//
+// Only one call may drive downloads at a time, across all daemons
+// sharing a database. If another call is still driving downloads,
+// this fails with error code 20000 (see @@Code). If that call
+// stopped sending heartbeats (for example, because its daemon crashed),
+// this one takes over.
 //
 // @name Downloads.Drive
 // @category Downloads
 // @caller client
-type DownloadsDriveParams struct{}
+type DownloadsDriveParams struct {
+	// Maximum number of downloads performed at the same time.
+	// Defaults to 1.
+	// @optional
+	Slots int64 `json:"slots,omitempty"`
+
+	// How to tell when we're back online after a download
+	// failed because of a network error. Defaults to pinging
+	// the itch.io instance butler was started with (`--address`).
+	// @optional
+	Connectivity *ConnectivityCheck `json:"connectivity,omitempty"`
+}
+
+// Upper bound for DownloadsDriveParams.Slots
+const MaxDownloadSlots = 8
 
 func (p DownloadsDriveParams) Validate() error {
-	return nil
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Slots, validation.Min(int64(0)), validation.Max(int64(MaxDownloadSlots))),
+		validation.Field(&p.Connectivity),
+	)
+}
+
+// GetSlots returns the number of download slots to use, applying the default.
+func (p DownloadsDriveParams) GetSlots() int {
+	if p.Slots <= 0 {
+		return 1
+	}
+	return int(p.Slots)
 }
 
 type DownloadsDriveResult struct{}
 
+// Describes how @@DownloadsDriveParams checks for connectivity
+// while it's offline.
+type ConnectivityCheck struct {
+	// What to check
+	Kind ConnectivityCheckKind `json:"kind"`
+
+	// URL to request, for `url` checks. Any 2xx response means we're online.
+	// @optional
+	URL string `json:"url,omitempty"`
+
+	// How long to wait for connectivity before giving up
+	// and retrying downloads anyway, in seconds. Defaults to 120.
+	// @optional
+	Timeout int64 `json:"timeout,omitempty"`
+}
+
+func (cc ConnectivityCheck) Validate() error {
+	var urlRules []validation.Rule
+	if cc.Kind == ConnectivityCheckKindURL {
+		urlRules = append(urlRules, validation.Required)
+	}
+
+	return validation.ValidateStruct(&cc,
+		validation.Field(&cc.Kind, validation.Required, validation.In(
+			ConnectivityCheckKindPing,
+			ConnectivityCheckKindURL,
+			ConnectivityCheckKindAPI,
+		)),
+		validation.Field(&cc.URL, urlRules...),
+		validation.Field(&cc.Timeout, validation.Min(int64(0))),
+	)
+}
+
+type ConnectivityCheckKind string
+
+const (
+	// Request `/static/ping.txt` from the configured itch.io
+	// instance, once a second.
+	ConnectivityCheckKindPing ConnectivityCheckKind = "ping"
+	// Request a user-supplied URL, once a second.
+	ConnectivityCheckKindURL ConnectivityCheckKind = "url"
+	// Request the configured API server, with exponential backoff.
+	// Any response, even an error, means we're online.
+	ConnectivityCheckKindAPI ConnectivityCheckKind = "api"
+)
+
 // Stop driving downloads gracefully.
 //
 // @name Downloads.Drive.Cancel
@@ -2623,11 +3190,16 @@ type DownloadsDriveProgressNotification struct {
 	Progress *DownloadProgress `json:"progress"`
 	// BPS values for the last minute
 	SpeedHistory []float64 `json:"speedHistory"`
+	// Index of the download slot performing this download,
+	// from 0 to `slots - 1` (see @@DownloadsDriveParams)
+	Slot int64 `json:"slot"`
 }
 
 // @name Downloads.Drive.Started
 type DownloadsDriveStartedNotification struct {
 	Download *Download `json:"download"`
+	// Index of the download slot this download was started in
+	Slot int64 `json:"slot"`
 }
 
 // @name Downloads.Drive.Errored
@@ -2655,6 +3227,19 @@ type DownloadsDriveDiscardedNotification struct {
 type DownloadsDriveNetworkStatusNotification struct {
 	// The current network status
 	Status NetworkStatus `json:"status"`
+
+	// What is being checked to find out whether we're back online
+	Check ConnectivityCheckKind `json:"check"`
+
+	// The URL being checked
+	Target string `json:"target"`
+
+	// How many checks failed so far, while offline
+	Attempts int64 `json:"attempts"`
+
+	// Seconds until the next check, while offline
+	// @optional
+	RetryIn float64 `json:"retryIn,omitempty"`
 }
 
 type NetworkStatus string
@@ -2673,6 +3258,20 @@ const (
 	DownloadReasonVersionSwitch DownloadReason = "version-switch"
 )
 
+// Sent during @@DownloadsDriveParams whenever the active window
+// of the download schedule changes, see @@DownloadsScheduleSetParams.
+//
+// @name Downloads.Drive.ScheduleWindowChanged
+type DownloadsDriveScheduleWindowChangedNotification struct {
+	// The window that is now active, null if none is
+	// @optional
+	Window *DownloadScheduleWindow `json:"window,omitempty"`
+	// True if downloads are now paused
+	Paused bool `json:"paused"`
+	// The bandwidth cap now in effect, in kbps. 0 means unlimited.
+	Rate int64 `json:"rate"`
+}
+
 // Represents a download queued, which will be
 // performed whenever @@DownloadsDriveParams is called.
 type Download struct {
@@ -2742,6 +3341,95 @@ func (p DownloadsDiscardParams) Validate() error {
 
 type DownloadsDiscardResult struct{}
 
+// Retrieves the schedule consulted by @@DownloadsDriveParams
+// before starting or continuing downloads.
+//
+// @name Downloads.Schedule.Get
+// @category Downloads
+// @caller client
+type DownloadsScheduleGetParams struct{}
+
+func (p DownloadsScheduleGetParams) Validate() error {
+	return nil
+}
+
+type DownloadsScheduleGetResult struct {
+	Schedule *DownloadSchedule `json:"schedule"`
+}
+
+// Replaces the schedule consulted by @@DownloadsDriveParams.
+// Takes effect within a few seconds if downloads are being driven.
+//
+// @name Downloads.Schedule.Set
+// @category Downloads
+// @caller client
+type DownloadsScheduleSetParams struct {
+	Schedule *DownloadSchedule `json:"schedule"`
+}
+
+func (p DownloadsScheduleSetParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Schedule, validation.Required),
+	)
+}
+
+type DownloadsScheduleSetResult struct{}
+
+// A set of time windows during which downloads are
+// throttled or paused.
+type DownloadSchedule struct {
+	// Windows in order of precedence: the first one that contains
+	// the current local time is the active one.
+	Windows []*DownloadScheduleWindow `json:"windows"`
+
+	// If true, downloads are paused whenever no window is active,
+	// which allows only downloading during specific windows.
+	// @optional
+	PausedOutsideWindows bool `json:"pausedOutsideWindows,omitempty"`
+}
+
+func (s DownloadSchedule) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Windows),
+	)
+}
+
+// A recurring time window of a @@DownloadSchedule
+type DownloadScheduleWindow struct {
+	// Local time at which this window starts, as `HH:MM`
+	Start string `json:"start"`
+
+	// Local time at which this window ends, as `HH:MM`. If it's not
+	// after `start`, the window spans midnight.
+	End string `json:"end"`
+
+	// Days of the week this window applies to, from 0 (Sunday) to
+	// 6 (Saturday). Applies every day if empty. For windows that
+	// span midnight, this is the day the window starts on.
+	// @optional
+	Days []int64 `json:"days,omitempty"`
+
+	// If true, downloads are not started or continued during this window
+	// @optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Bandwidth cap during this window, in kbps. It only ever lowers
+	// the throttle set with Network.SetBandwidthThrottle. 0 means no cap.
+	// @optional
+	Rate int64 `json:"rate,omitempty"`
+}
+
+var scheduleTimeRe = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+func (w DownloadScheduleWindow) Validate() error {
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.Start, validation.Required, validation.Match(scheduleTimeRe)),
+		validation.Field(&w.End, validation.Required, validation.Match(scheduleTimeRe)),
+		validation.Field(&w.Days, validation.Each(validation.Min(int64(0)), validation.Max(int64(6)))),
+		validation.Field(&w.Rate, validation.Min(int64(0))),
+	)
+}
+
 //----------------------------------------------------------------------
 // CheckUpdate
 //----------------------------------------------------------------------
@@ -2751,7 +3439,8 @@ type DownloadsDiscardResult struct{}
 // If a list of cave identifiers is passed, will only look for
 // updates for these caves *and will ignore snooze*.
 //
-// Otherwise, will look for updates for all games, respecting snooze.
+// Otherwise, will look for updates for all games, respecting snooze,
+// and skipping caves whose settings opt out of updates (see @@CaveSettings).
 //
 // Updates found are regularly sent via @@GameUpdateAvailableNotification, and
 // then all at once in the result.
@@ -2966,6 +3655,13 @@ func validateCaveSettings(settings *CaveSettings) error {
 		return fmt.Errorf("settings.commandTemplate: %w", err)
 	}
 
+	if settings.AutoUpdate != "" {
+		err := validation.Validate(settings.AutoUpdate, validation.In(AutoUpdatePolicyList...))
+		if err != nil {
+			return fmt.Errorf("settings.autoUpdate: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -3431,6 +4127,12 @@ const (
 
 	// The selected sandbox is not available on this system
 	CodeSandboxNotAvailable Code = 19000
+
+	// Downloads are already being driven by another @@DownloadsDriveParams call
+	CodeDownloadsDriveAlreadyRunning Code = 20000
+
+	// No rollback snapshot was found for a cave
+	CodeNoRollbackSnapshot Code = 21000
 )
 
 // Publish
@@ -0,0 +1,97 @@
+package butlerd
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+	"github.com/pkg/errors"
+)
+
+type ServeUnixParams struct {
+	Handler   jsonrpc2.Handler
+	Listener  *net.UnixListener
+	KeepAlive bool
+
+	ShutdownChan chan struct{}
+}
+
+// ListenUnix listens on a unix domain socket only the current user can
+// connect to. Any stale socket at that path (from a butlerd that didn't
+// exit cleanly) is replaced, but a socket something still listens on,
+// or anything that isn't a socket, is left alone and an error is returned.
+func ListenUnix(socketPath string) (*net.UnixListener, error) {
+	if !PeerCredentialsSupported {
+		return nil, errors.New("the unix socket transport isn't supported on this platform")
+	}
+
+	stats, err := os.Lstat(socketPath)
+	if err == nil {
+		if stats.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("refusing to replace (%s): not a unix socket", socketPath)
+		}
+		conn, dialErr := net.DialTimeout("unix", socketPath, time.Second)
+		if dialErr == nil {
+			conn.Close()
+			return nil, errors.Errorf("refusing to replace (%s): another process is listening on it", socketPath)
+		}
+		err = os.Remove(socketPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = os.Chmod(socketPath, 0o600)
+	if err != nil {
+		listener.Close()
+		return nil, errors.WithStack(err)
+	}
+	return listener, nil
+}
+
+// ServeUnix serves JSON-RPC over a unix domain socket. There is no
+// secret: the socket is only accessible to the user running butlerd,
+// and connections from other users are refused.
+func (s *Server) ServeUnix(ctx context.Context, params ServeUnixParams) error {
+	return serveConns(ctx, serveConnsParams{
+		Kind:         "unix socket",
+		Listener:     params.Listener,
+		KeepAlive:    params.KeepAlive,
+		ShutdownChan: params.ShutdownChan,
+		Handle: func(ctx context.Context, conn net.Conn) error {
+			return s.handleUnixConn(ctx, params, conn)
+		},
+	})
+}
+
+func (s *Server) handleUnixConn(parentCtx context.Context, params ServeUnixParams, unixConn net.Conn) error {
+	uid, err := peerUID(unixConn.(*net.UnixConn))
+	if err != nil {
+		unixConn.Close()
+		return errors.WithMessage(err, "checking peer credentials")
+	}
+	if uid != os.Getuid() {
+		log.Printf("Refusing unix socket connection from UID %d, only UID %d is allowed", uid, os.Getuid())
+		unixConn.Close()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewRwcTransport(unixConn), params.Handler)
+
+	<-conn.DisconnectNotify()
+
+	return nil
+}
@@ -0,0 +1,118 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package butlerd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+)
+
+func Test_ServeUnix(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "butlerd.sock")
+
+	listener, err := ListenUnix(socketPath)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+
+	stats, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("stat'ing socket: %v", err)
+	}
+	if stats.Mode().Perm() != 0o600 {
+		t.Fatalf("expected socket mode 0600, got %o", stats.Mode().Perm())
+	}
+
+	shutdownChan := make(chan struct{})
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- NewServer("").ServeUnix(context.Background(), ServeUnixParams{
+			Handler:      pingHandler(),
+			Listener:     listener,
+			KeepAlive:    true,
+			ShutdownChan: shutdownChan,
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		unixConn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("dialing: %v", err)
+		}
+		client := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewRwcTransport(unixConn), &testHandler{})
+
+		// no Meta.Authenticate needed
+		var result struct {
+			OK bool `json:"ok"`
+		}
+		err = client.Call("Ping", struct{}{}, &result)
+		if err != nil {
+			t.Fatalf("calling Ping over unix socket: %v", err)
+		}
+		if !result.OK {
+			t.Fatalf("expected ok=true, got false")
+		}
+		client.Close()
+	}
+
+	close(shutdownChan)
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Fatalf("ServeUnix returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for ServeUnix to return after shutdown")
+	}
+}
+
+func Test_ListenUnixReplacesOnlySockets(t *testing.T) {
+	dir := t.TempDir()
+
+	filePath := filepath.Join(dir, "not-a-socket")
+	err := os.WriteFile(filePath, []byte("precious"), 0o644)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	_, err = ListenUnix(filePath)
+	if err == nil {
+		t.Fatalf("expected listening over a regular file to fail")
+	}
+	contents, err := os.ReadFile(filePath)
+	if err != nil || string(contents) != "precious" {
+		t.Fatalf("expected regular file to be left alone (%q, %v)", string(contents), err)
+	}
+
+	socketPath := filepath.Join(dir, "butlerd.sock")
+	stale, err := ListenUnix(socketPath)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	// leave the socket file behind, like a butlerd that crashed would
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ListenUnix(socketPath)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced: %v", err)
+	}
+	defer listener.Close()
+
+	// another butlerd is still serving on it
+	_, err = ListenUnix(socketPath)
+	if err == nil {
+		t.Fatalf("expected listening over a live socket to fail")
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("expected live socket to be left alone: %v", err)
+	}
+	conn.Close()
+}
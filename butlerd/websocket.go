@@ -0,0 +1,113 @@
+package butlerd
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+	"golang.org/x/net/websocket"
+)
+
+type ServeWebSocketParams struct {
+	Handler   jsonrpc2.Handler
+	Listener  net.Listener
+	Secret    string
+	KeepAlive bool
+	// When set, connections are made over TLS (wss://), with the
+	// certificate from MakeTLSState
+	TLSState *TLSState
+
+	ShutdownChan chan struct{}
+}
+
+// ServeWebSocket serves JSON-RPC over WebSocket, one JSON-RPC message per
+// WebSocket text message, so web-based clients can connect directly.
+// Like TCP connections, WebSocket connections must call Meta.Authenticate
+// before anything else.
+func (s *Server) ServeWebSocket(ctx context.Context, params ServeWebSocketParams) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listener := params.Listener
+	if params.TLSState != nil {
+		config := params.TLSState.Config.Clone()
+		// WebSocket handshakes are HTTP/1.1 requests
+		config.NextProtos = []string{"http/1.1"}
+		listener = tls.NewListener(listener, config)
+	}
+
+	var wg sync.WaitGroup
+	var servedMutex sync.Mutex
+	served := false
+	firstConnDone := make(chan struct{})
+
+	wsServer := websocket.Server{
+		// No origin check: clients connect from all kinds of origins
+		// (file://, custom schemes), the secret is what keeps others out.
+		Handler: func(wsConn *websocket.Conn) {
+			if !params.KeepAlive {
+				servedMutex.Lock()
+				first := !served
+				served = true
+				servedMutex.Unlock()
+
+				if !first {
+					log.Printf("Refusing WebSocket connection, already serving one (use --keep-alive to accept more)")
+					wsConn.Close()
+					return
+				}
+				defer close(firstConnDone)
+			}
+
+			wg.Add(1)
+			defer wg.Done()
+			s.handleWebSocketConn(ctx, params, wsConn)
+		},
+	}
+
+	httpServer := &http.Server{
+		Handler: wsServer,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	select {
+	case <-firstConnDone:
+		// without keep-alive, we're done after the first connection
+	case <-params.ShutdownChan:
+		log.Printf("Closing WebSocket listener...")
+		err := httpServer.Close()
+		if err != nil {
+			log.Printf("While closing WebSocket listener: %+v", err)
+		}
+
+		log.Printf("Waiting for WebSocket connections to close...")
+		wg.Wait()
+		log.Printf("All WebSocket connections closed")
+	case <-ctx.Done():
+	case err := <-serveErr:
+		return err
+	}
+
+	httpServer.Close()
+	return nil
+}
+
+func (s *Server) handleWebSocketConn(parentCtx context.Context, params ServeWebSocketParams, wsConn *websocket.Conn) {
+	gh := newGatedHandler(params.Handler, params.Secret)
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
+
+	// the connection is closed as soon as the websocket handler returns,
+	// so wait for the peer (or us) to disconnect
+	conn := jsonrpc2.NewConn(ctx, jsonrpc2.NewWebSocketTransport(wsConn), gh)
+
+	<-conn.DisconnectNotify()
+}
@@ -0,0 +1,156 @@
+package butlerd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd/jsonrpc2"
+	"golang.org/x/net/websocket"
+)
+
+func pingHandler() jsonrpc2.Handler {
+	return &testHandler{
+		handleRequest: func(conn jsonrpc2.Conn, req jsonrpc2.Request) (interface{}, error) {
+			if req.Method != "Ping" {
+				return nil, errors.New("unexpected method")
+			}
+			return map[string]bool{"ok": true}, nil
+		},
+	}
+}
+
+func serveTestWebSocket(t *testing.T, params ServeWebSocketParams) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	params.Listener = listener
+
+	serveDone := make(chan error, 1)
+	go func() {
+		serveDone <- NewServer(params.Secret).ServeWebSocket(context.Background(), params)
+	}()
+	return listener.Addr().String(), serveDone
+}
+
+func dialTestWebSocket(t *testing.T, config *websocket.Config) *websocket.Conn {
+	wsConn, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	return wsConn
+}
+
+func Test_ServeWebSocket_RequiresSecret(t *testing.T) {
+	shutdownChan := make(chan struct{})
+	addr, serveDone := serveTestWebSocket(t, ServeWebSocketParams{
+		Handler:      pingHandler(),
+		Secret:       "hunter2",
+		KeepAlive:    true,
+		ShutdownChan: shutdownChan,
+	})
+
+	config, err := websocket.NewConfig("ws://"+addr+"/", "http://localhost/")
+	if err != nil {
+		t.Fatalf("making config: %v", err)
+	}
+
+	client := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewWebSocketTransport(dialTestWebSocket(t, config)), &testHandler{})
+	defer client.Close()
+
+	var authResult MetaAuthenticateResult
+	err = client.Call("Meta.Authenticate", MetaAuthenticateParams{Secret: "wrong"}, &authResult)
+	if err == nil {
+		t.Fatalf("expected wrong secret to be refused")
+	}
+
+	err = client.Call("Meta.Authenticate", MetaAuthenticateParams{Secret: "hunter2"}, &authResult)
+	if err != nil {
+		t.Fatalf("authenticating: %v", err)
+	}
+
+	var result struct {
+		OK bool `json:"ok"`
+	}
+	err = client.Call("Ping", struct{}{}, &result)
+	if err != nil {
+		t.Fatalf("calling Ping over WebSocket: %v", err)
+	}
+	if !result.OK {
+		t.Fatalf("expected ok=true, got false")
+	}
+
+	// with keep-alive, a second client can connect
+	second := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewWebSocketTransport(dialTestWebSocket(t, config)), &testHandler{})
+	err = second.Call("Meta.Authenticate", MetaAuthenticateParams{Secret: "hunter2"}, &authResult)
+	if err != nil {
+		t.Fatalf("authenticating second client: %v", err)
+	}
+	second.Close()
+
+	client.Close()
+	close(shutdownChan)
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Fatalf("ServeWebSocket returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for ServeWebSocket to return after shutdown")
+	}
+}
+
+func Test_ServeWebSocket_TLS(t *testing.T) {
+	ts, err := MakeTLSState()
+	if err != nil {
+		t.Fatalf("making TLS state: %v", err)
+	}
+
+	addr, serveDone := serveTestWebSocket(t, ServeWebSocketParams{
+		Handler:  pingHandler(),
+		Secret:   "hunter2",
+		TLSState: ts,
+	})
+
+	config, err := websocket.NewConfig("wss://"+addr+"/", "https://localhost/")
+	if err != nil {
+		t.Fatalf("making config: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ts.CertPEMBlock) {
+		t.Fatalf("could not parse certificate")
+	}
+	config.TlsConfig = &tls.Config{RootCAs: roots}
+
+	client := jsonrpc2.NewConn(context.Background(), jsonrpc2.NewWebSocketTransport(dialTestWebSocket(t, config)), &testHandler{})
+
+	var authResult MetaAuthenticateResult
+	err = client.Call("Meta.Authenticate", MetaAuthenticateParams{Secret: "hunter2"}, &authResult)
+	if err != nil {
+		t.Fatalf("authenticating: %v", err)
+	}
+
+	var result struct {
+		OK bool `json:"ok"`
+	}
+	err = client.Call("Ping", struct{}{}, &result)
+	if err != nil {
+		t.Fatalf("calling Ping over secure WebSocket: %v", err)
+	}
+
+	// without keep-alive, serving ends with the first connection
+	client.Close()
+	select {
+	case err := <-serveDone:
+		if err != nil {
+			t.Fatalf("ServeWebSocket returned error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for ServeWebSocket to return after disconnect")
+	}
+}
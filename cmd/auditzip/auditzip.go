@@ -38,15 +38,54 @@ func do(ctx *mansion.Context) {
 }
 
 func Do(consumer *state.Consumer, file string, upstream bool) error {
+	started := false
+	auditConsumer := &state.Consumer{
+		OnMessage: consumer.OnMessage,
+		OnProgress: func(progress float64) {
+			if !started {
+				comm.StartProgress()
+				started = true
+			}
+			comm.Progress(progress)
+		},
+		OnProgressLabel: comm.ProgressLabel,
+	}
+
+	foundErrors, err := Audit(auditConsumer, file, upstream)
+	comm.EndProgress()
+	if err != nil {
+		return err
+	}
+
+	if len(foundErrors) > 0 {
+		consumer.Infof("================================================")
+		consumer.Statf("Found %d errors:", len(foundErrors))
+		for _, fullMessage := range foundErrors {
+			consumer.Logf(" ✖ %s", fullMessage)
+		}
+		consumer.Infof("================================================")
+		return fmt.Errorf("Found %d errors in zip file", len(foundErrors))
+	}
+
+	consumer.Statf("Everything checks out!")
+
+	return nil
+}
+
+// Audit reads every entry of a zip file, and returns the problems it
+// found with single entries, like names that aren't encoded properly or
+// data that can't be decompressed. It returns an error if the zip file
+// can't be read at all, or if an entry isn't the size its header says.
+func Audit(consumer *state.Consumer, file string, upstream bool) ([]string, error) {
 	f, err := eos.Open(file, option.WithConsumer(consumer))
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	defer f.Close()
 
 	stats, err := f.Stat()
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	consumer.Opf("Auditing (%s)...", stats.Name())
@@ -69,13 +108,8 @@ func Do(consumer *state.Consumer, file string, upstream bool) error {
 	}
 
 	paths := make(map[string]int)
-	started := false
 
 	err = impl.EachEntry(consumer, f, stats.Size(), func(index int, name string, nonutf8 bool, uncompressedSize int64, rc io.ReadCloser, numEntries int) error {
-		if !started {
-			comm.StartProgress()
-			started = true
-		}
 		path := boar.CleanFileName(name)
 
 		if nonutf8 {
@@ -87,8 +121,8 @@ func Do(consumer *state.Consumer, file string, upstream bool) error {
 			}
 		}
 
-		comm.Progress(float64(index) / float64(numEntries))
-		comm.ProgressLabel(path)
+		consumer.Progress(float64(index) / float64(numEntries))
+		consumer.ProgressLabel(path)
 
 		if previousIndex, ok := paths[path]; ok {
 			consumer.Warnf("Duplicate path (%s) at indices (%d) and (%d)", path, index, previousIndex)
@@ -113,24 +147,11 @@ func Do(consumer *state.Consumer, file string, upstream bool) error {
 		}
 		return nil
 	})
-	comm.EndProgress()
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	if len(foundErrors) > 0 {
-		consumer.Infof("================================================")
-		consumer.Statf("Found %d errors:", len(foundErrors))
-		for _, fullMessage := range foundErrors {
-			consumer.Logf(" ✖ %s", fullMessage)
-		}
-		consumer.Infof("================================================")
-		return fmt.Errorf("Found %d errors in zip file", len(foundErrors))
-	}
-
-	consumer.Statf("Everything checks out!")
-
-	return nil
+	return foundErrors, nil
 }
 
 // zip implementation types
@@ -1,6 +1,10 @@
 package auditzip_test
 
 import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/itchio/butler/cmd/auditzip"
@@ -24,3 +28,38 @@ func TestProtoZip(t *testing.T) {
 	wtest.Must(t, auditzip.Do(consumer, "./testdata/proto.zip", upstream))
 	wtest.Must(t, auditzip.Do(consumer, "./testdata/proto-with-lzma.zip", upstream))
 }
+
+func TestAuditNonUTF8Names(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "latin1.zip")
+	f, err := os.Create(zipPath)
+	wtest.Must(t, err)
+
+	zw := zip.NewWriter(f)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "ok.txt"},
+		// "é" in latin-1, without the utf-8 flag
+		{Name: "caf\xe9.txt", NonUTF8: true},
+	} {
+		w, err := zw.CreateHeader(fh)
+		wtest.Must(t, err)
+		_, err = w.Write([]byte("data"))
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, zw.Close())
+	wtest.Must(t, f.Close())
+
+	consumer := &state.Consumer{}
+	for _, upstream := range []bool{true, false} {
+		problems, err := auditzip.Audit(consumer, zipPath, upstream)
+		wtest.Must(t, err)
+		if len(problems) != 1 || !strings.Contains(problems[0], "isn't encoded as utf-8") {
+			t.Fatalf("expected a single encoding problem (upstream = %v), got %v", upstream, problems)
+		}
+	}
+
+	problems, err := auditzip.Audit(consumer, "./testdata/proto.zip", false)
+	wtest.Must(t, err)
+	if len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
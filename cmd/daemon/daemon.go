@@ -2,6 +2,7 @@ package daemon
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"log/slog"
 	"net"
@@ -28,6 +29,9 @@ var args = struct {
 	transport   string
 	keepAlive   bool
 	log         bool
+	logFile     string
+	tls         bool
+	socket      string
 }{}
 
 // origStdout holds the real stdout before redirecting it for stdio transport.
@@ -36,9 +40,12 @@ var origStdout *os.File
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("daemon", "Start a butlerd instance").Hidden()
 	cmd.Flag("destiny-pid", "The daemon will shutdown whenever any of its destiny PIDs shuts down").Int64ListVar(&args.destinyPids)
-	cmd.Flag("transport", "Which transport to use").Default("tcp").EnumVar(&args.transport, "http", "tcp", "stdio")
-	cmd.Flag("keep-alive", "Accept multiple TCP connections, stay up until killed or a destiny PID shuts down").BoolVar(&args.keepAlive)
-	cmd.Flag("log", "Log all requests to stderr").BoolVar(&args.log)
+	cmd.Flag("transport", "Which transport to use").Default("tcp").EnumVar(&args.transport, "http", "tcp", "stdio", "websocket", "unix")
+	cmd.Flag("keep-alive", "Accept multiple TCP, WebSocket or unix socket connections, stay up until killed or a destiny PID shuts down").BoolVar(&args.keepAlive)
+	cmd.Flag("tls", "With the websocket transport, use TLS (wss://) with a self-signed certificate, included in the listen notification").BoolVar(&args.tls)
+	cmd.Flag("socket", "With the unix transport, the path of the socket to listen on (defaults to a fresh temporary directory)").StringVar(&args.socket)
+	cmd.Flag("log", "Log all requests, with their duration, error code and API calls, as JSON lines (see --log-file)").BoolVar(&args.log)
+	cmd.Flag("log-file", "Where to write the request log, rotated when it gets large (defaults to butlerd-requests.log next to the database)").StringVar(&args.logFile)
 	ctx.Register(cmd, do)
 }
 
@@ -130,7 +137,24 @@ func do(ctx *mansion.Context) {
 
 func Do(mansionContext *mansion.Context, ctx context.Context, dbPool *sqlitex.Pool, secret string) error {
 	s := butlerd.NewServer(secret)
-	router := GetRouter(dbPool, mansionContext)
+
+	var requestLog *butlerd.RequestLog
+	if args.log {
+		logPath := args.logFile
+		if logPath == "" {
+			logPath = filepath.Join(filepath.Dir(mansionContext.DBPath), "butlerd-requests.log")
+		}
+
+		var err error
+		requestLog, err = butlerd.NewRequestLog(logPath, butlerd.DefaultRequestLogMaxSize, butlerd.DefaultRequestLogMaxBackups)
+		if err != nil {
+			return errors.WithMessage(err, "opening request log")
+		}
+		defer requestLog.Close()
+		comm.Logf("butlerd: logging requests to %s", logPath)
+	}
+
+	router := GetRouter(dbPool, mansionContext, requestLog)
 
 	switch args.transport {
 	case "tcp":
@@ -158,6 +182,80 @@ func Do(mansionContext *mansion.Context, ctx context.Context, dbPool *sqlitex.Po
 		if err != nil {
 			return err
 		}
+	case "websocket":
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		if err != nil {
+			return err
+		}
+
+		var ts *butlerd.TLSState
+		scheme := "ws"
+		if args.tls {
+			ts, err = butlerd.MakeTLSState()
+			if err != nil {
+				return errors.WithMessage(err, "generating TLS certificate")
+			}
+			scheme = "wss"
+		}
+
+		wsInfo := map[string]interface{}{
+			"address": listener.Addr().String(),
+			"url":     fmt.Sprintf("%s://%s/", scheme, listener.Addr().String()),
+		}
+		if ts != nil {
+			wsInfo["cert"] = string(ts.CertPEMBlock)
+		}
+		comm.Object("butlerd/listen-notification", map[string]interface{}{
+			"secret":    secret,
+			"websocket": wsInfo,
+		})
+
+		err = s.ServeWebSocket(ctx, butlerd.ServeWebSocketParams{
+			Handler:   router,
+			Listener:  listener,
+			Secret:    secret,
+			KeepAlive: args.keepAlive,
+			TLSState:  ts,
+
+			ShutdownChan: router.ShutdownChan,
+		})
+		if err != nil {
+			return err
+		}
+	case "unix":
+		socketPath := args.socket
+		if socketPath == "" {
+			socketDir, err := os.MkdirTemp("", "butlerd-")
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			defer os.RemoveAll(socketDir)
+			socketPath = filepath.Join(socketDir, "butlerd.sock")
+		}
+
+		listener, err := butlerd.ListenUnix(socketPath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socketPath)
+
+		// no secret: only our own user can connect
+		comm.Object("butlerd/listen-notification", map[string]interface{}{
+			"unix": map[string]interface{}{
+				"path": socketPath,
+			},
+		})
+
+		err = s.ServeUnix(ctx, butlerd.ServeUnixParams{
+			Handler:   router,
+			Listener:  listener,
+			KeepAlive: args.keepAlive,
+
+			ShutdownChan: router.ShutdownChan,
+		})
+		if err != nil {
+			return err
+		}
 	case "stdio":
 		rwc := &stdioReadWriteCloser{
 			in:  os.Stdin,
@@ -172,7 +270,7 @@ func Do(mansionContext *mansion.Context, ctx context.Context, dbPool *sqlitex.Po
 			return err
 		}
 	case "http":
-		comm.Dief("The HTTP transport is deprecated. Use TCP instead.")
+		comm.Dief("The HTTP transport is deprecated. Use TCP, or WebSocket for web-based clients, instead.")
 	}
 
 	return nil
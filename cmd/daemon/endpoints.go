@@ -22,12 +22,15 @@ import (
 
 var mainRouter *butlerd.Router
 
-func GetRouter(dbPool *sqlitex.Pool, mansionContext *mansion.Context) *butlerd.Router {
+func GetRouter(dbPool *sqlitex.Pool, mansionContext *mansion.Context, requestLog *butlerd.RequestLog) *butlerd.Router {
 	if mainRouter != nil {
 		return mainRouter
 	}
 
 	mainRouter = butlerd.NewRouter(dbPool, mansionContext.NewClient, mansionContext.HTTPClient, mansionContext.HTTPTransport)
+	mainRouter.WebAddress = mansionContext.WebAddress()
+	mainRouter.APIAddress = mansionContext.APIAddress()
+	mainRouter.RequestLog = requestLog
 
 	meta.Register(mainRouter)
 	utilities.Register(mainRouter)
@@ -45,5 +48,7 @@ func GetRouter(dbPool *sqlitex.Pool, mansionContext *mansion.Context) *butlerd.R
 
 	messages.EnsureAllRequests(mainRouter)
 
+	mainRouter.QueueBackgroundTask(update.AutoUpdate())
+
 	return mainRouter
 }
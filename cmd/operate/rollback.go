@@ -0,0 +1,296 @@
+package operate
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/hush/bfs"
+	"github.com/pkg/errors"
+)
+
+// Rollback snapshots are full copies of an install folder, taken right
+// before an upgrade patches it, so the previous build can be restored
+// without a network round-trip. They're opt-in, per install location:
+// see models.InstallLocation.RollbackKeep.
+//
+// Snapshots live in the install location's rollback folder for the cave,
+// in a folder named after the build they hold.
+
+// renameFolder is os.Rename, tests swap it out to act like snapshots are
+// on another device
+var renameFolder = os.Rename
+
+type RollbackSnapshot struct {
+	BuildID   int64
+	Path      string
+	CreatedAt time.Time
+}
+
+// ListRollbackSnapshots returns the snapshots in a cave's rollback
+// folder, most recent first.
+func ListRollbackSnapshots(rollbackFolder string) ([]*RollbackSnapshot, error) {
+	entries, err := os.ReadDir(rollbackFolder)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var snapshots []*RollbackSnapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// skips snapshots still being copied, too
+		buildID, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, &RollbackSnapshot{
+			BuildID:   buildID,
+			Path:      filepath.Join(rollbackFolder, entry.Name()),
+			CreatedAt: info.ModTime(),
+		})
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// PruneRollbackSnapshots removes all but the `keep` most recent snapshots.
+func PruneRollbackSnapshots(consumer *state.Consumer, rollbackFolder string, keep int64) error {
+	snapshots, err := ListRollbackSnapshots(rollbackFolder)
+	if err != nil {
+		return err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	for i := int(keep); i < len(snapshots); i++ {
+		consumer.Infof("Pruning rollback snapshot of build %d", snapshots[i].BuildID)
+		err := os.RemoveAll(snapshots[i].Path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if keep == 0 {
+		os.Remove(rollbackFolder)
+	}
+	return nil
+}
+
+// TakeRollbackSnapshot copies installFolder to a snapshot for buildID,
+// unless one exists already (for example, when resuming an upgrade),
+// then prunes older snapshots. Installs bigger than maxSize (if non-zero)
+// aren't snapshotted.
+func TakeRollbackSnapshot(consumer *state.Consumer, installFolder string, rollbackFolder string, buildID int64, keep int64, maxSize int64) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	snapshotPath := filepath.Join(rollbackFolder, strconv.FormatInt(buildID, 10))
+	if _, err := os.Stat(snapshotPath); err == nil {
+		consumer.Infof("Rollback snapshot of build %d already exists", buildID)
+		return nil
+	}
+
+	if maxSize > 0 {
+		size, err := folderSize(installFolder)
+		if err != nil {
+			return err
+		}
+		if size > maxSize {
+			consumer.Infof("Install is bigger than rollback size limit (%d > %d bytes), not keeping a snapshot", size, maxSize)
+			return nil
+		}
+	}
+
+	consumer.Opf("Keeping rollback snapshot of build %d...", buildID)
+	tmpPath := snapshotPath + ".tmp"
+	err := os.RemoveAll(tmpPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = copyTree(installFolder, tmpPath)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return errors.WithMessage(err, "copying install folder")
+	}
+	err = os.Rename(tmpPath, snapshotPath)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return errors.WithStack(err)
+	}
+	// mark creation time, copyTree preserves the install folder's
+	now := time.Now()
+	err = os.Chtimes(snapshotPath, now, now)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return PruneRollbackSnapshots(consumer, rollbackFolder, keep)
+}
+
+// RestoreRollbackSnapshot swaps installFolder with a snapshot, and returns
+// the receipt of the restored build. The snapshot is consumed.
+func RestoreRollbackSnapshot(consumer *state.Consumer, snapshot *RollbackSnapshot, installFolder string) (*bfs.Receipt, error) {
+	receipt, err := bfs.ReadReceipt(snapshot.Path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "reading snapshot receipt")
+	}
+	if receipt == nil {
+		return nil, errors.Errorf("Rollback snapshot of build %d has no receipt", snapshot.BuildID)
+	}
+
+	replacedFolder := installFolder + ".replaced"
+	err = os.RemoveAll(replacedFolder)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	consumer.Opf("Restoring build %d from rollback snapshot...", snapshot.BuildID)
+	err = renameFolder(installFolder, replacedFolder)
+	if err != nil {
+		return nil, errors.WithMessage(err, "moving current install out of the way")
+	}
+
+	err = renameFolder(snapshot.Path, installFolder)
+	if err != nil {
+		// different device, most likely: copy it instead
+		consumer.Infof("Could not move snapshot (%v), copying it", err)
+		err = copyTree(snapshot.Path, installFolder)
+		if err != nil {
+			os.RemoveAll(installFolder)
+			if rErr := renameFolder(replacedFolder, installFolder); rErr != nil {
+				consumer.Warnf("Could not put current install back: %v", rErr)
+			}
+			return nil, errors.WithMessage(err, "restoring snapshot")
+		}
+		os.RemoveAll(snapshot.Path)
+	}
+
+	err = os.RemoveAll(replacedFolder)
+	if err != nil {
+		consumer.Warnf("Could not remove replaced install: %v", err)
+	}
+
+	return receipt, nil
+}
+
+// takeRollbackSnapshot keeps a snapshot of the build an upgrade is about
+// to patch, if the cave's install location asks for it. A failed snapshot
+// doesn't prevent the upgrade.
+func takeRollbackSnapshot(oc *OperationContext, meta *MetaSubcontext, receiptIn *bfs.Receipt) {
+	consumer := oc.Consumer()
+	params := meta.Data
+
+	if params.NoCave || params.InstallLocationID == "" {
+		return
+	}
+	if receiptIn == nil || receiptIn.Build == nil {
+		return
+	}
+
+	var il *models.InstallLocation
+	oc.rc.WithConn(func(conn *sqlite.Conn) {
+		il = models.InstallLocationByID(conn, params.InstallLocationID)
+	})
+	if il == nil || il.RollbackKeep <= 0 {
+		return
+	}
+
+	err := TakeRollbackSnapshot(
+		consumer,
+		params.InstallFolder,
+		il.GetRollbackFolder(params.CaveID),
+		receiptIn.Build.ID,
+		il.RollbackKeep,
+		il.RollbackMaxSize,
+	)
+	if err != nil {
+		consumer.Warnf("Could not keep rollback snapshot, continuing without: %+v", err)
+	}
+}
+
+func folderSize(folder string) (int64, error) {
+	var size int64
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.WithStack(err)
+}
+
+// copyTree copies a folder, preserving file modes, modification
+// times and symlinks.
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			err = os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case info.Mode()&os.ModeSymlink != 0:
+			var link string
+			link, err = os.Readlink(path)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+			return errors.WithStack(err)
+		case info.Mode().IsRegular():
+			err = copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(os.Chtimes(target, info.ModTime(), info.ModTime()))
+	})
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
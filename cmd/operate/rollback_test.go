@@ -0,0 +1,218 @@
+package operate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/hush/bfs"
+	"github.com/stretchr/testify/require"
+)
+
+// makeInstall writes a small install folder for a build, with its receipt
+func makeInstall(t *testing.T, folder string, buildID int64, contents string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(folder, "data"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "game"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(folder, "data", "level.dat"), []byte(contents), 0o644))
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Symlink("data/level.dat", filepath.Join(folder, "current.dat")))
+	}
+
+	receipt := &bfs.Receipt{Build: &itchio.Build{ID: buildID}}
+	require.NoError(t, receipt.WriteReceipt(folder))
+}
+
+func requireInstall(t *testing.T, folder string, contents string) {
+	data, err := os.ReadFile(filepath.Join(folder, "data", "level.dat"))
+	require.NoError(t, err)
+	require.Equal(t, contents, string(data))
+
+	if runtime.GOOS != "windows" {
+		stats, err := os.Stat(filepath.Join(folder, "game"))
+		require.NoError(t, err)
+		require.EqualValues(t, 0o755, stats.Mode().Perm())
+
+		link, err := os.Readlink(filepath.Join(folder, "current.dat"))
+		require.NoError(t, err)
+		require.Equal(t, "data/level.dat", link)
+	}
+}
+
+// makeSnapshots creates empty snapshots, the first one being the oldest
+func makeSnapshots(t *testing.T, rollbackFolder string, buildIDs ...string) {
+	start := time.Now().Add(-time.Hour)
+	for i, buildID := range buildIDs {
+		p := filepath.Join(rollbackFolder, buildID)
+		require.NoError(t, os.MkdirAll(p, 0o755))
+		mtime := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(p, mtime, mtime))
+	}
+}
+
+func snapshotIDs(t *testing.T, rollbackFolder string) []int64 {
+	snapshots, err := ListRollbackSnapshots(rollbackFolder)
+	require.NoError(t, err)
+	var ids []int64
+	for _, s := range snapshots {
+		ids = append(ids, s.BuildID)
+	}
+	return ids
+}
+
+func Test_TakeRollbackSnapshot(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	rollbackFolder := filepath.Join(dir, "rollback")
+	makeInstall(t, installFolder, 10, "build 10")
+
+	// not asked for
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 10, 0, 0))
+	require.NoDirExists(t, rollbackFolder)
+
+	// too big
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 10, 2, 4))
+	require.NoDirExists(t, rollbackFolder)
+
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 10, 2, 0))
+	require.Equal(t, []int64{10}, snapshotIDs(t, rollbackFolder))
+	requireInstall(t, filepath.Join(rollbackFolder, "10"), "build 10")
+	require.NoDirExists(t, filepath.Join(rollbackFolder, "10.tmp"))
+
+	// resuming an upgrade keeps the snapshot taken before the interruption
+	require.NoError(t, os.WriteFile(filepath.Join(installFolder, "data", "level.dat"), []byte("half-patched"), 0o644))
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 10, 2, 0))
+	requireInstall(t, filepath.Join(rollbackFolder, "10"), "build 10")
+}
+
+func Test_TakeRollbackSnapshotPrunes(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	rollbackFolder := filepath.Join(dir, "rollback")
+	makeInstall(t, installFolder, 12, "build 12")
+	makeSnapshots(t, rollbackFolder, "10", "11")
+
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 12, 2, 0))
+	require.Equal(t, []int64{12, 11}, snapshotIDs(t, rollbackFolder))
+	require.NoDirExists(t, filepath.Join(rollbackFolder, "10"))
+}
+
+func Test_PruneRollbackSnapshots(t *testing.T) {
+	consumer := &state.Consumer{}
+	rollbackFolder := filepath.Join(t.TempDir(), "rollback")
+	makeSnapshots(t, rollbackFolder, "30", "10", "20")
+	// still being copied, not a snapshot yet
+	makeSnapshots(t, rollbackFolder, "40.tmp")
+
+	require.Equal(t, []int64{20, 10, 30}, snapshotIDs(t, rollbackFolder))
+
+	require.NoError(t, PruneRollbackSnapshots(consumer, rollbackFolder, 5))
+	require.Equal(t, []int64{20, 10, 30}, snapshotIDs(t, rollbackFolder))
+
+	require.NoError(t, PruneRollbackSnapshots(consumer, rollbackFolder, 1))
+	require.Equal(t, []int64{20}, snapshotIDs(t, rollbackFolder))
+	require.DirExists(t, filepath.Join(rollbackFolder, "40.tmp"))
+
+	require.NoError(t, os.RemoveAll(filepath.Join(rollbackFolder, "40.tmp")))
+	require.NoError(t, PruneRollbackSnapshots(consumer, rollbackFolder, 0))
+	require.NoDirExists(t, rollbackFolder)
+
+	// nothing to prune
+	require.NoError(t, PruneRollbackSnapshots(consumer, rollbackFolder, 1))
+}
+
+func Test_RestoreRollbackSnapshot(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	rollbackFolder := filepath.Join(dir, "rollback")
+	makeInstall(t, installFolder, 10, "build 10")
+	require.NoError(t, TakeRollbackSnapshot(consumer, installFolder, rollbackFolder, 10, 1, 0))
+
+	require.NoError(t, os.RemoveAll(installFolder))
+	makeInstall(t, installFolder, 11, "build 11")
+
+	snapshots, err := ListRollbackSnapshots(rollbackFolder)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+
+	receipt, err := RestoreRollbackSnapshot(consumer, snapshots[0], installFolder)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, receipt.Build.ID)
+	requireInstall(t, installFolder, "build 10")
+	require.NoDirExists(t, snapshots[0].Path)
+	require.NoDirExists(t, installFolder+".replaced")
+}
+
+func Test_RestoreRollbackSnapshotCopies(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	snapshot := &RollbackSnapshot{BuildID: 10, Path: filepath.Join(dir, "rollback", "10")}
+	makeInstall(t, snapshot.Path, 10, "build 10")
+	makeInstall(t, installFolder, 11, "build 11")
+
+	// the snapshot is on another device
+	defer func() { renameFolder = os.Rename }()
+	renameFolder = func(oldpath string, newpath string) error {
+		if oldpath == snapshot.Path {
+			return errors.New("invalid cross-device link")
+		}
+		return os.Rename(oldpath, newpath)
+	}
+
+	receipt, err := RestoreRollbackSnapshot(consumer, snapshot, installFolder)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, receipt.Build.ID)
+	requireInstall(t, installFolder, "build 10")
+	require.NoDirExists(t, snapshot.Path)
+	require.NoDirExists(t, installFolder+".replaced")
+}
+
+func Test_RestoreRollbackSnapshotFailure(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	snapshot := &RollbackSnapshot{BuildID: 10, Path: filepath.Join(dir, "rollback", "10")}
+	makeInstall(t, snapshot.Path, 10, "build 10")
+	makeInstall(t, installFolder, 11, "build 11")
+
+	// the snapshot can't be moved, and copying it fails halfway because
+	// something is in the way
+	defer func() { renameFolder = os.Rename }()
+	renameFolder = func(oldpath string, newpath string) error {
+		if oldpath == snapshot.Path {
+			require.NoError(t, os.WriteFile(installFolder, []byte("in the way"), 0o644))
+			return errors.New("invalid cross-device link")
+		}
+		return os.Rename(oldpath, newpath)
+	}
+
+	_, err := RestoreRollbackSnapshot(consumer, snapshot, installFolder)
+	require.Error(t, err)
+
+	// the current install is put back, and the snapshot is kept
+	requireInstall(t, installFolder, "build 11")
+	requireInstall(t, snapshot.Path, "build 10")
+	require.NoDirExists(t, installFolder+".replaced")
+}
+
+func Test_RestoreRollbackSnapshotWithoutReceipt(t *testing.T) {
+	consumer := &state.Consumer{}
+	dir := t.TempDir()
+	installFolder := filepath.Join(dir, "install")
+	snapshot := &RollbackSnapshot{BuildID: 10, Path: filepath.Join(dir, "rollback", "10")}
+	require.NoError(t, os.MkdirAll(snapshot.Path, 0o755))
+	makeInstall(t, installFolder, 11, "build 11")
+
+	_, err := RestoreRollbackSnapshot(consumer, snapshot, installFolder)
+	require.Error(t, err)
+	requireInstall(t, installFolder, "build 11")
+	require.DirExists(t, snapshot.Path)
+}
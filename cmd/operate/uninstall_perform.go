@@ -2,6 +2,7 @@ package operate
 
 import (
 	"context"
+	"os"
 
 	"github.com/itchio/butler/butlerd"
 	"github.com/itchio/butler/butlerd/messages"
@@ -99,5 +100,13 @@ func UninstallPerform(ctx context.Context, rc *butlerd.RequestContext, params bu
 		models.Must(wipe.Do(consumer, installFolder))
 	}()
 
+	if il := cave.GetInstallLocation(conn); il != nil {
+		consumer.Infof("Wiping rollback snapshots...")
+		err := os.RemoveAll(il.GetRollbackFolder(cave.ID))
+		if err != nil {
+			consumer.Warnf("While wiping rollback snapshots: %+v", err)
+		}
+	}
+
 	return nil
 }
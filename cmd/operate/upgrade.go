@@ -41,6 +41,10 @@ func upgrade(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcontext
 
 	consumer.Infof("Applying %d patches (%d already done)", remainingPatches, donePatches)
 
+	if donePatches == 0 {
+		takeRollbackSnapshot(oc, meta, receiptIn)
+	}
+
 	var roughPatchCosts []float64
 	var totalPatchCost float64
 	for _, b := range istate.UpgradePath.Builds {
@@ -0,0 +1,210 @@
+package push
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+
+	itchio "github.com/itchio/go-itchio"
+
+	"github.com/itchio/headway/united"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+var buildsArgs = struct {
+	target      string
+	from        int64
+	to          int64
+	changesOnly bool
+}{}
+
+// RegisterBuilds wires up `butler builds`, which lists every build of a
+// channel, and `butler builds diff`, which compares two builds using only
+// their signatures.
+func RegisterBuilds(ctx *mansion.Context) {
+	parentCmd := ctx.App.Command("builds", "List the builds of a channel, or compare two builds.")
+
+	{
+		cmd := parentCmd.Command("list", "List every build of a channel, newest first, with its version, size, date and state.").Default()
+		cmd.Arg("target", "Which channel to list builds of, for example 'leafo/x-moon:win-64'").Required().StringVar(&buildsArgs.target)
+		ctx.Register(cmd, doBuildsList)
+	}
+
+	{
+		cmd := parentCmd.Command("diff", "Show which files changed between two builds, without downloading either of them.")
+		cmd.Arg("from", "ID of the older build").Required().Int64Var(&buildsArgs.from)
+		cmd.Arg("to", "ID of the newer build").Required().Int64Var(&buildsArgs.to)
+		cmd.Flag("changes-only", "Hide unchanged entries from the listing. Counts in the summary still cover every entry.").Default("true").BoolVar(&buildsArgs.changesOnly)
+		ctx.Register(cmd, doBuildsDiff)
+	}
+}
+
+func doBuildsList(ctx *mansion.Context) {
+	ctx.Must(DoBuildsList(ctx, buildsArgs.target))
+}
+
+func doBuildsDiff(ctx *mansion.Context) {
+	ctx.Must(DoBuildsDiff(ctx, buildsArgs.from, buildsArgs.to, buildsArgs.changesOnly))
+}
+
+// DoBuildsList prints every build of a channel.
+func DoBuildsList(ctx *mansion.Context, specStr string) error {
+	spec, err := itchio.ParseSpec(specStr)
+	if err != nil {
+		return errors.Wrapf(err, "parsing target '%s'", specStr)
+	}
+	err = spec.EnsureChannel()
+	if err != nil {
+		return err
+	}
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+
+	requestCtx, cancel := ctx.DefaultCtx()
+	chanRes, err := client.GetChannel(requestCtx, spec.Target, spec.Channel)
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "getting channel")
+	}
+	ch := chanRes.Channel
+	if ch == nil || ch.Upload == nil {
+		return errors.Errorf("channel %s not found for %s", spec.Channel, spec.Target)
+	}
+
+	requestCtx, cancel = ctx.DefaultCtx()
+	buildsRes, err := client.ListUploadBuilds(requestCtx, itchio.ListUploadBuildsParams{
+		UploadID: ch.Upload.ID,
+	})
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "listing builds")
+	}
+
+	var headID int64
+	if ch.Head != nil {
+		headID = ch.Head.ID
+	}
+
+	if comm.JsonEnabled() {
+		builds := []map[string]interface{}{}
+		for _, b := range buildsRes.Builds {
+			out := map[string]interface{}{
+				"id":          b.ID,
+				"state":       string(b.State),
+				"version":     b.Version,
+				"userVersion": b.UserVersion,
+				"createdAt":   b.CreatedAt,
+				"head":        b.ID == headID,
+			}
+			if size, ok := buildSize(b); ok {
+				out["size"] = size
+			}
+			if b.ParentBuildID > 0 {
+				out["parentBuildId"] = b.ParentBuildID
+			}
+			builds = append(builds, out)
+		}
+		comm.Result(map[string]interface{}{
+			"target":  spec.Target,
+			"channel": spec.Channel,
+			"builds":  builds,
+		})
+		return nil
+	}
+
+	if len(buildsRes.Builds) == 0 {
+		comm.Logf("No builds yet for channel %s", spec.Channel)
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.Header([]string{"Build", "Version", "Size", "Date", "State"})
+	for _, b := range buildsRes.Builds {
+		id := fmt.Sprintf("#%d", b.ID)
+		if b.ID == headID {
+			id += " (head)"
+		}
+
+		version := b.UserVersion
+		if version == "" {
+			version = fmt.Sprintf("%d", b.Version)
+		}
+
+		size := "-"
+		if s, ok := buildSize(b); ok {
+			size = united.FormatBytes(s)
+		}
+
+		table.Append([]string{id, version, size, formatBuildDate(b.CreatedAt), string(b.State)})
+	}
+	table.Render()
+
+	return nil
+}
+
+// buildSize returns the size of a build's archive, if it has one
+func buildSize(b *itchio.Build) (int64, bool) {
+	f := itchio.FindBuildFileEx(itchio.BuildFileTypeArchive, itchio.BuildFileSubTypeDefault, b.Files)
+	if f == nil {
+		return 0, false
+	}
+	return f.Size, true
+}
+
+func formatBuildDate(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// DoBuildsDiff compares two builds using their signatures, like
+// `butler push-preview` compares a folder against a channel's latest build.
+func DoBuildsDiff(ctx *mansion.Context, fromID int64, toID int64, changesOnly bool) error {
+	if fromID == toID {
+		return errors.New("can't compare a build to itself")
+	}
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+	consumer := comm.NewStateConsumer()
+
+	comm.Opf("Downloading signatures of builds %d and %d...", fromID, toID)
+	fromSig, err := getSignature(ctx, client, consumer, fromID)
+	if err != nil {
+		return errors.Wrapf(err, "getting signature of build %d", fromID)
+	}
+	toSig, err := getSignature(ctx, client, consumer, toID)
+	if err != nil {
+		return errors.Wrapf(err, "getting signature of build %d", toID)
+	}
+
+	result, err := compareSignatures(toSig, fromSig)
+	if err != nil {
+		return errors.Wrap(err, "comparing builds")
+	}
+
+	printComparison(result, changesOnly)
+	comm.Statf("Build %d to build %d: %d new, %d modified, %d deleted, %d unchanged",
+		fromID, toID, result.Counts.New, result.Counts.Modified, result.Counts.Deleted, result.Counts.Same)
+	comm.Statf("%s in %d files, to %s in %d files",
+		united.FormatBytes(fromSig.Container.Size), len(fromSig.Container.Files), united.FormatBytes(toSig.Container.Size), len(toSig.Container.Files))
+
+	comm.Result(map[string]interface{}{
+		"fromBuildId":     fromID,
+		"toBuildId":       toID,
+		"comparison":      &result.Counts,
+		"topChangedFiles": computeTopChangedFiles(result),
+	})
+	return nil
+}
@@ -0,0 +1,516 @@
+package push
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itchio/butler/buildinfo"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+
+	itchio "github.com/itchio/go-itchio"
+
+	"github.com/itchio/headway/counter"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/headway/united"
+
+	"github.com/itchio/httpkit/eos"
+	"github.com/itchio/lake"
+	"github.com/itchio/lake/tlc"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// A push bundle is a zip file holding everything `butler push` would
+// upload (a patch and a signature), computed offline against a given
+// signature, so it can be uploaded later, from another machine, by
+// `butler push-bundle`.
+const (
+	bundleFormatVersion = 1
+
+	bundleMetadataName  = "bundle.json"
+	bundlePatchName     = "patch.pwr"
+	bundleSignatureName = "signature.pws"
+)
+
+// bundleMetadata is stored as bundle.json in push bundles
+type bundleMetadata struct {
+	Version       int       `json:"version"`
+	ButlerVersion string    `json:"butlerVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+
+	// Target given when exporting, push-bundle may use another one
+	Target      string `json:"target,omitempty"`
+	UserVersion string `json:"userVersion,omitempty"`
+	Hidden      bool   `json:"hidden,omitempty"`
+
+	// Size of the pushed files, before diffing
+	SourceSize int64 `json:"sourceSize"`
+	// Identifies the signature the patch was computed against, see
+	// signatureFingerprint. Empty when it was computed for a first build.
+	BaseSignature string `json:"baseSignature,omitempty"`
+}
+
+var bundleArgs = struct {
+	bundle      string
+	target      string
+	userVersion string
+	allowOlder  bool
+	wait        bool
+	waitTimeout time.Duration
+}{}
+
+// RegisterBundle wires up `butler push-bundle`, which uploads a bundle made
+// by `butler push --export`.
+func RegisterBundle(ctx *mansion.Context) {
+	cmd := ctx.App.Command("push-bundle", "Upload a bundle made with `butler push --export` as a new build.")
+	cmd.Arg("bundle", "Path of the .butlerpush bundle to upload").Required().StringVar(&bundleArgs.bundle)
+	cmd.Arg("target", "Where to push, for example 'leafo/x-moon:win-64'. Must be the channel whose latest build was used when exporting.").Required().StringVar(&bundleArgs.target)
+	cmd.Flag("userversion", "A user-supplied version number, instead of the one given when exporting").Default("").StringVar(&bundleArgs.userVersion)
+	cmd.Flag("allow-older-version", "Push even if the user version is older than the one of the channel's latest build").Default("false").BoolVar(&bundleArgs.allowOlder)
+	cmd.Flag("wait", "Wait for the build to be processed, and exit with an error if processing fails").Default("false").BoolVar(&bundleArgs.wait)
+	cmd.Flag("wait-timeout", "With --wait, give up after this long (for example 30m), 0 waits forever").Default("30m").DurationVar(&bundleArgs.waitTimeout)
+	ctx.Register(cmd, doBundle)
+}
+
+func doBundle(ctx *mansion.Context) {
+	go ctx.DoVersionCheck()
+	ctx.Must(DoBundle(ctx, bundleArgs.bundle, bundleArgs.target, bundleArgs.userVersion, bundleArgs.allowOlder, bundleArgs.wait, bundleArgs.waitTimeout))
+}
+
+// signatureFingerprint identifies a signature by the container it
+// describes (its directories, files and symlinks, along with their
+// modes) and its block hashes, so that a signature made by `butler sign`
+// from the same files as a build matches that build's signature.
+func signatureFingerprint(sig *pwr.SignatureInfo) string {
+	h := sha256.New()
+	for _, d := range sig.Container.Dirs {
+		fmt.Fprintf(h, "d\x00%s\x00%o\n", d.Path, d.Mode)
+	}
+	for _, f := range sig.Container.Files {
+		fmt.Fprintf(h, "f\x00%s\x00%o\x00%d\n", f.Path, f.Mode, f.Size)
+	}
+	for _, l := range sig.Container.Symlinks {
+		fmt.Fprintf(h, "l\x00%s\x00%o\x00%s\n", l.Path, l.Mode, l.Dest)
+	}
+
+	buf := make([]byte, 4)
+	for _, bh := range sig.Hashes {
+		binary.LittleEndian.PutUint32(buf, bh.WeakHash)
+		h.Write(buf)
+		h.Write(bh.StrongHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readSignatureFile reads a signature made by `butler sign`, or downloaded
+// from a build.
+func readSignatureFile(signaturePath string) (*pwr.SignatureInfo, error) {
+	signatureReader, err := eos.Open(signaturePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening signature file")
+	}
+	defer signatureReader.Close()
+
+	signatureSource := seeksource.FromFile(signatureReader)
+
+	_, err = signatureSource.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signature, err := pwr.ReadSignature(context.Background(), signatureSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signature file")
+	}
+	return signature, nil
+}
+
+// exportBundle computes what a push would upload into a bundle, without
+// talking to the itch.io API at all.
+func exportBundle(job Job, r *reporter, userVersion string, sourceContainer *tlc.Container, sourcePool lake.Pool) (*pushOutcome, error) {
+	meta := &bundleMetadata{
+		Version:       bundleFormatVersion,
+		ButlerVersion: buildinfo.Version,
+		CreatedAt:     time.Now().UTC(),
+		Target:        job.Target,
+		UserVersion:   userVersion,
+		Hidden:        job.Hidden,
+		SourceSize:    sourceContainer.Size,
+	}
+
+	var targetSignature *pwr.SignatureInfo
+	if job.ExportSignature == "" {
+		r.Opf("No --export-signature given, exporting a first build")
+		targetSignature = &pwr.SignatureInfo{
+			Container: &tlc.Container{},
+			Hashes:    make([]wsync.BlockHash, 0),
+		}
+	} else {
+		r.Opf("Exporting a patch against %s", job.ExportSignature)
+		var err error
+		targetSignature, err = readSignatureFile(job.ExportSignature)
+		if err != nil {
+			return nil, err
+		}
+		meta.BaseSignature = signatureFingerprint(targetSignature)
+	}
+
+	tmpPath := job.Export + ".tmp"
+	bundleFile, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		bundleFile.Close()
+		os.Remove(tmpPath)
+	}()
+
+	// the patch and signature are written at the same time, and a zip
+	// entry must be done before starting the next one, so the signature
+	// waits in a temporary file.
+	signatureFile, err := os.CreateTemp(filepath.Dir(job.Export), ".butlerpush-signature-*")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		signatureFile.Close()
+		os.Remove(signatureFile.Name())
+	}()
+
+	zw := zip.NewWriter(bundleFile)
+	patchEntry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     bundlePatchName,
+		Method:   zip.Store,
+		Modified: meta.CreatedAt,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	patchCounter := counter.NewWriter(patchEntry)
+	signatureCounter := counter.NewWriter(signatureFile)
+
+	dctx := &pwr.DiffContext{
+		Compression: &pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm_BROTLI,
+			Quality:   1,
+		},
+
+		SourceContainer: sourceContainer,
+		Pool:            sourcePool,
+
+		TargetContainer: targetSignature.Container,
+		TargetSignature: targetSignature.Hashes,
+
+		Consumer: &state.Consumer{
+			OnProgress: func(progress float64) {
+				r.ProgressWith(progress, nil)
+			},
+		},
+	}
+
+	r.Opf("Exporting %s", sourceContainer)
+	r.StartProgress()
+	err = dctx.WritePatch(context.Background(), patchCounter, signatureCounter)
+	r.EndProgress()
+	if err != nil {
+		return nil, errors.Wrap(err, "computing and writing patch")
+	}
+
+	_, err = signatureFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	signatureEntry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     bundleSignatureName,
+		Method:   zip.Store,
+		Modified: meta.CreatedAt,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, err = io.Copy(signatureEntry, signatureFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	metaEntry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     bundleMetadataName,
+		Method:   zip.Deflate,
+		Modified: meta.CreatedAt,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	enc := json.NewEncoder(metaEntry)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(meta)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = bundleFile.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = os.Rename(tmpPath, job.Export)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	r.Statf("Exported %s patch and %s signature to %s", united.FormatBytes(patchCounter.Count()), united.FormatBytes(signatureCounter.Count()), job.Export)
+	r.Logf("")
+	r.Logf("Use `butler push-bundle %s %s` to upload it.", job.Export, job.Target)
+	r.Logf("")
+
+	return &pushOutcome{Reason: "export", Bundle: job.Export}, nil
+}
+
+// pushBundle is an opened push bundle
+type pushBundle struct {
+	meta      *bundleMetadata
+	reader    *zip.ReadCloser
+	patch     *zip.File
+	signature *zip.File
+}
+
+// openBundle opens a bundle and reads its metadata
+func openBundle(bundlePath string) (*pushBundle, error) {
+	reader, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening bundle (%s)", bundlePath)
+	}
+
+	b := &pushBundle{reader: reader}
+	var metaFile *zip.File
+	for _, f := range reader.File {
+		switch f.Name {
+		case bundleMetadataName:
+			metaFile = f
+		case bundlePatchName:
+			b.patch = f
+		case bundleSignatureName:
+			b.signature = f
+		}
+	}
+	if metaFile == nil || b.patch == nil || b.signature == nil {
+		reader.Close()
+		return nil, errors.Errorf("(%s) is not a push bundle, make them with `butler push --export`", bundlePath)
+	}
+
+	err = func() error {
+		metaReader, err := metaFile.Open()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer metaReader.Close()
+
+		b.meta = &bundleMetadata{}
+		return errors.WithStack(json.NewDecoder(metaReader).Decode(b.meta))
+	}()
+	if err != nil {
+		reader.Close()
+		return nil, errors.WithMessage(err, "reading bundle metadata")
+	}
+
+	if b.meta.Version > bundleFormatVersion {
+		reader.Close()
+		return nil, errors.Errorf("bundle was made by a newer version of butler (%s), upgrade to upload it", b.meta.ButlerVersion)
+	}
+	return b, nil
+}
+
+func (b *pushBundle) Close() error {
+	return b.reader.Close()
+}
+
+// DoBundle uploads a push bundle as a new build of a channel, after making
+// sure the channel's latest build is the one the bundle was made against.
+func DoBundle(ctx *mansion.Context, bundlePath string, specStr string, userVersion string, allowOlder bool, wait bool, waitTimeout time.Duration) (retErr error) {
+	b, err := openBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if userVersion == "" {
+		userVersion = b.meta.UserVersion
+	}
+
+	spec, err := itchio.ParseSpec(specStr)
+	if err != nil {
+		return errors.Wrapf(err, "parsing push target '%s'", specStr)
+	}
+	err = spec.EnsureChannel()
+	if err != nil {
+		return err
+	}
+	if b.meta.Target != "" && b.meta.Target != specStr {
+		comm.Logf("Bundle was exported for %s, pushing it to %s", b.meta.Target, specStr)
+	}
+
+	s := newSession(ctx)
+	client, err := s.authenticate()
+	if err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+	consumer := comm.NewStateConsumer()
+
+	var headID int64
+	requestCtx, cancel := ctx.DefaultCtx()
+	chanInfo, err := client.GetChannel(requestCtx, spec.Target, spec.Channel)
+	cancel()
+	if err == nil && chanInfo != nil && chanInfo.Channel != nil && chanInfo.Channel.Head != nil {
+		head := chanInfo.Channel.Head
+		headID = head.ID
+		if !allowOlder {
+			err = checkNotOlder(userVersion, head.UserVersion, head.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case headID == 0 && b.meta.BaseSignature != "":
+		return errors.Errorf("bundle was exported against a previous build, but channel %s has no builds yet. Export it again without --export-signature", spec.Channel)
+	case headID != 0 && b.meta.BaseSignature == "":
+		return errors.Errorf("bundle was exported as a first build, but channel %s already has build %d. Export it again with that build's signature", spec.Channel, headID)
+	case headID != 0:
+		comm.Opf("Checking bundle was made against build %d...", headID)
+		sig, err := s.signature(client, consumer, headID)
+		if err != nil {
+			return errors.Wrap(err, "getting latest build signature")
+		}
+		if signatureFingerprint(sig) != b.meta.BaseSignature {
+			return errors.Errorf("bundle was exported against another build than %d, the latest build of channel %s. Export it again with that build's signature", headID, spec.Channel)
+		}
+	}
+
+	source := os.Getenv("BUTLER_PUSH_SOURCE")
+	if source == "" {
+		source = fmt.Sprintf("cli/%s", buildinfo.Version)
+	}
+
+	requestCtx, cancel = ctx.DefaultCtx()
+	newBuildRes, err := client.CreateBuild(requestCtx, itchio.CreateBuildParams{
+		Target:      spec.Target,
+		Channel:     spec.Channel,
+		UserVersion: userVersion,
+		Hidden:      b.meta.Hidden,
+		Source:      source,
+	})
+	cancel()
+	if err != nil {
+		return errors.Wrap(err, "creating build on remote server")
+	}
+
+	buildID := newBuildRes.Build.ID
+	defer func() {
+		if retErr != nil && buildID != 0 {
+			reportBuildFailure(ctx, client, buildID, spec.Channel, retErr)
+		}
+	}()
+
+	comm.Object("buildCreated", comm.JsonMessage{
+		"buildId": buildID,
+		"channel": spec.Channel,
+	})
+
+	// someone pushed in the meantime
+	if newBuildRes.Build.ParentBuild.ID != headID {
+		return errors.Errorf("build %d was pushed to channel %s while checking the bundle, export it again against that build", newBuildRes.Build.ParentBuild.ID, spec.Channel)
+	}
+
+	bothFiles, err := createBothFiles(ctx, client, buildID)
+	if err != nil {
+		return errors.Wrap(err, "creating remote patch and signature files")
+	}
+
+	upload := func(entry *zip.File, res *itchio.CreateBuildFileResponse, progress bool) error {
+		entryReader, err := entry.Open()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer entryReader.Close()
+
+		file := &journalFile{UploadURL: res.File.UploadURL}
+		ju, err := newJournaledUpload(&pushJournal{}, file, consumer)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if progress {
+			size := float64(entry.UncompressedSize64)
+			ju.SetProgressListener(func(count int64) {
+				comm.Progress(float64(count) / size)
+			})
+		}
+
+		_, err = io.Copy(ju, entryReader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = ju.Close()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		requestCtx, cancel := ctx.DefaultCtx()
+		defer cancel()
+		_, err = client.FinalizeBuildFile(requestCtx, itchio.FinalizeBuildFileParams{
+			BuildID: buildID,
+			FileID:  res.File.ID,
+			Size:    int64(entry.UncompressedSize64),
+		})
+		return errors.WithStack(err)
+	}
+
+	comm.Opf("Uploading %s signature", united.FormatBytes(int64(b.signature.UncompressedSize64)))
+	err = upload(b.signature, bothFiles.signatureRes, false)
+	if err != nil {
+		return errors.Wrap(err, "uploading signature")
+	}
+
+	comm.Opf("Uploading %s patch", united.FormatBytes(int64(b.patch.UncompressedSize64)))
+	comm.StartProgress()
+	err = upload(b.patch, bothFiles.patchRes, true)
+	comm.EndProgress()
+	if err != nil {
+		return errors.Wrap(err, "uploading patch")
+	}
+
+	outcome := &pushOutcome{BuildID: buildID, Channel: spec.Channel}
+	if wait {
+		outcome.State, err = s.waitForBuild(client, &reporter{}, buildID, waitTimeout)
+		pushResult(outcome)
+		// the build was uploaded fine, don't report it as failed
+		if err != nil {
+			buildID = 0
+		}
+		return err
+	}
+
+	comm.Opf("Build is now processing, should be up in a bit.")
+	comm.Logf("")
+	comm.Logf("Use the `butler status %s` for more information.", specStr)
+	comm.Logf("")
+	pushResult(outcome)
+	return nil
+}
@@ -0,0 +1,186 @@
+package push
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/lake/pools"
+	"github.com/itchio/lake/tlc"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wsync"
+	"github.com/stretchr/testify/require"
+
+	// registered by package main in butler itself
+	_ "github.com/itchio/wharf/compressors/cbrotli"
+	_ "github.com/itchio/wharf/decompressors/cbrotli"
+)
+
+func writeTestBundle(t *testing.T, entries map[string]string) string {
+	bundlePath := filepath.Join(t.TempDir(), "test.butlerpush")
+	f, err := os.Create(bundlePath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, contents := range entries {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bundlePath
+}
+
+func Test_OpenBundle(t *testing.T) {
+	bundlePath := writeTestBundle(t, map[string]string{
+		bundleMetadataName:  `{"version": 1, "target": "leafo/x-moon:win", "userVersion": "1.2.0", "baseSignature": "abcd"}`,
+		bundlePatchName:     "patch",
+		bundleSignatureName: "signature",
+	})
+	b, err := openBundle(bundlePath)
+	require.NoError(t, err)
+	require.Equal(t, "leafo/x-moon:win", b.meta.Target)
+	require.Equal(t, "1.2.0", b.meta.UserVersion)
+	require.Equal(t, "abcd", b.meta.BaseSignature)
+	require.EqualValues(t, len("patch"), b.patch.UncompressedSize64)
+	require.NoError(t, b.Close())
+
+	_, err = openBundle(writeTestBundle(t, map[string]string{
+		bundleMetadataName: `{"version": 1}`,
+		bundlePatchName:    "patch",
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not a push bundle")
+
+	_, err = openBundle(writeTestBundle(t, map[string]string{
+		bundleMetadataName:  `{"version": 99, "butlerVersion": "v99.0.0"}`,
+		bundlePatchName:     "patch",
+		bundleSignatureName: "signature",
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "newer version of butler")
+
+	_, err = openBundle(writeTestBundle(t, map[string]string{
+		bundleMetadataName:  `not json`,
+		bundlePatchName:     "patch",
+		bundleSignatureName: "signature",
+	}))
+	require.Error(t, err)
+}
+
+func testSignature() *pwr.SignatureInfo {
+	return &pwr.SignatureInfo{
+		Container: &tlc.Container{
+			Dirs: []*tlc.Dir{
+				{Path: "data", Mode: 0o755},
+			},
+			Files: []*tlc.File{
+				{Path: "data/level.dat", Mode: 0o644, Size: 5},
+				{Path: "game", Mode: 0o755, Size: 3},
+			},
+			Symlinks: []*tlc.Symlink{
+				{Path: "latest", Mode: 0o777, Dest: "game"},
+			},
+		},
+		Hashes: []wsync.BlockHash{
+			{WeakHash: 1, StrongHash: []byte{1, 2, 3}},
+			{WeakHash: 2, StrongHash: []byte{4, 5, 6}},
+		},
+	}
+}
+
+func Test_SignatureFingerprint(t *testing.T) {
+	reference := signatureFingerprint(testSignature())
+	require.Equal(t, reference, signatureFingerprint(testSignature()), "same signature, same fingerprint")
+
+	for name, change := range map[string]func(sig *pwr.SignatureInfo){
+		"file mode": func(sig *pwr.SignatureInfo) { sig.Container.Files[1].Mode = 0o644 },
+		"file size": func(sig *pwr.SignatureInfo) { sig.Container.Files[1].Size = 4 },
+		"file path": func(sig *pwr.SignatureInfo) { sig.Container.Files[1].Path = "game.exe" },
+		"dir mode":  func(sig *pwr.SignatureInfo) { sig.Container.Dirs[0].Mode = 0o700 },
+		"extra dir": func(sig *pwr.SignatureInfo) {
+			sig.Container.Dirs = append(sig.Container.Dirs, &tlc.Dir{Path: "logs", Mode: 0o755})
+		},
+		"symlink dest":   func(sig *pwr.SignatureInfo) { sig.Container.Symlinks[0].Dest = "data" },
+		"no symlink":     func(sig *pwr.SignatureInfo) { sig.Container.Symlinks = nil },
+		"weak hash":      func(sig *pwr.SignatureInfo) { sig.Hashes[0].WeakHash = 3 },
+		"strong hash":    func(sig *pwr.SignatureInfo) { sig.Hashes[1].StrongHash = []byte{4, 5, 7} },
+		"missing blocks": func(sig *pwr.SignatureInfo) { sig.Hashes = sig.Hashes[:1] },
+	} {
+		sig := testSignature()
+		change(sig)
+		require.NotEqual(t, reference, signatureFingerprint(sig), name)
+	}
+}
+
+func exportTestBundle(t *testing.T, source string, exportPath string, exportSignature string) {
+	container, err := tlc.WalkAny(source, tlc.WalkOpts{})
+	require.NoError(t, err)
+	pool, err := pools.New(container, source)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	job := Job{
+		Target:          "leafo/x-moon:win",
+		Hidden:          true,
+		Export:          exportPath,
+		ExportSignature: exportSignature,
+	}
+	outcome, err := exportBundle(job, &reporter{}, "1.2.0", container, pool)
+	require.NoError(t, err)
+	require.Equal(t, exportPath, outcome.Bundle)
+}
+
+func Test_ExportBundleRoundTrip(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "data"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "game.exe"), []byte("MZ game"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "data", "level.dat"), []byte("level"), 0o644))
+
+	dir := t.TempDir()
+
+	// a first build
+	firstPath := filepath.Join(dir, "first.butlerpush")
+	exportTestBundle(t, source, firstPath, "")
+
+	first, err := openBundle(firstPath)
+	require.NoError(t, err)
+	defer first.Close()
+	require.Equal(t, bundleFormatVersion, first.meta.Version)
+	require.Equal(t, "leafo/x-moon:win", first.meta.Target)
+	require.Equal(t, "1.2.0", first.meta.UserVersion)
+	require.True(t, first.meta.Hidden)
+	require.EqualValues(t, len("MZ game")+len("level"), first.meta.SourceSize)
+	require.Empty(t, first.meta.BaseSignature)
+	_, err = os.Stat(firstPath + ".tmp")
+	require.True(t, os.IsNotExist(err), "temporary file is cleaned up")
+
+	// the signature in the bundle is the one the build will have once
+	// processed, export a second build against it
+	signaturePath := filepath.Join(dir, "first.pws")
+	entry, err := first.signature.Open()
+	require.NoError(t, err)
+	signatureFile, err := os.Create(signaturePath)
+	require.NoError(t, err)
+	_, err = io.Copy(signatureFile, entry)
+	require.NoError(t, err)
+	entry.Close()
+	require.NoError(t, signatureFile.Close())
+
+	signature, err := readSignatureFile(signaturePath)
+	require.NoError(t, err)
+	require.Len(t, signature.Container.Files, 2)
+
+	require.NoError(t, os.WriteFile(filepath.Join(source, "data", "level.dat"), []byte("level 2"), 0o644))
+	secondPath := filepath.Join(dir, "second.butlerpush")
+	exportTestBundle(t, source, secondPath, signaturePath)
+
+	second, err := openBundle(secondPath)
+	require.NoError(t, err)
+	defer second.Close()
+	require.Equal(t, signatureFingerprint(signature), second.meta.BaseSignature)
+}
@@ -219,6 +219,14 @@ func compareContainers(
 		Container: sourceContainer,
 		Hashes:    sourceHashes,
 	}
+	return compareSignatures(sourceSig, targetSig)
+}
+
+// compareSignatures classifies every entry in sourceSig vs targetSig, like
+// compareContainers, when both sides are already hashed. `butler builds
+// diff` uses it to compare two builds without downloading either.
+func compareSignatures(sourceSig *pwr.SignatureInfo, targetSig *pwr.SignatureInfo) (*comparisonResult, error) {
+	sourceContainer := sourceSig.Container
 
 	sourceHashInfo, err := pwr.ComputeHashInfo(sourceSig)
 	if err != nil {
@@ -0,0 +1,236 @@
+package push
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// ConfigFileName is the project config `butler push` looks for, in the
+// current directory and its parents, when it isn't given a target.
+const ConfigFileName = "butler.toml"
+
+// Config lists the channels of a project, so they can be pushed by
+// name (`butler push windows`) or all at once (`butler push --all`)
+// instead of repeating flags in CI scripts. For example:
+//
+//	target = "leafo/x-moon"
+//	ignore = ["*.pdb"]
+//
+//	[channels.windows]
+//	src = "build/windows"
+//	userversion-file = "VERSION"
+//
+//	[channels.linux-beta]
+//	src = "build/linux"
+//	target = "leafo/x-moon:linux-beta"
+//	hidden = true
+type Config struct {
+	// Project to push to, for channels that don't specify a full target
+	Target string `toml:"target"`
+	// Ignore patterns for all channels
+	Ignore []string `toml:"ignore"`
+
+	Channels map[string]*ChannelConfig `toml:"channels"`
+
+	// folder the config was loaded from, relative paths start there
+	dir string
+}
+
+type ChannelConfig struct {
+	// Folder (or archive) to push
+	Src string `toml:"src"`
+	// Where to push: a full project:channel spec, or just a project,
+	// in which case the channel is named after this entry.
+	Target string `toml:"target"`
+	// Ignore patterns, on top of the project's
+	Ignore []string `toml:"ignore"`
+
+	UserVersion     string `toml:"userversion"`
+	UserVersionFile string `toml:"userversion-file"`
+	// Where to derive the user version from, see --userversion-from.
+	// Relative paths start at the config's folder, and so does git.
+	UserVersionFrom string `toml:"userversion-from"`
+
+	Hidden         *bool `toml:"hidden"`
+	Dereference    *bool `toml:"dereference"`
+	FixPermissions *bool `toml:"fix-permissions"`
+}
+
+// Job is a single push: a source to a target channel.
+type Job struct {
+	// Name of the channel entry in the project config, if any
+	Name string
+
+	Src             string
+	Target          string
+	UserVersion     string
+	UserVersionFile string
+	UserVersionFrom string
+	// Refuse user versions that aren't semantic versions
+	SemVer bool
+	// Push even if the user version is older than the channel's
+	AllowOlderVersion bool
+	// Check the build can be launched before pushing, and only warn
+	// about problems if LintWarnOnly is set
+	Lint         bool
+	LintWarnOnly bool
+	// Ignore patterns, on top of --ignore
+	Ignore []string
+
+	FixPerms    bool
+	Dereference bool
+	IfChanged   bool
+	AutoWrap    bool
+	AutoUnzip   bool
+	Hidden      bool
+	// Don't keep a journal to resume the push if it's interrupted
+	NoResume bool
+	// Wait for the build to be processed, giving up after WaitTimeout
+	// (if non-zero)
+	Wait        bool
+	WaitTimeout time.Duration
+	// Write a bundle to this path instead of pushing, computed against
+	// ExportSignature (or as a first build)
+	Export          string
+	ExportSignature string
+}
+
+// FindConfig looks for a project config in dir and its parents.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for {
+		configPath := filepath.Join(dir, ConfigFileName)
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.Errorf("no %s found in the current directory or its parents. Pass both src and target, or use --config", ConfigFileName)
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig parses a project config.
+func LoadConfig(configPath string) (*Config, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	config := &Config{}
+	md, err := toml.DecodeFile(absPath, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", configPath)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		var keys []string
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return nil, errors.Errorf("%s: unknown keys: %s", configPath, strings.Join(keys, ", "))
+	}
+
+	config.dir = filepath.Dir(absPath)
+	return config, nil
+}
+
+// ChannelNames returns the names of all configured channels, sorted.
+func (c *Config) ChannelNames() []string {
+	var names []string
+	for name := range c.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Jobs resolves configured channels into jobs, in the order given.
+// Settings missing from the config are taken from defaults (the
+// command-line flags), except for the user version and --hidden,
+// where the command line wins.
+func (c *Config) Jobs(names []string, defaults Job) ([]Job, error) {
+	if len(c.Channels) == 0 {
+		return nil, errors.New("project config has no channels")
+	}
+
+	var jobs []Job
+	for _, name := range names {
+		cc, ok := c.Channels[name]
+		if !ok {
+			return nil, errors.Errorf("no channel named (%s) in project config, known channels: %s", name, strings.Join(c.ChannelNames(), ", "))
+		}
+
+		job := defaults
+		job.Name = name
+
+		if cc.Src == "" {
+			return nil, errors.Errorf("channel (%s): src is required", name)
+		}
+		job.Src = c.resolvePath(cc.Src)
+
+		target := cc.Target
+		if target == "" {
+			target = c.Target
+		}
+		if target == "" {
+			return nil, errors.Errorf("channel (%s): target is required, either for the channel or the whole project", name)
+		}
+		if !strings.Contains(target, ":") {
+			target = fmt.Sprintf("%s:%s", target, name)
+		}
+		job.Target = target
+
+		job.Ignore = append(append(append([]string{}, defaults.Ignore...), c.Ignore...), cc.Ignore...)
+
+		if job.UserVersion == "" && job.UserVersionFile == "" && job.UserVersionFrom == "" {
+			job.UserVersion = cc.UserVersion
+			if cc.UserVersionFile != "" {
+				job.UserVersionFile = c.resolvePath(cc.UserVersionFile)
+			}
+			if cc.UserVersionFrom != "" {
+				src, err := parseUserVersionSource(cc.UserVersionFrom)
+				if err != nil {
+					return nil, errors.Wrapf(err, "channel (%s)", name)
+				}
+				if src.kind != "env" {
+					src.path = c.resolvePath(src.path)
+				}
+				job.UserVersionFrom = src.String()
+			}
+		}
+
+		// --hidden can only ask for hiding, the config can't undo it
+		if cc.Hidden != nil && !job.Hidden {
+			job.Hidden = *cc.Hidden
+		}
+		if cc.Dereference != nil {
+			job.Dereference = *cc.Dereference
+		}
+		if cc.FixPermissions != nil {
+			job.FixPerms = *cc.FixPermissions
+		}
+
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (c *Config) resolvePath(p string) string {
+	if filepath.IsAbs(p) || c.dir == "" {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
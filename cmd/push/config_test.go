@@ -0,0 +1,131 @@
+package push
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+target = "user/mygame"
+ignore = ["*.pdb"]
+
+[channels.windows]
+src = "build/windows"
+userversion-file = "VERSION"
+hidden = false
+
+[channels.linux-beta]
+src = "/builds/linux"
+target = "user/mygame:linux-beta"
+ignore = ["*.debug"]
+hidden = true
+fix-permissions = false
+`
+
+func writeTestConfig(t *testing.T, contents string) string {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ConfigFileName)
+	require.NoError(t, os.WriteFile(configPath, []byte(contents), 0o644))
+	return configPath
+}
+
+func Test_ConfigJobs(t *testing.T) {
+	configPath := writeTestConfig(t, testConfig)
+	dir := filepath.Dir(configPath)
+
+	config, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	require.Equal(t, []string{"linux-beta", "windows"}, config.ChannelNames())
+
+	defaults := Job{FixPerms: true, AutoUnzip: true, Ignore: []string{"*.log"}}
+	jobs, err := config.Jobs(config.ChannelNames(), defaults)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+
+	beta := jobs[0]
+	require.Equal(t, "linux-beta", beta.Name)
+	require.Equal(t, "/builds/linux", beta.Src)
+	require.Equal(t, "user/mygame:linux-beta", beta.Target)
+	require.Equal(t, []string{"*.log", "*.pdb", "*.debug"}, beta.Ignore)
+	require.True(t, beta.Hidden)
+	require.False(t, beta.FixPerms)
+	require.True(t, beta.AutoUnzip)
+
+	windows := jobs[1]
+	require.Equal(t, filepath.Join(dir, "build/windows"), windows.Src)
+	require.Equal(t, "user/mygame:windows", windows.Target)
+	require.Equal(t, filepath.Join(dir, "VERSION"), windows.UserVersionFile)
+	require.False(t, windows.Hidden)
+	require.True(t, windows.FixPerms)
+
+	// the command line wins for user versions
+	defaults.UserVersion = "1.2.3"
+	jobs, err = config.Jobs([]string{"windows"}, defaults)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.3", jobs[0].UserVersion)
+	require.Empty(t, jobs[0].UserVersionFile)
+
+	// and for --hidden
+	defaults.Hidden = true
+	jobs, err = config.Jobs([]string{"windows"}, defaults)
+	require.NoError(t, err)
+	require.True(t, jobs[0].Hidden)
+
+	_, err = config.Jobs([]string{"mac"}, defaults)
+	require.Error(t, err)
+}
+
+func Test_ConfigErrors(t *testing.T) {
+	_, err := LoadConfig(writeTestConfig(t, "[channels.windows]\nsource = \"build\"\n"))
+	require.Error(t, err, "unknown keys are reported")
+
+	config, err := LoadConfig(writeTestConfig(t, "[channels.windows]\nsrc = \"build\"\n"))
+	require.NoError(t, err)
+	_, err = config.Jobs([]string{"windows"}, Job{})
+	require.Error(t, err, "target is required")
+}
+
+func Test_ConfigUserVersionFrom(t *testing.T) {
+	configPath := writeTestConfig(t, `
+target = "user/mygame"
+
+[channels.windows]
+src = "build"
+userversion-from = "json:package.json#version"
+
+[channels.linux]
+src = "build"
+userversion-from = "git"
+
+[channels.mac]
+src = "build"
+userversion-from = "env:${VERSION}"
+`)
+	dir := filepath.Dir(configPath)
+
+	config, err := LoadConfig(configPath)
+	require.NoError(t, err)
+	jobs, err := config.Jobs([]string{"windows", "linux", "mac"}, Job{})
+	require.NoError(t, err)
+	require.Equal(t, "json:"+filepath.Join(dir, "package.json")+"#version", jobs[0].UserVersionFrom)
+	require.Equal(t, "git:"+dir, jobs[1].UserVersionFrom)
+	require.Equal(t, "env:${VERSION}", jobs[2].UserVersionFrom)
+
+	config, err = LoadConfig(writeTestConfig(t, "[channels.windows]\nsrc = \"build\"\ntarget = \"user/mygame\"\nuserversion-from = \"svn\"\n"))
+	require.NoError(t, err)
+	_, err = config.Jobs([]string{"windows"}, Job{})
+	require.Error(t, err, "unknown sources are reported")
+}
+
+func Test_FindConfig(t *testing.T) {
+	configPath := writeTestConfig(t, testConfig)
+	nested := filepath.Join(filepath.Dir(configPath), "build", "windows")
+	require.NoError(t, os.MkdirAll(nested, 0o755))
+
+	found, err := FindConfig(nested)
+	require.NoError(t, err)
+	require.Equal(t, configPath, found)
+}
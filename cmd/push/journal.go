@@ -0,0 +1,295 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/lake/tlc"
+	"github.com/pkg/errors"
+)
+
+// A pushJournal records a push that's uploading, so that if butler dies
+// (or loses connectivity for too long) halfway through, pushing the same
+// source to the same target again resumes the build upload instead of
+// creating a new build and starting from scratch.
+//
+// Journals are kept next to the credentials file, in the push-journals
+// folder, and removed once a push succeeds.
+type pushJournal struct {
+	Src         string `json:"src"`
+	Target      string `json:"target"`
+	UserVersion string `json:"userVersion"`
+	Hidden      bool   `json:"hidden"`
+	// Identifies the contents of the source, see sourceFingerprint
+	Fingerprint string `json:"fingerprint"`
+
+	BuildID  int64 `json:"buildId"`
+	ParentID int64 `json:"parentId"`
+
+	Patch     journalFile `json:"patch"`
+	Signature journalFile `json:"signature"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// journalFile is the state of one of the build's uploads
+type journalFile struct {
+	FileID    int64  `json:"fileId"`
+	UploadURL string `json:"uploadUrl"`
+
+	// Bytes the storage server has committed, and the sha256 of those
+	// bytes, to make sure the regenerated file starts the same way.
+	Committed     int64  `json:"committed"`
+	CommittedHash string `json:"committedHash"`
+	// Set once the last byte is committed
+	Complete bool `json:"complete"`
+	// Set once the build file is finalized on the itch.io side
+	Finalized bool `json:"finalized"`
+}
+
+// journalPath returns where the journal for pushing src to target lives
+func journalPath(identity string, src string, target string) (string, error) {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	sum := sha256.Sum256([]byte(absSrc + "\n" + target))
+	name := hex.EncodeToString(sum[:8]) + ".json"
+	return filepath.Join(filepath.Dir(identity), "push-journals", name), nil
+}
+
+// loadJournal reads a journal, returning nil if there's none
+func loadJournal(journalPath string) (*pushJournal, error) {
+	buf, err := os.ReadFile(journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	j := &pushJournal{}
+	err = json.Unmarshal(buf, j)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing push journal (%s)", journalPath)
+	}
+	j.path = journalPath
+	return j, nil
+}
+
+// save writes the journal, replacing any previous version atomically.
+// Journals without a path (see --no-resume) aren't saved.
+func (j *pushJournal) save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.path == "" {
+		return nil
+	}
+
+	j.UpdatedAt = time.Now().UTC()
+	buf, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(j.path), 0o755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tmpPath := j.path + ".tmp"
+	err = os.WriteFile(tmpPath, buf, 0o600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpPath, j.path))
+}
+
+// update changes a journal entry and saves it
+func (j *pushJournal) update(f func()) error {
+	j.mutex.Lock()
+	f()
+	j.mutex.Unlock()
+	return j.save()
+}
+
+func (j *pushJournal) remove() error {
+	if j.path == "" {
+		return nil
+	}
+	err := os.Remove(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// resumable returns true if the journal has a build to resume
+func (j *pushJournal) resumable() bool {
+	return j != nil && j.path != "" && j.BuildID != 0
+}
+
+// reset forgets the journal's build, so it can be reused for a new one
+func (j *pushJournal) reset() {
+	j.BuildID = 0
+	j.ParentID = 0
+	j.Patch = journalFile{}
+	j.Signature = journalFile{}
+}
+
+// mismatch returns why a journal can't be used to resume a push of job,
+// or an empty string if it can.
+func (j *pushJournal) mismatch(job Job, userVersion string, fingerprint string) string {
+	switch {
+	case j.UserVersion != userVersion:
+		return "the user version changed"
+	case j.Hidden != job.Hidden:
+		return "--hidden changed"
+	case j.Fingerprint != fingerprint:
+		return "the source changed"
+	}
+	return ""
+}
+
+// sourceFingerprint identifies the contents of a walked source by the
+// path, size, mode and modification time of each entry. When the source
+// is an archive, that of the archive itself is used instead.
+func sourceFingerprint(buildPath string, container *tlc.Container) (string, error) {
+	h := sha256.New()
+
+	stats, err := os.Stat(buildPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !stats.IsDir() {
+		fmt.Fprintf(h, "archive %d %d\n", stats.Size(), stats.ModTime().UnixNano())
+	}
+
+	var lines []string
+	for _, d := range container.Dirs {
+		lines = append(lines, fmt.Sprintf("dir %s %o", d.Path, d.Mode))
+	}
+	for _, s := range container.Symlinks {
+		lines = append(lines, fmt.Sprintf("symlink %s %o %s", s.Path, s.Mode, s.Dest))
+	}
+	for _, f := range container.Files {
+		line := fmt.Sprintf("file %s %o %d", f.Path, f.Mode, f.Size)
+		if stats.IsDir() {
+			fileStats, err := os.Stat(filepath.Join(buildPath, filepath.FromSlash(f.Path)))
+			if err != nil {
+				return "", errors.WithStack(err)
+			}
+			line += fmt.Sprintf(" %d", fileStats.ModTime().UnixNano())
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		io.WriteString(h, line+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// openJournal returns the journal of an interrupted push of the same
+// source to the same target if it can be resumed, or a new journal.
+// Builds of journals that can't be resumed are marked as failed.
+func (s *session) openJournal(client *itchio.Client, r *reporter, job Job, channel string, buildPath string, userVersion string, container *tlc.Container) (*pushJournal, error) {
+	path, err := journalPath(s.ctx.Identity, job.Src, job.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := loadJournal(path)
+	if err != nil {
+		r.Warnf("Ignoring push journal: %s", err.Error())
+		previous = nil
+	}
+
+	if job.NoResume {
+		if previous != nil {
+			previous.abandon(s.ctx, client, channel, "--no-resume used")
+		}
+		return &pushJournal{}, nil
+	}
+
+	fingerprint, err := sourceFingerprint(buildPath, container)
+	if err != nil {
+		return nil, errors.WithMessage(err, "fingerprinting source")
+	}
+
+	absSrc, err := filepath.Abs(job.Src)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if previous != nil && previous.BuildID != 0 {
+		reason := previous.mismatch(job, userVersion, fingerprint)
+		if reason == "" {
+			return previous, nil
+		}
+		r.Opf("Not resuming build %d, %s since it was interrupted", previous.BuildID, reason)
+		previous.abandon(s.ctx, client, channel, reason)
+	}
+
+	return &pushJournal{
+		Src:         absSrc,
+		Target:      job.Target,
+		UserVersion: userVersion,
+		Hidden:      job.Hidden,
+		Fingerprint: fingerprint,
+		path:        path,
+	}, nil
+}
+
+// abandon marks the journal's build as failed and removes the journal
+func (j *pushJournal) abandon(ctx *mansion.Context, client *itchio.Client, channel string, reason string) {
+	if j.BuildID != 0 {
+		reportBuildFailure(ctx, client, j.BuildID, channel, errors.Errorf("interrupted push not resumed: %s", reason))
+	}
+	j.remove()
+}
+
+// checkResumable makes sure the journal's build is still waiting for its
+// files. It returns true if the build was in fact fully uploaded before
+// the interruption, and resets the journal if it can't be resumed.
+func (s *session) checkResumable(client *itchio.Client, r *reporter, j *pushJournal, channel string) (bool, error) {
+	requestCtx, cancel := s.ctx.DefaultCtx()
+	buildRes, err := client.GetBuild(requestCtx, itchio.GetBuildParams{
+		BuildID: j.BuildID,
+	})
+	cancel()
+	if err != nil {
+		return false, errors.Wrapf(err, "looking up interrupted build %d", j.BuildID)
+	}
+
+	build := buildRes.Build
+	switch build.State {
+	case itchio.BuildStateStarted:
+		return false, nil
+	case itchio.BuildStateQueued, itchio.BuildStateProcessing, itchio.BuildStateCompleted:
+		if j.Patch.Finalized && j.Signature.Finalized {
+			r.Statf("Build %d was fully uploaded before the interruption, nothing left to push", j.BuildID)
+			j.remove()
+			return true, nil
+		}
+	}
+
+	r.Opf("Interrupted build %d is %s, starting over", j.BuildID, build.State)
+	j.reset()
+	return false, j.save()
+}
@@ -0,0 +1,454 @@
+package push
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/itchio/httpkit/retrycontext"
+	"github.com/itchio/httpkit/timeout"
+	"github.com/pkg/errors"
+)
+
+const (
+	// storage servers only accept uploads in multiples of this, except
+	// for the last chunk
+	uploadChunkSize = 256 * 1024
+	// how much we send per request, 16MiB, like httpkit's uploader
+	uploadGroupSize = 64 * uploadChunkSize
+)
+
+// uploadRetrySettings is how hard each request is retried, the tests
+// lower it so failures don't take minutes.
+var uploadRetrySettings = retrycontext.Settings{
+	MaxTries: 15,
+}
+
+// errJournalMismatch is returned when a resumed upload doesn't regenerate
+// the same bytes the storage server already has.
+var errJournalMismatch = errors.New("regenerated data doesn't match what was uploaded before the interruption")
+
+// errUploadAborted is returned by writes after abort
+var errUploadAborted = errors.New("upload aborted")
+
+// An interruptedError is an upload that stopped because the storage
+// server couldn't be reached (or kept failing), the push journal lets
+// a later push continue it. Anything else means the upload can't go on.
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.err
+}
+
+// isInterrupted returns true if err (or any error it wraps) is an
+// interruptedError
+func isInterrupted(err error) bool {
+	var ie *interruptedError
+	return errors.As(err, &ie)
+}
+
+// journaledUpload writes to a resumable upload session, like httpkit's
+// uploader, but records what the server has committed in a push journal.
+// When resuming, the bytes the server already has are hashed (to make
+// sure they're the same) and skipped instead of being sent again.
+type journaledUpload struct {
+	journal *pushJournal
+	file    *journalFile
+
+	httpClient       *http.Client
+	consumer         *state.Consumer
+	progressListener func(count int64)
+
+	// bytes written to us so far
+	written int64
+	// bytes the server had committed before we started
+	skip int64
+	// bytes recorded in the journal, and their hash, checked while skipping
+	checkpoint     int64
+	checkpointHash string
+	// set when the server has all of the file but the journal didn't know yet
+	unjournaledComplete bool
+
+	// hash of all bytes up to `written` (while skipping) or up to
+	// `committed` (once uploading), only accessed by one side at a time
+	hash hash.Hash
+	// bytes the server has committed, only accessed by the worker
+	committed int64
+
+	pending []byte
+	groups  chan *uploadGroup
+	done    chan struct{}
+	closed  bool
+
+	errMutex sync.Mutex
+	err      error
+}
+
+type uploadGroup struct {
+	data []byte
+	last bool
+}
+
+// newJournaledUpload queries the session's state and returns a writer
+// that continues it.
+func newJournaledUpload(journal *pushJournal, file *journalFile, consumer *state.Consumer) (*journaledUpload, error) {
+	ju := &journaledUpload{
+		journal:        journal,
+		file:           file,
+		httpClient:     timeout.NewClient(30*time.Second, 60*time.Second),
+		consumer:       consumer,
+		checkpoint:     file.Committed,
+		checkpointHash: file.CommittedHash,
+		hash:           sha256.New(),
+		groups:         make(chan *uploadGroup, 1),
+		done:           make(chan struct{}),
+	}
+
+	if file.Committed > 0 || file.Complete {
+		if file.Complete {
+			ju.skip = -1
+		} else {
+			committed, complete, err := ju.queryStatus()
+			if err != nil {
+				return nil, errors.WithMessage(err, "querying upload status")
+			}
+			if complete {
+				ju.skip = -1
+				ju.unjournaledComplete = true
+			} else {
+				if committed < file.Committed {
+					return nil, errors.Errorf("server has fewer bytes (%d) than the journal says it committed (%d)", committed, file.Committed)
+				}
+				ju.skip = committed
+			}
+		}
+	}
+
+	go ju.work()
+	return ju, nil
+}
+
+func (ju *journaledUpload) SetProgressListener(progressListener func(count int64)) {
+	ju.progressListener = progressListener
+}
+
+// Skipped returns how many bytes didn't need to be sent again
+func (ju *journaledUpload) Skipped() int64 {
+	if ju.skip < 0 {
+		return ju.written
+	}
+	return ju.skip
+}
+
+// Write implements io.Writer
+func (ju *journaledUpload) Write(buf []byte) (int, error) {
+	if err := ju.checkError(); err != nil {
+		return 0, err
+	}
+	n := len(buf)
+
+	// the server already has the beginning (or all) of the file
+	if ju.skip < 0 || ju.written < ju.skip {
+		skipped := len(buf)
+		if ju.skip >= 0 && int64(skipped) > ju.skip-ju.written {
+			skipped = int(ju.skip - ju.written)
+		}
+		err := ju.hashSkipped(buf[:skipped])
+		if err != nil {
+			return 0, err
+		}
+		if ju.progressListener != nil {
+			ju.progressListener(ju.written)
+		}
+		buf = buf[skipped:]
+		if len(buf) == 0 {
+			return n, nil
+		}
+	}
+
+	ju.pending = append(ju.pending, buf...)
+	ju.written += int64(len(buf))
+	for len(ju.pending) >= uploadGroupSize {
+		group := append([]byte{}, ju.pending[:uploadGroupSize]...)
+		ju.pending = ju.pending[uploadGroupSize:]
+		if err := ju.send(&uploadGroup{data: group}); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// hashSkipped hashes skipped bytes, checking them against the journal
+// when reaching its checkpoint
+func (ju *journaledUpload) hashSkipped(buf []byte) error {
+	if ju.written < ju.checkpoint && ju.written+int64(len(buf)) >= ju.checkpoint {
+		before := int(ju.checkpoint - ju.written)
+		ju.hash.Write(buf[:before])
+		if hex.EncodeToString(ju.hash.Sum(nil)) != ju.checkpointHash {
+			return errJournalMismatch
+		}
+		ju.hash.Write(buf[before:])
+	} else {
+		ju.hash.Write(buf)
+	}
+	ju.written += int64(len(buf))
+	return nil
+}
+
+func (ju *journaledUpload) send(group *uploadGroup) error {
+	select {
+	case ju.groups <- group:
+		return nil
+	case <-ju.done:
+		if err := ju.checkError(); err != nil {
+			return err
+		}
+		return errors.New("upload stopped")
+	}
+}
+
+// abort stops the upload without completing it, and waits for the
+// worker to be done. It does nothing if the upload was already closed.
+func (ju *journaledUpload) abort() {
+	if ju.closed {
+		return
+	}
+	ju.closed = true
+
+	ju.setError(errUploadAborted)
+	close(ju.groups)
+	<-ju.done
+}
+
+// Close sends what's left and completes the upload
+func (ju *journaledUpload) Close() error {
+	if ju.closed {
+		return ju.checkError()
+	}
+	ju.closed = true
+
+	if ju.skip < 0 {
+		close(ju.groups)
+		<-ju.done
+
+		if ju.unjournaledComplete {
+			// we only know the hash of the beginning
+			if ju.written < ju.checkpoint {
+				return errJournalMismatch
+			}
+			sum := hex.EncodeToString(ju.hash.Sum(nil))
+			return ju.journal.update(func() {
+				ju.file.Committed = ju.written
+				ju.file.CommittedHash = sum
+				ju.file.Complete = true
+			})
+		}
+
+		// make sure we regenerated all of it
+		if ju.written != ju.checkpoint {
+			return errJournalMismatch
+		}
+		return nil
+	}
+
+	if ju.written < ju.checkpoint {
+		return errJournalMismatch
+	}
+
+	err := ju.send(&uploadGroup{data: ju.pending, last: true})
+	ju.pending = nil
+	close(ju.groups)
+	<-ju.done
+	if err != nil {
+		return err
+	}
+	return ju.checkError()
+}
+
+func (ju *journaledUpload) work() {
+	defer close(ju.done)
+	if ju.skip > 0 {
+		ju.committed = ju.skip
+	}
+
+	for group := range ju.groups {
+		if ju.checkError() != nil {
+			// aborted, don't send anything else
+			continue
+		}
+		err := ju.put(group.data, group.last)
+		if err != nil {
+			ju.setError(err)
+			// drain so writers don't block
+			for range ju.groups {
+			}
+			return
+		}
+	}
+}
+
+// put uploads data at the current offset, retrying and picking up
+// partial commits as needed.
+func (ju *journaledUpload) put(data []byte, last bool) error {
+	settings := uploadRetrySettings
+	settings.Consumer = ju.consumer
+	retryCtx := retrycontext.New(settings)
+
+	for retryCtx.ShouldTry() {
+		committed, complete, err := ju.tryPut(data, last)
+		if err != nil {
+			retryCtx.Retry(err)
+			// find out where the server's at before trying again
+			committed, complete, err = ju.queryStatus()
+			if err != nil {
+				continue
+			}
+		}
+
+		end := ju.committed + int64(len(data))
+		if complete {
+			committed = end
+		}
+		if committed < ju.committed || committed > end {
+			return errors.Errorf("server committed unexpected range (%d bytes, expected between %d and %d)", committed, ju.committed, end)
+		}
+
+		newBytes := committed - ju.committed
+		err = ju.commit(data[:newBytes], complete)
+		if err != nil {
+			return err
+		}
+		data = data[newBytes:]
+
+		if complete || (!last && len(data) == 0) {
+			return nil
+		}
+		if newBytes == 0 {
+			retryCtx.Retry(errors.Errorf("Commit failed (retrying %d bytes)", len(data)))
+		}
+	}
+	return &interruptedError{errors.Errorf("Too many errors, stopping upload")}
+}
+
+// commit records that the server has committed more bytes
+func (ju *journaledUpload) commit(data []byte, complete bool) error {
+	ju.hash.Write(data)
+	ju.committed += int64(len(data))
+	if ju.progressListener != nil {
+		ju.progressListener(ju.committed)
+	}
+
+	sum := hex.EncodeToString(ju.hash.Sum(nil))
+	return ju.journal.update(func() {
+		ju.file.Committed = ju.committed
+		ju.file.CommittedHash = sum
+		ju.file.Complete = complete
+	})
+}
+
+// tryPut sends data, and returns how many bytes the server has committed
+// in total, and whether the upload is complete
+func (ju *journaledUpload) tryPut(data []byte, last bool) (int64, bool, error) {
+	start := ju.committed
+	end := start + int64(len(data)) - 1
+
+	req, err := http.NewRequest("PUT", ju.file.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	req.ContentLength = int64(len(data))
+
+	switch {
+	case last && len(data) == 0:
+		req.Header.Set("content-range", fmt.Sprintf("bytes */%d", start))
+	case last:
+		req.Header.Set("content-range", fmt.Sprintf("bytes %d-%d/%d", start, end, end+1))
+	default:
+		req.Header.Set("content-range", fmt.Sprintf("bytes %d-%d/*", start, end))
+	}
+
+	res, err := ju.httpClient.Do(req)
+	if err != nil {
+		return 0, false, &interruptedError{errors.WithStack(err)}
+	}
+	res.Body.Close()
+	return interpretUploadStatus(res)
+}
+
+// queryStatus asks the server how many bytes it has committed
+func (ju *journaledUpload) queryStatus() (int64, bool, error) {
+	req, err := http.NewRequest("PUT", ju.file.UploadURL, nil)
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	req.Header.Set("content-range", "bytes */*")
+
+	res, err := ju.httpClient.Do(req)
+	if err != nil {
+		return 0, false, &interruptedError{errors.WithStack(err)}
+	}
+	res.Body.Close()
+	return interpretUploadStatus(res)
+}
+
+func interpretUploadStatus(res *http.Response) (int64, bool, error) {
+	switch res.StatusCode {
+	case 200, 201:
+		return 0, true, nil
+	case 308:
+		committed, err := parseCommittedRange(res.Header.Get("Range"))
+		return committed, false, err
+	case 404, 410:
+		return 0, false, errors.Errorf("upload session expired (HTTP %s)", res.Status)
+	}
+	err := errors.Errorf("got HTTP %s", res.Status)
+	if res.StatusCode == 429 || res.StatusCode >= 500 {
+		return 0, false, &interruptedError{err}
+	}
+	return 0, false, err
+}
+
+// parseCommittedRange parses a `bytes=0-N` header into the number of
+// bytes committed. No header means nothing was committed yet.
+func parseCommittedRange(rangeHeader string) (int64, error) {
+	if rangeHeader == "" {
+		return 0, nil
+	}
+	startEnd := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	if len(startEnd) != 2 || startEnd[0] != "0" {
+		return 0, errors.Errorf("invalid range header (%s)", rangeHeader)
+	}
+	end, err := strconv.ParseInt(startEnd[1], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid range header (%s)", rangeHeader)
+	}
+	return end + 1, nil
+}
+
+func (ju *journaledUpload) checkError() error {
+	ju.errMutex.Lock()
+	defer ju.errMutex.Unlock()
+	return ju.err
+}
+
+func (ju *journaledUpload) setError(err error) {
+	ju.errMutex.Lock()
+	defer ju.errMutex.Unlock()
+	if ju.err == nil {
+		ju.err = err
+	}
+}
@@ -0,0 +1,230 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/itchio/httpkit/retrycontext"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStorage is a resumable upload session that can be told to stop
+// accepting data after a number of bytes, to simulate an interruption.
+type fakeStorage struct {
+	mutex    sync.Mutex
+	data     []byte
+	complete bool
+	received int64
+	limit    int64
+}
+
+func (fs *fakeStorage) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	contentRange := strings.TrimPrefix(req.Header.Get("content-range"), "bytes ")
+	rangeSpec, total, _ := strings.Cut(contentRange, "/")
+
+	if rangeSpec != "*" {
+		if fs.limit > 0 && fs.received+int64(len(body)) > fs.limit {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		startStr, _, _ := strings.Cut(rangeSpec, "-")
+		start, _ := strconv.ParseInt(startStr, 10, 64)
+		if start != int64(len(fs.data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fs.data = append(fs.data, body...)
+		fs.received += int64(len(body))
+	}
+
+	if total != "*" {
+		size, _ := strconv.ParseInt(total, 10, 64)
+		if size == int64(len(fs.data)) {
+			fs.complete = true
+		}
+	}
+	if fs.complete {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(fs.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(fs.data)-1))
+	}
+	w.WriteHeader(308)
+}
+
+func uploadAll(t *testing.T, j *pushJournal, payload []byte) (*journaledUpload, error) {
+	ju, err := newJournaledUpload(j, &j.Patch, nil)
+	require.NoError(t, err)
+
+	// write in odd-sized pieces, like the patch writer does
+	for offset := 0; offset < len(payload); {
+		n := 100*1024 + rand.Intn(4*1024*1024)
+		if offset+n > len(payload) {
+			n = len(payload) - offset
+		}
+		_, err := ju.Write(payload[offset : offset+n])
+		if err != nil {
+			return ju, err
+		}
+		offset += n
+	}
+	return ju, ju.Close()
+}
+
+// lowerUploadRetries makes failed uploads give up right away, it
+// returns a func that restores the regular settings.
+func lowerUploadRetries() func() {
+	before := uploadRetrySettings
+	uploadRetrySettings = retrycontext.Settings{
+		MaxTries: 2,
+		NoSleep:  true,
+	}
+	return func() { uploadRetrySettings = before }
+}
+
+func Test_JournaledUploadResume(t *testing.T) {
+	payload := make([]byte, 50*1024*1024+1234)
+	rand.New(rand.NewSource(0)).Read(payload)
+
+	storage := &fakeStorage{limit: 2 * uploadGroupSize}
+	server := httptest.NewServer(storage)
+	defer server.Close()
+
+	journalPath := filepath.Join(t.TempDir(), "journal.json")
+	j := &pushJournal{
+		BuildID: 123,
+		Patch:   journalFile{UploadURL: server.URL},
+		path:    journalPath,
+	}
+	require.NoError(t, j.save())
+
+	// the storage server stops accepting data after two groups
+	defer lowerUploadRetries()()
+	_, err := uploadAll(t, j, payload)
+	require.Error(t, err)
+	require.True(t, isInterrupted(err))
+
+	j, err = loadJournal(journalPath)
+	require.NoError(t, err)
+	require.EqualValues(t, 2*uploadGroupSize, j.Patch.Committed)
+	require.False(t, j.Patch.Complete)
+
+	// resuming only sends the rest
+	storage.limit = 0
+	storage.received = 0
+	ju, err := uploadAll(t, j, payload)
+	require.NoError(t, err)
+	require.EqualValues(t, 2*uploadGroupSize, ju.Skipped())
+	require.EqualValues(t, len(payload)-2*uploadGroupSize, storage.received)
+	require.True(t, storage.complete)
+	require.True(t, bytes.Equal(payload, storage.data))
+
+	j, err = loadJournal(journalPath)
+	require.NoError(t, err)
+	require.True(t, j.Patch.Complete)
+	require.EqualValues(t, len(payload), j.Patch.Committed)
+
+	// resuming a complete upload sends nothing
+	storage.received = 0
+	_, err = uploadAll(t, j, payload)
+	require.NoError(t, err)
+	require.EqualValues(t, 0, storage.received)
+}
+
+func Test_JournaledUploadMismatch(t *testing.T) {
+	payload := make([]byte, 3*uploadGroupSize)
+	rand.New(rand.NewSource(0)).Read(payload)
+
+	storage := &fakeStorage{limit: uploadGroupSize}
+	server := httptest.NewServer(storage)
+	defer server.Close()
+
+	j := &pushJournal{
+		BuildID: 123,
+		Patch:   journalFile{UploadURL: server.URL},
+		path:    filepath.Join(t.TempDir(), "journal.json"),
+	}
+
+	defer lowerUploadRetries()()
+	_, err := uploadAll(t, j, payload)
+	require.Error(t, err)
+	require.EqualValues(t, uploadGroupSize, j.Patch.Committed)
+
+	// the regenerated file differs from what the server has
+	storage.limit = 0
+	payload[10] ^= 0xff
+	_, err = uploadAll(t, j, payload)
+	require.ErrorIs(t, err, errJournalMismatch)
+	require.False(t, isInterrupted(err))
+}
+
+func Test_JournaledUploadAbort(t *testing.T) {
+	storage := &fakeStorage{}
+	server := httptest.NewServer(storage)
+	defer server.Close()
+
+	j := &pushJournal{
+		BuildID: 123,
+		Patch:   journalFile{UploadURL: server.URL},
+		path:    filepath.Join(t.TempDir(), "journal.json"),
+	}
+
+	ju, err := newJournaledUpload(j, &j.Patch, nil)
+	require.NoError(t, err)
+	_, err = ju.Write(make([]byte, uploadGroupSize+1))
+	require.NoError(t, err)
+
+	// the worker is done once abort returns, and the upload isn't completed
+	ju.abort()
+	_, ok := <-ju.done
+	require.False(t, ok)
+	require.False(t, storage.complete)
+
+	_, err = ju.Write([]byte{1})
+	require.ErrorIs(t, err, errUploadAborted)
+	require.ErrorIs(t, ju.Close(), errUploadAborted)
+	ju.abort()
+}
+
+func Test_UploadStatusInterrupted(t *testing.T) {
+	for status, interrupted := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusGone:                false,
+	} {
+		_, _, err := interpretUploadStatus(&http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     http.Header{},
+		})
+		require.Error(t, err)
+		require.Equal(t, interrupted, isInterrupted(err), "HTTP %d", status)
+	}
+
+	// the storage server can't be reached at all
+	ju := &journaledUpload{
+		file:       &journalFile{UploadURL: "http://127.0.0.1:1/upload"},
+		httpClient: http.DefaultClient,
+	}
+	_, _, err := ju.queryStatus()
+	require.Error(t, err)
+	require.True(t, isInterrupted(err))
+}
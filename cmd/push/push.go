@@ -3,19 +3,22 @@ package push
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/itchio/httpkit/eos"
 	"github.com/itchio/httpkit/eos/option"
-	"github.com/itchio/httpkit/uploader"
 
 	itchio "github.com/itchio/go-itchio"
 
+	"github.com/itchio/butler/bandwidth"
 	"github.com/itchio/butler/buildinfo"
+	"github.com/itchio/butler/cmd/auditzip"
+	"github.com/itchio/butler/cmd/validate"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
@@ -54,6 +57,11 @@ var args = struct {
 	target          string
 	userVersion     string
 	userVersionFile string
+	userVersionFrom string
+	semVer          bool
+	allowOlder      bool
+	lint            bool
+	lintLevel       string
 	fixPerms        bool
 	dereference     bool
 	ifChanged       bool
@@ -61,14 +69,28 @@ var args = struct {
 	autoWrap        bool
 	autoUnzip       bool
 	hidden          bool
+	all             bool
+	config          string
+	pairs           []string
+	maxConcurrent   int
+	maxBandwidth    int64
+	noResume        bool
+	wait            bool
+	waitTimeout     time.Duration
+	export          string
+	exportSignature string
 }{}
 
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("push", "Upload a new build to itch.io. See `butler help push`.")
-	cmd.Arg("src", "Directory to upload. May also be a zip archive (slower)").Required().StringVar(&args.src)
-	cmd.Arg("target", "Where to push, for example 'leafo/x-moon:win-64'. Targets are of the form project:channel, where project is username/game or game_id.").Required().StringVar(&args.target)
+	cmd.Arg("src", "Directory to upload. May also be a zip archive (slower). When target is omitted, the name of a channel configured in butler.toml").StringVar(&args.src)
+	cmd.Arg("target", "Where to push, for example 'leafo/x-moon:win-64'. Targets are of the form project:channel, where project is username/game or game_id.").StringVar(&args.target)
+	cmd.Arg("pairs", "More src and target pairs, pushed in the same run").StringsVar(&args.pairs)
 	cmd.Flag("userversion", "A user-supplied version number that you can later query builds by").StringVar(&args.userVersion)
 	cmd.Flag("userversion-file", "A file containing a user-supplied version number that you can later query builds by").StringVar(&args.userVersionFile)
+	cmd.Flag("userversion-from", "Where to derive the user version from: 'git' (git describe), 'json:file#key.path', 'toml:file#key.path' or 'env:template' (like 'env:1.0.${BUILD_NUMBER}')").PlaceHolder("SOURCE").StringVar(&args.userVersionFrom)
+	cmd.Flag("semver", "Refuse to push if the user version isn't a semantic version, like 1.4.2").Default("false").BoolVar(&args.semVer)
+	cmd.Flag("allow-older-version", "Push even if the user version is older than the one of the channel's latest build").Default("false").BoolVar(&args.allowOlder)
 	cmd.Flag("fix-permissions", "Detect Mac & Linux executables and adjust their permissions automatically").Default("true").BoolVar(&args.fixPerms)
 	cmd.Flag("dereference", "Dereference symlinks").Default("false").BoolVar(&args.dereference)
 	cmd.Flag("if-changed", "Don't push anything if it would be an empty patch").Default("false").BoolVar(&args.ifChanged)
@@ -76,90 +98,258 @@ func Register(ctx *mansion.Context) {
 	cmd.Flag("auto-wrap", "Apply workaround for https://github.com/itchio/itch/issues/2147").Default("true").BoolVar(&args.autoWrap)
 	cmd.Flag("auto-unzip", "If src is a directory containing a single .zip file, push the zip's contents instead of the zip-as-a-blob").Default("true").BoolVar(&args.autoUnzip)
 	cmd.Flag("hidden", "When pushing to a new channel, mark it as hidden so it's not immediately downloadable").Default("false").BoolVar(&args.hidden)
+	cmd.Flag("all", "Push every channel configured in butler.toml").Default("false").BoolVar(&args.all)
+	cmd.Flag("config", "Project config listing channels to push (default: butler.toml in the current directory or its parents)").StringVar(&args.config)
+	cmd.Flag("no-resume", "Don't resume an interrupted push of the same src to the same target, and don't keep what's needed to resume this one").Default("false").BoolVar(&args.noResume)
+	cmd.Flag("wait", "Wait for the build to be processed, and exit with an error if processing fails").Default("false").BoolVar(&args.wait)
+	cmd.Flag("wait-timeout", "With --wait, give up after this long (for example 30m), 0 waits forever").Default("30m").DurationVar(&args.waitTimeout)
+	cmd.Flag("export", "Don't push anything, write what would be uploaded to a bundle file instead, to upload later with `butler push-bundle`").PlaceHolder("BUNDLE").StringVar(&args.export)
+	cmd.Flag("export-signature", "With --export, signature of the channel's latest build to compute the patch against (see `butler sign`). Without it, the bundle is a first build").PlaceHolder("SIGNATURE").StringVar(&args.exportSignature)
+	cmd.Flag("lint", "Check that the build can be launched on the platforms its channel is for (like `butler validate`) before pushing it").Default("false").BoolVar(&args.lint)
+	cmd.Flag("lint-level", "With --lint, whether problems found stop the push (error) or are only shown (warn)").Default("error").EnumVar(&args.lintLevel, "error", "warn")
+	cmd.Flag("max-concurrent", "When pushing several channels, how many to push at the same time").Default("3").IntVar(&args.maxConcurrent)
+	cmd.Flag("max-bandwidth", "Use less than this many Kbps (kilobits per second) of bandwidth, shared by all channels being pushed. Lowers --throttle, never raises it").Default("0").Int64Var(&args.maxBandwidth)
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
 	go ctx.DoVersionCheck()
 
-	// if userVersionFile specified, read from the given file
-	// TODO: do utf-16 decoding here
-	userVersion := args.userVersion
-	if userVersion == "" && args.userVersionFile != "" {
-		buf, err := ioutil.ReadFile(args.userVersionFile)
-		ctx.Must(err)
+	defaults := Job{
+		Src:               args.src,
+		Target:            args.target,
+		UserVersion:       args.userVersion,
+		UserVersionFile:   args.userVersionFile,
+		UserVersionFrom:   args.userVersionFrom,
+		SemVer:            args.semVer,
+		AllowOlderVersion: args.allowOlder,
+		Lint:              args.lint,
+		LintWarnOnly:      args.lintLevel == "warn",
+		FixPerms:          args.fixPerms,
+		Dereference:       args.dereference,
+		IfChanged:         args.ifChanged,
+		AutoWrap:          args.autoWrap,
+		AutoUnzip:         args.autoUnzip,
+		Hidden:            args.hidden,
+		NoResume:          args.noResume,
+		Wait:              args.wait,
+		WaitTimeout:       args.waitTimeout,
+		Export:            args.export,
+		ExportSignature:   args.exportSignature,
+	}
+
+	versionSources := 0
+	for _, s := range []string{args.userVersion, args.userVersionFile, args.userVersionFrom} {
+		if s != "" {
+			versionSources++
+		}
+	}
+	if versionSources > 1 {
+		ctx.Must(errors.New("--userversion, --userversion-file and --userversion-from can't be combined, pick one"))
+	}
+
+	if args.maxBandwidth > 0 {
+		bandwidth.SetCap(args.maxBandwidth)
+		comm.Logf("Throttling to %s/s bandwidth", united.FormatBytes(bandwidth.Effective()*1024/8))
+	}
+
+	if args.all || args.target == "" {
+		ctx.Must(doConfig(ctx, defaults))
+		return
+	}
+
+	jobs := []Job{defaults}
+	if len(args.pairs)%2 != 0 {
+		ctx.Must(errors.Errorf("expected pairs of src and target, but (%s) has no target", args.pairs[len(args.pairs)-1]))
+	}
+	for i := 0; i < len(args.pairs); i += 2 {
+		job := defaults
+		job.Src = args.pairs[i]
+		job.Target = args.pairs[i+1]
+		jobs = append(jobs, job)
+	}
+
+	ctx.Must(newSession(ctx).pushAll(jobs, args.maxConcurrent))
+}
+
+// doConfig pushes channels from the project config, authenticating
+// only once.
+func doConfig(ctx *mansion.Context, defaults Job) error {
+	var names []string
+	if args.all {
+		if args.src != "" || len(args.pairs) > 0 {
+			return errors.New("--all pushes every configured channel, it can't be combined with a channel name")
+		}
+	} else {
+		if args.src == "" {
+			return errors.Errorf("missing src and target. To push channels configured in %s, pass a channel name or --all", ConfigFileName)
+		}
+		names = []string{args.src}
+	}
 
-		userVersion = strings.TrimSpace(string(buf))
-		if strings.ContainsAny(userVersion, "\r\n") {
-			ctx.Must(fmt.Errorf("%s contains line breaks, refusing to use as userversion", args.userVersionFile))
+	configPath := args.config
+	if configPath == "" {
+		var err error
+		configPath, err = FindConfig(".")
+		if err != nil {
+			return err
 		}
 	}
+	comm.Logf("Using project config (%s)", configPath)
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if args.all {
+		names = config.ChannelNames()
+	}
+
+	defaults.Src = ""
+	defaults.Target = ""
+	jobs, err := config.Jobs(names, defaults)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 1 {
+		comm.Opf("Pushing channel (%s): %s to %s", jobs[0].Name, jobs[0].Src, jobs[0].Target)
+	}
+	return newSession(ctx).pushAll(jobs, args.maxConcurrent)
+}
 
-	ctx.Must(Do(ctx, args.src, args.target, userVersion, args.fixPerms, args.dereference, args.ifChanged, args.autoWrap, args.autoUnzip, args.hidden))
+func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion string, fixPerms bool, dereference bool, ifChanged bool, wrap bool, autoUnzip bool, hidden bool) error {
+	return newSession(ctx).pushAll([]Job{{
+		Src:         buildPath,
+		Target:      specStr,
+		UserVersion: userVersion,
+		FixPerms:    fixPerms,
+		Dereference: dereference,
+		IfChanged:   ifChanged,
+		AutoWrap:    wrap,
+		AutoUnzip:   autoUnzip,
+		Hidden:      hidden,
+	}}, 1)
 }
 
-func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion string, fixPerms bool, dereference bool, ifChanged bool, wrap bool, autoUnzip bool, hidden bool) (retErr error) {
-	consumer := comm.NewStateConsumer()
+func (s *session) push(job Job, r *reporter) (outcome *pushOutcome, retErr error) {
+	ctx := s.ctx
+	consumer := r.Consumer()
+
+	buildPath := job.Src
+	specStr := job.Target
+	filter := filtering.FilterPathsWith(job.Ignore)
 
-	if autoUnzip {
-		buildPath = walkutil.ResolveSingleZipDir(buildPath, filtering.FilterPaths)
+	userVersion, err := readUserVersion(job)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.AutoUnzip {
+		buildPath = walkutil.ResolveSingleZipDir(buildPath, filter)
 	}
 
 	// Captured by the defer below so any error returned after CreateBuild
 	// succeeds gets reported back to the server as a build failure. Without
-	// this the build is left stuck in "started" state forever.
+	// this the build is left stuck in "started" state forever, unless the
+	// upload was interrupted and the journal lets us resume it.
 	var buildID int64
 	var client *itchio.Client
 	var channel string
+	var journal *pushJournal
+	// upload workers to stop before returning (or starting over)
+	var writers []*journaledUpload
+	abortWriters := func() {
+		for _, w := range writers {
+			w.abort()
+		}
+		writers = nil
+	}
+	defer abortWriters()
 	defer func() {
 		if retErr != nil && buildID != 0 && client != nil {
+			if journal.resumable() && isInterrupted(retErr) {
+				r.Logf("")
+				r.Logf("Build %d was left open: run the same push again to resume it, or use --no-resume to start over.", buildID)
+				r.Logf("")
+				return
+			}
 			reportBuildFailure(ctx, client, buildID, channel, retErr)
+			if journal.resumable() {
+				journal.remove()
+			}
 		}
 	}()
 
 	// start walking source container while waiting on auth flow
-	sourceContainerChan := make(chan walkResult)
-	walkErrs := make(chan error)
+	sourceContainerChan := make(chan walkResult, 1)
+	walkErrs := make(chan error, 1)
 	walkOpts := tlc.WalkOpts{
-		Filter:      filtering.FilterPaths,
-		Dereference: dereference,
+		Filter:      filter,
+		Dereference: job.Dereference,
 	}
-	if wrap {
+	if job.AutoWrap {
 		walkOpts.AutoWrap(&buildPath, consumer)
 	}
 
-	go doWalk(buildPath, sourceContainerChan, walkErrs, fixPerms, walkOpts)
+	go func() {
+		walkies, err := s.walk(buildPath, job.FixPerms, job.Ignore, walkOpts)
+		if err != nil {
+			walkErrs <- err
+			return
+		}
+		sourceContainerChan <- *walkies
+	}()
 
 	spec, err := itchio.ParseSpec(specStr)
 	if err != nil {
-		return errors.Wrapf(err, "parsing push target '%s'", specStr)
+		return nil, errors.Wrapf(err, "parsing push target '%s'", specStr)
 	}
 
 	err = spec.EnsureChannel()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	channel = spec.Channel
 
 	if args.dryRun {
-		comm.Opf("Dry run, listing files we would push...")
+		r.Opf("Dry run, listing files we would push...")
 		select {
 		case walkErr := <-walkErrs:
-			return errors.Wrap(walkErr, "walking directory to push")
+			return nil, errors.Wrap(walkErr, "walking directory to push")
 		case walkies := <-sourceContainerChan:
 			log := func(line string) {
-				comm.Logf("%s", line)
+				r.Logf("%s", line)
 			}
 			walkies.container.Print(log)
-			comm.Statf("Would push %s", walkies.container)
+			r.Statf("Would push %s", walkies.container)
 		}
-		pushResult(0, spec.Channel, true, false, "dry-run")
-		return nil
+		return &pushOutcome{Channel: spec.Channel, DryRun: true, Reason: "dry-run"}, nil
+	}
+
+	if job.Export != "" {
+		// exporting doesn't need the itch.io API at all
+		var walkies walkResult
+		select {
+		case walkErr := <-walkErrs:
+			return nil, errors.Wrap(walkErr, "walking directory to push")
+		case walkies = <-sourceContainerChan:
+		}
+
+		err = validateSource(r, buildPath, walkies.container)
+		if err != nil {
+			return nil, err
+		}
+		err = lintSource(job, r, buildPath, channel, walkies.container)
+		if err != nil {
+			return nil, err
+		}
+		return exportBundle(job, r, userVersion, walkies.container, walkies.pool)
 	}
 
-	client, err = ctx.AuthenticateViaOauth()
+	client, err = s.authenticate()
 	if err != nil {
-		return errors.Wrap(err, "authenticating")
+		return nil, errors.Wrap(err, "authenticating")
 	}
 
 	// Consume the walk result before any further API calls so an invalid
@@ -169,119 +359,187 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 	var sourcePool lake.Pool
 	select {
 	case walkErr := <-walkErrs:
-		return errors.Wrap(walkErr, "walking directory to push")
+		return nil, errors.Wrap(walkErr, "walking directory to push")
 	case walkies := <-sourceContainerChan:
 		sourceContainer = walkies.container
 		sourcePool = walkies.pool
 	}
 
-	showSingleFileWarningIfNecessary(sourceContainer)
+	err = validateSource(r, buildPath, sourceContainer)
+	if err != nil {
+		return nil, err
+	}
+	err = lintSource(job, r, buildPath, channel, sourceContainer)
+	if err != nil {
+		return nil, err
+	}
 
-	err = sourceContainer.Validate()
+	journal, err = s.openJournal(client, r, job, channel, buildPath, userVersion, sourceContainer)
 	if err != nil {
-		comm.Notice("Validation failed", []string{
-			fmt.Sprintf("(%s) cannot be pushed, because it is invalid.", buildPath),
-			"",
-			"If you're pushing a .zip file, try pushing a folder directly instead. Pushing a folder is not only faster, it eliminates a whole class of errors.",
-			"",
-			"The errors found during validation follow.",
-		})
-		comm.Logf("%s", err)
-		return errors.Wrap(err, "refusing to push invalid container")
+		return nil, err
+	}
+	resuming := journal.BuildID != 0
+
+	if resuming {
+		done, err := s.checkResumable(client, r, journal, channel)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return &pushOutcome{BuildID: journal.BuildID, Channel: spec.Channel}, nil
+		}
+		resuming = journal.BuildID != 0
 	}
 
-	if ifChanged {
+	// restart abandons a build we couldn't resume and pushes from scratch
+	restart := func(err error) (*pushOutcome, error) {
+		r.Warnf("Could not resume build %d (%s), starting over", buildID, err.Error())
+		abortWriters()
+		reportBuildFailure(ctx, client, buildID, channel, err)
+		journal.remove()
+		buildID = 0
+		return s.push(job, r)
+	}
+
+	if !resuming && !job.AllowOlderVersion && userVersion != "" {
 		requestCtx, cancel := ctx.DefaultCtx()
 		chanInfo, err := client.GetChannel(requestCtx, spec.Target, spec.Channel)
 		cancel()
 		if err == nil && chanInfo != nil && chanInfo.Channel != nil && chanInfo.Channel.Head != nil {
-			comm.Opf("Comparing against previous build...")
-			sig, err := getSignature(ctx, client, consumer, chanInfo.Channel.Head.ID)
+			head := chanInfo.Channel.Head
+			err = checkNotOlder(userVersion, head.UserVersion, head.ID)
 			if err != nil {
-				return errors.Wrap(err, "getting previous build signature")
+				return nil, err
+			}
+		}
+	}
+
+	if job.IfChanged && !resuming {
+		requestCtx, cancel := ctx.DefaultCtx()
+		chanInfo, err := client.GetChannel(requestCtx, spec.Target, spec.Channel)
+		cancel()
+		if err == nil && chanInfo != nil && chanInfo.Channel != nil && chanInfo.Channel.Head != nil {
+			r.Opf("Comparing against previous build...")
+			sig, err := s.signature(client, consumer, chanInfo.Channel.Head.ID)
+			if err != nil {
+				return nil, errors.Wrap(err, "getting previous build signature")
 			}
 
 			err = pwr.AssertValid(buildPath, sig)
 			if err == nil {
-				comm.Statf("No changes and --if-changed used, not pushing anything")
-				pushResult(0, spec.Channel, false, true, "if-changed")
-				return nil
+				r.Statf("No changes and --if-changed used, not pushing anything")
+				return &pushOutcome{Channel: spec.Channel, Skipped: true, Reason: "if-changed"}, nil
 			}
 
 			if _, ok := err.(*pwr.ErrHasWound); ok {
 				// cool, that's what we expected
 			} else {
-				return errors.Wrap(err, "checking for differences")
+				return nil, errors.Wrap(err, "checking for differences")
 			}
 		} else {
-			comm.Opf("No previous build to compare against, pushing unconditionally")
+			r.Opf("No previous build to compare against, pushing unconditionally")
 		}
 	}
 
-	source := os.Getenv("BUTLER_PUSH_SOURCE")
-	if source == "" {
-		source = fmt.Sprintf("cli/%s", buildinfo.Version)
-	}
+	var parentID int64
+	if resuming {
+		buildID = journal.BuildID
+		parentID = journal.ParentID
+		r.Opf("Resuming build %d, interrupted %s ago", buildID, united.FormatDuration(time.Since(journal.UpdatedAt)))
+	} else {
+		source := os.Getenv("BUTLER_PUSH_SOURCE")
+		if source == "" {
+			source = fmt.Sprintf("cli/%s", buildinfo.Version)
+		}
 
-	requestCtx, cancel := ctx.DefaultCtx()
-	newBuildRes, err := client.CreateBuild(requestCtx, itchio.CreateBuildParams{
-		Target:      spec.Target,
-		Channel:     spec.Channel,
-		UserVersion: userVersion,
-		Hidden:      hidden,
-		Source:      source,
-	})
-	cancel()
-	if err != nil {
-		return errors.Wrap(err, "creating build on remote server")
-	}
+		requestCtx, cancel := ctx.DefaultCtx()
+		newBuildRes, err := client.CreateBuild(requestCtx, itchio.CreateBuildParams{
+			Target:      spec.Target,
+			Channel:     spec.Channel,
+			UserVersion: userVersion,
+			Hidden:      job.Hidden,
+			Source:      source,
+		})
+		cancel()
+		if err != nil {
+			return nil, errors.Wrap(err, "creating build on remote server")
+		}
 
-	buildID = newBuildRes.Build.ID
-	parentID := newBuildRes.Build.ParentBuild.ID
+		buildID = newBuildRes.Build.ID
+		parentID = newBuildRes.Build.ParentBuild.ID
+	}
 
 	// notify that build id has been obtained
 	comm.Object("buildCreated", comm.JsonMessage{
 		"buildId": buildID,
 		"channel": spec.Channel,
+		"resumed": resuming,
 	})
 
 	var targetSignature *pwr.SignatureInfo
 
 	if parentID == 0 {
-		comm.Opf("For channel `%s`: pushing first build", spec.Channel)
+		r.Opf("For channel `%s`: pushing first build", spec.Channel)
 		targetSignature = &pwr.SignatureInfo{
 			Container: &tlc.Container{},
 			Hashes:    make([]wsync.BlockHash, 0),
 		}
 	} else {
-		comm.Opf("For channel `%s`: last build is %d, downloading its signature", spec.Channel, parentID)
+		r.Opf("For channel `%s`: last build is %d, downloading its signature", spec.Channel, parentID)
 		var err error
-		targetSignature, err = getSignature(ctx, client, consumer, parentID)
+		targetSignature, err = s.signature(client, consumer, parentID)
 		if err != nil {
-			return errors.Wrap(err, "searching for parent build signature")
+			return nil, errors.Wrap(err, "searching for parent build signature")
 		}
 	}
 
-	bothFiles, err := createBothFiles(ctx, client, buildID)
-	if err != nil {
-		return errors.Wrap(err, "creating remote patch and signature files")
-	}
+	if !resuming {
+		bothFiles, err := createBothFiles(ctx, client, buildID)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating remote patch and signature files")
+		}
 
-	newPatchRes := bothFiles.patchRes
-	newSignatureRes := bothFiles.signatureRes
+		err = journal.update(func() {
+			journal.BuildID = buildID
+			journal.ParentID = parentID
+			journal.Patch = journalFile{
+				FileID:    bothFiles.patchRes.File.ID,
+				UploadURL: bothFiles.patchRes.File.UploadURL,
+			}
+			journal.Signature = journalFile{
+				FileID:    bothFiles.signatureRes.File.ID,
+				UploadURL: bothFiles.signatureRes.File.UploadURL,
+			}
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "writing push journal")
+		}
+	}
 
-	patchWriter := uploader.NewResumableUpload(newPatchRes.File.UploadURL)
-	patchWriter.SetConsumer(consumer)
+	patchWriter, err := newJournaledUpload(journal, &journal.Patch, consumer)
+	if err != nil {
+		if resuming && !isInterrupted(err) {
+			return restart(err)
+		}
+		return nil, errors.WithStack(err)
+	}
+	writers = append(writers, patchWriter)
 
-	signatureWriter := uploader.NewResumableUpload(newSignatureRes.File.UploadURL)
-	signatureWriter.SetConsumer(consumer)
+	signatureWriter, err := newJournaledUpload(journal, &journal.Signature, consumer)
+	if err != nil {
+		if resuming && !isInterrupted(err) {
+			return restart(err)
+		}
+		return nil, errors.WithStack(err)
+	}
+	writers = append(writers, signatureWriter)
 
 	comm.Debugf("Launching patch & signature channels")
 
 	patchCounter := counter.NewWriter(patchWriter)
 	signatureCounter := counter.NewWriter(signatureWriter)
 
-	comm.Opf("Pushing %s", sourceContainer)
+	r.Opf("Pushing %s", sourceContainer)
 
 	comm.Debugf("Building diff context")
 	var readBytes int64
@@ -291,6 +549,8 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 	var patchUploadedBytes int64
 
 	stopTicking := make(chan struct{})
+	stop := sync.OnceFunc(func() { close(stopTicking) })
+	defer stop()
 	updateProgress := func() {
 		// input bytes that aren't in output, for example:
 		//  - bytes that have been compressed away
@@ -305,9 +565,9 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 			if bytesPerSec > 1 {
 				netStatus = fmt.Sprintf("@ %s/s", united.FormatBytes(int64(bytesPerSec)))
 			}
-			comm.ProgressLabel(fmt.Sprintf("%s, %s left", netStatus, united.FormatBytes(leftBytes)))
+			r.ProgressLabel(fmt.Sprintf("%s, %s left", netStatus, united.FormatBytes(leftBytes)))
 		} else {
-			comm.ProgressLabel(fmt.Sprintf("- almost there"))
+			r.ProgressLabel(fmt.Sprintf("- almost there"))
 		}
 
 		conservativeProgress := float64(patchUploadedBytes) / float64(conservativeTotalBytes)
@@ -316,7 +576,7 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		if bytesPerSec > 1 {
 			eta = float64(leftBytes) / bytesPerSec
 		}
-		comm.ProgressWith(conservativeProgress, comm.JsonMessage{
+		r.ProgressWith(conservativeProgress, comm.JsonMessage{
 			"bps":           bytesPerSec,
 			"eta":           eta,
 			"readBytes":     readBytes,
@@ -325,7 +585,7 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 			"patchBytes":    patchCounter.Count(),
 		})
 
-		comm.ProgressScale(float64(readBytes) / float64(sourceContainer.Size))
+		r.ProgressScale(float64(readBytes) / float64(sourceContainer.Size))
 	}
 
 	patchWriter.SetProgressListener(func(count int64) {
@@ -369,16 +629,20 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		Consumer: stateConsumer,
 	}
 
-	comm.StartProgress()
-	comm.ProgressScale(0.0)
+	r.StartProgress()
+	r.ProgressScale(0.0)
 	err = dctx.WritePatch(context.Background(), patchCounter, signatureCounter)
 	if err != nil {
-		return errors.Wrap(err, "computing and writing patch")
+		if resuming && errors.Is(err, errJournalMismatch) {
+			stop()
+			return restart(err)
+		}
+		return nil, errors.Wrap(err, "computing and writing patch")
 	}
 
 	// close both files concurrently
 	{
-		errs := make(chan error)
+		errs := make(chan error, 2)
 
 		go func() {
 			errs <- patchWriter.Close()
@@ -387,47 +651,76 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 			errs <- signatureWriter.Close()
 		}()
 
-		// 2 close
+		// 2 close, wait for both so neither is still uploading when we
+		// give up or start over
+		var closeErr error
 		for i := 0; i < 2; i++ {
 			err := <-errs
-			if err != nil {
-				return errors.WithStack(err)
+			if err != nil && closeErr == nil {
+				closeErr = err
+			}
+		}
+		if closeErr != nil {
+			if resuming && errors.Is(closeErr, errJournalMismatch) {
+				stop()
+				return restart(closeErr)
 			}
+			return nil, errors.WithStack(closeErr)
 		}
 	}
 
-	close(stopTicking)
-	comm.ProgressLabel("finalizing build")
+	stop()
+	r.ProgressLabel("finalizing build")
 
 	// finalize both files concurrently
 	{
-		errs := make(chan error)
+		errs := make(chan error, 2)
+
+		doFinalize := func(file *journalFile, fileSize int64, done chan error) {
+			if file.Finalized {
+				// done before the interruption
+				done <- nil
+				return
+			}
 
-		doFinalize := func(fileID int64, fileSize int64, done chan error) {
 			requestCtx, cancel := ctx.DefaultCtx()
 			defer cancel()
 
 			_, finalizeErr := client.FinalizeBuildFile(requestCtx, itchio.FinalizeBuildFileParams{
 				BuildID: buildID,
-				FileID:  fileID,
+				FileID:  file.FileID,
 				Size:    fileSize,
 			})
+			if finalizeErr == nil {
+				finalizeErr = journal.update(func() {
+					file.Finalized = true
+				})
+			}
 			done <- finalizeErr
 		}
 
-		go doFinalize(newPatchRes.File.ID, patchCounter.Count(), errs)
-		go doFinalize(newSignatureRes.File.ID, signatureCounter.Count(), errs)
+		go doFinalize(&journal.Patch, patchCounter.Count(), errs)
+		go doFinalize(&journal.Signature, signatureCounter.Count(), errs)
 
 		// 2 doFinalize
 		for i := 0; i < 2; i++ {
 			err := <-errs
 			if err != nil {
-				return errors.WithStack(err)
+				return nil, errors.WithStack(err)
 			}
 		}
 	}
 
-	comm.EndProgress()
+	r.EndProgress()
+
+	err = journal.remove()
+	if err != nil {
+		r.Warnf("Could not remove push journal: %s", err.Error())
+	}
+
+	if resuming {
+		r.Statf("Resumed upload, %s didn't need to be sent again", united.FormatBytes(patchWriter.Skipped()+signatureWriter.Skipped()))
+	}
 
 	{
 		prettyPatchSize := united.FormatBytes(patchCounter.Count())
@@ -437,24 +730,127 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		savings := 100.0 - relToNew
 
 		if dctx.ReusedBytes > 0 {
-			comm.Statf("Re-used %.2f%% of old, added %s fresh data", percReused, prettyFreshSize)
+			r.Statf("Re-used %.2f%% of old, added %s fresh data", percReused, prettyFreshSize)
 		} else {
-			comm.Statf("Added %s fresh data", prettyFreshSize)
+			r.Statf("Added %s fresh data", prettyFreshSize)
 		}
 
 		if savings > 0 && !math.IsNaN(savings) {
-			comm.Statf("%s patch (%.2f%% savings)", prettyPatchSize, 100.0-relToNew)
+			r.Statf("%s patch (%.2f%% savings)", prettyPatchSize, 100.0-relToNew)
 		} else {
-			comm.Statf("%s patch (no savings)", prettyPatchSize)
+			r.Statf("%s patch (no savings)", prettyPatchSize)
 		}
 	}
-	comm.Opf("Build is now processing, should be up in a bit.")
-	comm.Logf("")
-	comm.Logf("Use the `butler status %s` for more information.", specStr)
-	comm.Logf("")
+	if !job.Wait {
+		r.Opf("Build is now processing, should be up in a bit.")
+		r.Logf("")
+		r.Logf("Use the `butler status %s` for more information.", specStr)
+		r.Logf("")
+	}
+
+	return &pushOutcome{BuildID: buildID, Channel: spec.Channel}, nil
+}
+
+// validateSource refuses to push containers that can't be patched
+func validateSource(r *reporter, buildPath string, sourceContainer *tlc.Container) error {
+	showSingleFileWarningIfNecessary(r, sourceContainer)
+
+	err := sourceContainer.Validate()
+	if err != nil {
+		r.Notice("Validation failed", []string{
+			fmt.Sprintf("(%s) cannot be pushed, because it is invalid.", buildPath),
+			"",
+			"If you're pushing a .zip file, try pushing a folder directly instead. Pushing a folder is not only faster, it eliminates a whole class of errors.",
+			"",
+			"The errors found during validation follow.",
+		})
+		r.Logf("%s", err)
+		return errors.Wrap(err, "refusing to push invalid container")
+	}
+	return nil
+}
+
+// lintSource refuses to push a build that players couldn't launch, when
+// the job asks for it. See validate.Lint for what gets checked.
+func lintSource(job Job, r *reporter, buildPath string, channel string, sourceContainer *tlc.Container) error {
+	if !job.Lint {
+		return nil
+	}
+
+	stats, err := os.Stat(buildPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !stats.IsDir() {
+		return lintArchive(job, r, buildPath)
+	}
+
+	r.Opf("Linting build for channel %s...", channel)
+	if len(validate.ChannelPlatforms(channel)) == 0 {
+		r.Logf("Channel %s isn't tagged with a platform, only checking the manifest", channel)
+	}
+	problems, err := validate.Lint(validate.LintParams{
+		Dir:       buildPath,
+		Container: sourceContainer,
+		Channel:   channel,
+		Consumer:  r.Consumer(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "linting build")
+	}
+	return reportLintProblems(job, r, buildPath, problems)
+}
 
-	pushResult(buildID, spec.Channel, false, false, "")
+// lintArchive runs the checks of `butler auditzip` on a .zip source.
+// Launch targets can only be looked for in folders.
+func lintArchive(job Job, r *reporter, buildPath string) error {
+	if !strings.EqualFold(filepath.Ext(buildPath), ".zip") {
+		r.Warnf("Not linting (%s), only folders and .zip files can be linted", buildPath)
+		return nil
+	}
 
+	r.Opf("Auditing archive %s...", buildPath)
+	r.Logf("Launch targets are only checked in folders, extract the archive to have them checked")
+	consumer := r.Consumer()
+	auditConsumer := &state.Consumer{
+		OnMessage: func(level string, msg string) {
+			consumer.Debugf("%s", msg)
+		},
+	}
+
+	var problems []validate.LintProblem
+	entryProblems, err := auditzip.Audit(auditConsumer, buildPath, false)
+	if err != nil {
+		problems = append(problems, validate.LintProblem{
+			Message: fmt.Sprintf("the archive can't be extracted: %s", err.Error()),
+		})
+	}
+	for _, p := range entryProblems {
+		problems = append(problems, validate.LintProblem{Message: p})
+	}
+	return reportLintProblems(job, r, buildPath, problems)
+}
+
+func reportLintProblems(job Job, r *reporter, buildPath string, problems []validate.LintProblem) error {
+	var errs []string
+	for _, p := range problems {
+		if p.Warning || job.LintWarnOnly {
+			r.Warnf("%s", p)
+			continue
+		}
+		errs = append(errs, p.String())
+	}
+	if len(errs) > 0 {
+		r.Notice("Lint failed", append([]string{
+			fmt.Sprintf("(%s) won't be pushed, because players couldn't launch it:", buildPath),
+			"",
+		}, errs...))
+		return errors.Errorf("lint found %d problems, refusing to push. Use --lint-level warn to push anyway", len(errs))
+	}
+
+	if len(problems) == 0 {
+		r.Statf("No problems found")
+	}
 	return nil
 }
 
@@ -487,16 +883,24 @@ func reportBuildFailure(ctx *mansion.Context, client *itchio.Client, buildID int
 }
 
 // pushResult emits the final structured `result` event for a `butler push`
-// run. The shape matches what the CLI has always emitted; butlerd's
+// run. The shape matches what the CLI has always emitted, plus the build's
+// final state with --wait and the bundle's path with --export; butlerd's
 // Publish.Push parser ignores fields it doesn't surface in PublishPushResult.
-func pushResult(buildID int64, channel string, dryRun bool, skipped bool, reason string) {
-	comm.Result(map[string]interface{}{
-		"buildId": buildID,
-		"channel": channel,
-		"dryRun":  dryRun,
-		"skipped": skipped,
-		"reason":  reason,
-	})
+func pushResult(outcome *pushOutcome) {
+	result := map[string]interface{}{
+		"buildId": outcome.BuildID,
+		"channel": outcome.Channel,
+		"dryRun":  outcome.DryRun,
+		"skipped": outcome.Skipped,
+		"reason":  outcome.Reason,
+	}
+	if outcome.State != "" {
+		result["state"] = string(outcome.State)
+	}
+	if outcome.Bundle != "" {
+		result["bundle"] = outcome.Bundle
+	}
+	comm.Result(result)
 }
 
 // getSignature downloads and parses the wharf signature for the given build.
@@ -557,14 +961,7 @@ func getSignature(ctx *mansion.Context, client *itchio.Client, consumer *state.C
 	return signature, nil
 }
 
-func min(a, b float64) float64 {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-func showSingleFileWarningIfNecessary(sourceContainer *tlc.Container) {
+func showSingleFileWarningIfNecessary(r *reporter, sourceContainer *tlc.Container) {
 	if !sourceContainer.IsSingleFile() {
 		return
 	}
@@ -584,7 +981,7 @@ func showSingleFileWarningIfNecessary(sourceContainer *tlc.Container) {
 		return
 	}
 
-	comm.Notice("You're pushing a single file", []string{
+	r.Notice("You're pushing a single file", []string{
 		"Diffing and patching work poorly on 'all-in-one executables' and installers. Consider pushing a portable build instead, for optimal distribution.",
 		"",
 		"For more information, see https://itch.io/docs/butler/single-files.html",
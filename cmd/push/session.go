@@ -0,0 +1,344 @@
+package push
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	itchio "github.com/itchio/go-itchio"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+
+	"github.com/itchio/headway/state"
+
+	"github.com/itchio/lake/pools"
+	"github.com/itchio/lake/tlc"
+
+	"github.com/itchio/wharf/pwr"
+	"github.com/pkg/errors"
+)
+
+// session lets several pushes share a single authenticated client,
+// source walks and parent build signatures.
+type session struct {
+	ctx *mansion.Context
+
+	authMutex sync.Mutex
+	client    *itchio.Client
+
+	mutex      sync.Mutex
+	walks      map[string]*sharedWalk
+	signatures map[int64]*sharedSignature
+}
+
+type sharedWalk struct {
+	done      chan struct{}
+	container *tlc.Container
+	err       error
+}
+
+type sharedSignature struct {
+	done      chan struct{}
+	signature *pwr.SignatureInfo
+	err       error
+}
+
+// what the session calls to walk sources and download signatures,
+// replaced in tests to count calls
+var (
+	walkSource     = doWalk
+	fetchSignature = getSignature
+)
+
+func newSession(ctx *mansion.Context) *session {
+	return &session{
+		ctx:        ctx,
+		walks:      make(map[string]*sharedWalk),
+		signatures: make(map[int64]*sharedSignature),
+	}
+}
+
+func (s *session) authenticate() (*itchio.Client, error) {
+	s.authMutex.Lock()
+	defer s.authMutex.Unlock()
+
+	if s.client == nil {
+		client, err := s.ctx.AuthenticateViaOauth()
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// walk walks a source once per session, pushes of the same source (with
+// the same options) to several channels share the resulting container.
+// Each caller gets its own pool, since pools can't be read concurrently.
+func (s *session) walk(buildPath string, fixPerms bool, ignore []string, walkOpts tlc.WalkOpts) (*walkResult, error) {
+	absPath, err := filepath.Abs(buildPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	key := fmt.Sprintf("%s|%s|%v|%v|%s", absPath, walkOpts.WrappedDir, walkOpts.Dereference, fixPerms, strings.Join(ignore, "|"))
+
+	s.mutex.Lock()
+	sw, ok := s.walks[key]
+	if !ok {
+		sw = &sharedWalk{done: make(chan struct{})}
+		s.walks[key] = sw
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		<-sw.done
+	} else {
+		out := make(chan walkResult)
+		errs := make(chan error)
+		go walkSource(buildPath, out, errs, fixPerms, walkOpts)
+		select {
+		case sw.err = <-errs:
+		case walkies := <-out:
+			sw.container = walkies.container
+			walkies.pool.Close()
+		}
+		close(sw.done)
+	}
+
+	if sw.err != nil {
+		return nil, sw.err
+	}
+
+	pool, err := pools.New(sw.container, buildPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &walkResult{
+		container: sw.container,
+		pool:      pool,
+	}, nil
+}
+
+// signature downloads the signature of a build once per session.
+func (s *session) signature(client *itchio.Client, consumer *state.Consumer, buildID int64) (*pwr.SignatureInfo, error) {
+	s.mutex.Lock()
+	ss, ok := s.signatures[buildID]
+	if !ok {
+		ss = &sharedSignature{done: make(chan struct{})}
+		s.signatures[buildID] = ss
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		<-ss.done
+	} else {
+		ss.signature, ss.err = fetchSignature(s.ctx, client, consumer, buildID)
+		close(ss.done)
+	}
+	return ss.signature, ss.err
+}
+
+// pushOutcome is what a single push did, as reported in the `result` event.
+type pushOutcome struct {
+	Target  string `json:"target"`
+	BuildID int64  `json:"buildId"`
+	Channel string `json:"channel"`
+	DryRun  bool   `json:"dryRun"`
+	Skipped bool   `json:"skipped"`
+	Reason  string `json:"reason"`
+	// Final state of the build, with --wait
+	State itchio.BuildState `json:"state,omitempty"`
+	// Where the push was exported to, with --export
+	Bundle string `json:"bundle,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pushAll pushes jobs through the session, at most maxConcurrent at a
+// time. A single job emits the usual `result` event, several jobs emit
+// one `result` event listing every build, even if some failed.
+func (s *session) pushAll(jobs []Job, maxConcurrent int) error {
+	if len(jobs) > 1 && jobs[0].Export != "" {
+		return errors.New("--export can only export a single push")
+	}
+
+	if len(jobs) == 1 {
+		outcome, err := s.run(jobs[0], &reporter{})
+		if outcome != nil {
+			pushResult(outcome)
+		}
+		return err
+	}
+
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if maxConcurrent > len(jobs) {
+		maxConcurrent = len(jobs)
+	}
+
+	// concurrent pushes can't share the progress bar, so it shows
+	// their overall progress instead
+	var mp *multiProgress
+	if maxConcurrent > 1 {
+		comm.Opf("Pushing %d channels, %d at a time", len(jobs), maxConcurrent)
+		mp = newMultiProgress(len(jobs))
+		comm.StartProgress()
+	}
+
+	outcomes := make([]*pushOutcome, len(jobs))
+	slots := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			r := &reporter{
+				prefix: fmt.Sprintf("[%s] ", job.label()),
+				multi:  mp,
+				index:  i,
+			}
+			r.Opf("Pushing %s to %s", job.Src, job.Target)
+			outcome, err := s.run(job, r)
+			if err != nil {
+				r.Warnf("Push failed: %s", err.Error())
+				if outcome == nil {
+					outcome = &pushOutcome{}
+				}
+				outcome.Error = err.Error()
+			}
+			outcome.Target = job.Target
+			outcomes[i] = outcome
+			r.EndProgress()
+		}(i, job)
+	}
+	wg.Wait()
+
+	if mp != nil {
+		comm.EndProgress()
+	}
+
+	var failed []string
+	for i, outcome := range outcomes {
+		if outcome.Error != "" {
+			failed = append(failed, jobs[i].label())
+		}
+	}
+
+	comm.Result(map[string]interface{}{
+		"builds": outcomes,
+	})
+
+	if len(failed) > 0 {
+		return errors.Errorf("%d of %d pushes failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (job Job) label() string {
+	if job.Name != "" {
+		return job.Name
+	}
+	return job.Target
+}
+
+// reporter routes the output of a push: straight through comm when
+// it's the only one, prefixed with its channel when there are several,
+// and without its own progress bar when they run concurrently.
+type reporter struct {
+	prefix string
+	multi  *multiProgress
+	index  int
+}
+
+func (r *reporter) Opf(format string, args ...interface{}) {
+	comm.Opf("%s%s", r.prefix, fmt.Sprintf(format, args...))
+}
+
+func (r *reporter) Statf(format string, args ...interface{}) {
+	comm.Statf("%s%s", r.prefix, fmt.Sprintf(format, args...))
+}
+
+func (r *reporter) Logf(format string, args ...interface{}) {
+	comm.Logf("%s%s", r.prefix, fmt.Sprintf(format, args...))
+}
+
+func (r *reporter) Warnf(format string, args ...interface{}) {
+	comm.Warnf("%s%s", r.prefix, fmt.Sprintf(format, args...))
+}
+
+func (r *reporter) Notice(header string, lines []string) {
+	comm.Notice(r.prefix+header, lines)
+}
+
+// Consumer returns a state consumer whose messages go through the reporter
+func (r *reporter) Consumer() *state.Consumer {
+	if r.prefix == "" && r.multi == nil {
+		return comm.NewStateConsumer()
+	}
+	return &state.Consumer{
+		OnMessage: func(level string, msg string) {
+			comm.Logl(level, r.prefix+msg)
+		},
+	}
+}
+
+func (r *reporter) StartProgress() {
+	if r.multi == nil {
+		comm.StartProgress()
+	}
+}
+
+func (r *reporter) ProgressLabel(label string) {
+	if r.multi == nil {
+		comm.ProgressLabel(label)
+	}
+}
+
+func (r *reporter) ProgressWith(alpha float64, extras comm.JsonMessage) {
+	if r.multi == nil {
+		comm.ProgressWith(alpha, extras)
+		return
+	}
+	r.multi.set(r.index, alpha)
+}
+
+func (r *reporter) ProgressScale(scale float64) {
+	if r.multi == nil {
+		comm.ProgressScale(scale)
+	}
+}
+
+func (r *reporter) EndProgress() {
+	if r.multi == nil {
+		comm.EndProgress()
+		return
+	}
+	r.multi.set(r.index, 1.0)
+}
+
+// multiProgress folds the progress of concurrent pushes into one value
+type multiProgress struct {
+	mutex  sync.Mutex
+	alphas []float64
+}
+
+func newMultiProgress(n int) *multiProgress {
+	return &multiProgress{alphas: make([]float64, n)}
+}
+
+func (mp *multiProgress) set(index int, alpha float64) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.alphas[index] = alpha
+	var total float64
+	for _, a := range mp.alphas {
+		total += a
+	}
+	comm.Progress(total / float64(len(mp.alphas)))
+}
@@ -0,0 +1,97 @@
+package push
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/lake/tlc"
+	"github.com/itchio/wharf/pwr"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionWalksSourceOnce(t *testing.T) {
+	var walks atomic.Int64
+	oldWalkSource := walkSource
+	defer func() { walkSource = oldWalkSource }()
+	walkSource = func(path string, out chan walkResult, errs chan error, fixPerms bool, walkOpts tlc.WalkOpts) {
+		walks.Add(1)
+		doWalk(path, out, errs, fixPerms, walkOpts)
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "game.exe"), []byte("MZ"), 0o755))
+
+	s := newSession(nil)
+
+	// like three jobs pushing the same folder to different channels
+	results := make([]*walkResult, 3)
+	errs := make([]error, len(results))
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = s.walk(dir, false, nil, tlc.WalkOpts{})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.EqualValues(t, 1, walks.Load())
+	for _, res := range results[1:] {
+		require.Same(t, results[0].container, res.container)
+		require.NotSame(t, results[0].pool, res.pool, "each push gets its own pool")
+	}
+	require.Len(t, results[0].container.Files, 1)
+
+	// different options walk again
+	_, err := s.walk(dir, false, []string{"*.pdb"}, tlc.WalkOpts{})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, walks.Load())
+}
+
+func Test_SessionFetchesSignatureOnce(t *testing.T) {
+	var mutex sync.Mutex
+	fetches := make(map[int64]int)
+	oldFetchSignature := fetchSignature
+	defer func() { fetchSignature = oldFetchSignature }()
+	fetchSignature = func(ctx *mansion.Context, client *itchio.Client, consumer *state.Consumer, buildID int64) (*pwr.SignatureInfo, error) {
+		mutex.Lock()
+		fetches[buildID]++
+		mutex.Unlock()
+		return &pwr.SignatureInfo{}, nil
+	}
+
+	s := newSession(nil)
+
+	buildIDs := []int64{12, 12, 12, 34}
+	signatures := make([]*pwr.SignatureInfo, len(buildIDs))
+	errs := make([]error, len(buildIDs))
+	var wg sync.WaitGroup
+	for i, buildID := range buildIDs {
+		wg.Add(1)
+		go func(i int, buildID int64) {
+			defer wg.Done()
+			signatures[i], errs[i] = s.signature(nil, nil, buildID)
+		}(i, buildID)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, map[int64]int{12: 1, 34: 1}, fetches)
+	require.Same(t, signatures[0], signatures[1])
+	require.Same(t, signatures[0], signatures[2])
+	require.NotSame(t, signatures[0], signatures[3])
+}
@@ -0,0 +1,396 @@
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// readUserVersion returns the user version of a job: the one given
+// directly, or read from its user version file, or derived from its
+// user version source.
+func readUserVersion(job Job) (string, error) {
+	var userVersion string
+	switch {
+	case job.UserVersion != "":
+		userVersion = job.UserVersion
+	case job.UserVersionFile != "":
+		buf, err := os.ReadFile(job.UserVersionFile)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		text, err := decodeText(buf)
+		if err != nil {
+			return "", errors.Wrapf(err, "reading userversion from %s", job.UserVersionFile)
+		}
+		userVersion = strings.TrimSpace(text)
+		if strings.ContainsAny(userVersion, "\r\n") {
+			return "", fmt.Errorf("%s contains line breaks, refusing to use as userversion", job.UserVersionFile)
+		}
+	case job.UserVersionFrom != "":
+		src, err := parseUserVersionSource(job.UserVersionFrom)
+		if err != nil {
+			return "", err
+		}
+		userVersion, err = src.read()
+		if err != nil {
+			return "", errors.Wrapf(err, "deriving userversion from %s", job.UserVersionFrom)
+		}
+	}
+
+	if job.SemVer && userVersion != "" {
+		if _, ok := parseSemVer(userVersion); !ok {
+			return "", errors.Errorf("userversion (%s) is not a semantic version (like 1.4.2 or 2.0.0-beta.1), see https://semver.org", userVersion)
+		}
+	}
+	return userVersion, nil
+}
+
+// decodeText decodes a text file that's either UTF-8, or UTF-16 with a
+// byte order mark, which is what Windows tools (like PowerShell's
+// Out-File) tend to write.
+func decodeText(buf []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xEF, 0xBB, 0xBF}):
+		buf = buf[3:]
+	case bytes.HasPrefix(buf, []byte{0xFF, 0xFE}):
+		return decodeUTF16(buf[2:], false)
+	case bytes.HasPrefix(buf, []byte{0xFE, 0xFF}):
+		return decodeUTF16(buf[2:], true)
+	}
+
+	if !utf8.Valid(buf) {
+		return "", errors.New("not valid UTF-8 (UTF-16 files need a byte order mark)")
+	}
+	return string(buf), nil
+}
+
+func decodeUTF16(buf []byte, bigEndian bool) (string, error) {
+	if len(buf)%2 != 0 {
+		return "", errors.New("truncated UTF-16 text")
+	}
+
+	units := make([]uint16, len(buf)/2)
+	for i := range units {
+		lo, hi := buf[2*i], buf[2*i+1]
+		if bigEndian {
+			lo, hi = hi, lo
+		}
+		units[i] = uint16(lo) | uint16(hi)<<8
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// A userVersionSource derives a user version from somewhere other than
+// the command line or a plain file. Sources are written as:
+//
+//	git                     `git describe` in the current directory
+//	git:path/to/repo        `git describe` in another directory
+//	json:package.json#version
+//	toml:Cargo.toml#package.version
+//	env:1.0.${BUILD_NUMBER}
+type userVersionSource struct {
+	kind string
+	// repository for git, file for json and toml
+	path string
+	// dotted key path for json and toml
+	key string
+	// template for env
+	template string
+}
+
+func parseUserVersionSource(s string) (*userVersionSource, error) {
+	kind, rest := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, rest = s[:i], s[i+1:]
+	}
+
+	src := &userVersionSource{kind: kind}
+	switch kind {
+	case "git":
+		src.path = rest
+	case "json", "toml":
+		i := strings.LastIndex(rest, "#")
+		if i < 0 {
+			return nil, errors.Errorf("userversion source (%s) needs a key, like %s:file#version", s, kind)
+		}
+		src.path, src.key = rest[:i], rest[i+1:]
+		if src.path == "" || src.key == "" {
+			return nil, errors.Errorf("userversion source (%s) needs a file and a key, like %s:file#version", s, kind)
+		}
+	case "env":
+		if rest == "" {
+			return nil, errors.Errorf("userversion source (%s) needs a template, like env:${VERSION}", s)
+		}
+		src.template = rest
+	default:
+		return nil, errors.Errorf("unknown userversion source (%s), expected git, json:, toml: or env:", s)
+	}
+	return src, nil
+}
+
+func (src *userVersionSource) String() string {
+	switch src.kind {
+	case "git":
+		if src.path == "" {
+			return "git"
+		}
+		return "git:" + src.path
+	case "env":
+		return "env:" + src.template
+	default:
+		return fmt.Sprintf("%s:%s#%s", src.kind, src.path, src.key)
+	}
+}
+
+func (src *userVersionSource) read() (string, error) {
+	var version string
+	var err error
+	switch src.kind {
+	case "git":
+		version, err = src.readGit()
+	case "json", "toml":
+		version, err = src.readKey()
+	case "env":
+		version, err = src.readEnv()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return "", errors.New("got an empty version")
+	}
+	if strings.ContainsAny(version, "\r\n") {
+		return "", errors.New("version contains line breaks")
+	}
+	return version, nil
+}
+
+func (src *userVersionSource) readGit() (string, error) {
+	cmd := exec.Command("git", "describe", "--tags", "--always", "--dirty")
+	cmd.Dir = src.path
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Errorf("git describe: %s", msg)
+		}
+		return "", errors.Wrap(err, "running git describe")
+	}
+	return string(out), nil
+}
+
+func (src *userVersionSource) readKey() (string, error) {
+	buf, err := os.ReadFile(src.path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var doc interface{}
+	if src.kind == "json" {
+		err = json.Unmarshal(buf, &doc)
+	} else {
+		_, err = toml.Decode(string(buf), &doc)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing %s", src.path)
+	}
+
+	value := doc
+	for _, part := range strings.Split(src.key, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			value, ok = v[part]
+			if !ok {
+				return "", errors.Errorf("%s has no key %s", src.path, src.key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", errors.Errorf("%s has no key %s", src.path, src.key)
+			}
+			value = v[i]
+		default:
+			return "", errors.Errorf("%s has no key %s", src.path, src.key)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	default:
+		return "", errors.Errorf("%s in %s is not a string or a number", src.key, src.path)
+	}
+}
+
+func (src *userVersionSource) readEnv() (string, error) {
+	var missing []string
+	version := os.Expand(src.template, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", errors.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return version, nil
+}
+
+var semVerRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// gitDescribeRegexp matches what `git describe --tags --dirty` appends
+// to a tag: the number of commits since the tag, the abbreviated commit
+// hash, and whether the working tree had changes.
+var gitDescribeRegexp = regexp.MustCompile(`^(.+?)(?:-(\d+)-g[0-9a-f]+)?(?:-dirty)?$`)
+
+// semVer is a parsed semantic version (https://semver.org). A leading
+// "v", as in git tags, is allowed. Build metadata is ignored.
+//
+// `git describe` output is understood too: "v1.2.0-3-gabc1234" is
+// v1.2.0 plus 3 commits, which comes after v1.2.0 and before
+// "v1.2.0-10-gdef5678". A "-dirty" suffix is ignored.
+type semVer struct {
+	numbers    [3]uint64
+	prerelease []string
+	commits    uint64
+}
+
+func parseSemVer(s string) (*semVer, bool) {
+	v := &semVer{}
+
+	if gm := gitDescribeRegexp.FindStringSubmatch(s); gm != nil {
+		s = gm[1]
+		if gm[2] != "" {
+			commits, err := strconv.ParseUint(gm[2], 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			v.commits = commits
+		}
+	}
+
+	m := semVerRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+
+	for i := range v.numbers {
+		n, err := strconv.ParseUint(m[i+1], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		v.numbers[i] = n
+	}
+	if m[4] != "" {
+		v.prerelease = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 if v has lower, equal or higher precedence
+// than other.
+func (v *semVer) compare(other *semVer) int {
+	c := v.compareRelease(other)
+	if c != 0 {
+		return c
+	}
+	// more commits on top of the same tag is newer
+	return compareUints(v.commits, other.commits)
+}
+
+func (v *semVer) compareRelease(other *semVer) int {
+	for i := range v.numbers {
+		if v.numbers[i] != other.numbers[i] {
+			return compareUints(v.numbers[i], other.numbers[i])
+		}
+	}
+
+	// a pre-release comes before the release itself
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		an, aErr := strconv.ParseUint(a, 10, 64)
+		bn, bErr := strconv.ParseUint(b, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return compareUints(an, bn)
+		case aErr == nil:
+			// numeric identifiers come before alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		case a < b:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return compareUints(uint64(len(v.prerelease)), uint64(len(other.prerelease)))
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// checkNotOlder refuses to push userVersion if it's older than the user
+// version of the channel's latest build. Versions that aren't semantic
+// versions can't be ordered, so they're let through.
+func checkNotOlder(userVersion string, headVersion string, headID int64) error {
+	if userVersion == "" || headVersion == "" {
+		return nil
+	}
+	v, ok := parseSemVer(userVersion)
+	if !ok {
+		return nil
+	}
+	head, ok := parseSemVer(headVersion)
+	if !ok {
+		return nil
+	}
+
+	if v.compare(head) < 0 {
+		return errors.Errorf("userversion %s is older than %s, the version of build %d, the channel's latest. Use --allow-older-version to push it anyway", userVersion, headVersion, headID)
+	}
+	return nil
+}
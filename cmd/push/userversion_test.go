@@ -0,0 +1,161 @@
+package push
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadUserVersionFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents []byte) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, contents, 0o644))
+		return p
+	}
+
+	utf16le := []byte{0xFF, 0xFE}
+	utf16be := []byte{0xFE, 0xFF}
+	for _, u := range utf16.Encode([]rune("1.2.3-ß\r\n")) {
+		utf16le = append(utf16le, byte(u), byte(u>>8))
+		utf16be = append(utf16be, byte(u>>8), byte(u))
+	}
+
+	for name, contents := range map[string][]byte{
+		"plain":    []byte("1.2.3-ß\n"),
+		"utf8-bom": append([]byte{0xEF, 0xBB, 0xBF}, "1.2.3-ß\n"...),
+		"utf16le":  utf16le,
+		"utf16be":  utf16be,
+	} {
+		v, err := readUserVersion(Job{UserVersionFile: write(name, contents)})
+		require.NoError(t, err, name)
+		require.Equal(t, "1.2.3-ß", v, name)
+	}
+
+	_, err := readUserVersion(Job{UserVersionFile: write("lines", []byte("1.2.3\n4.5.6\n"))})
+	require.Error(t, err)
+
+	_, err = readUserVersion(Job{UserVersionFile: write("latin1", []byte{'1', 0xE9})})
+	require.Error(t, err)
+}
+
+func Test_UserVersionSources(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "package.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"version": "2.0.1", "build": {"number": 42}, "tags": ["a", "b"]}`), 0o644))
+	tomlPath := filepath.Join(dir, "Cargo.toml")
+	require.NoError(t, os.WriteFile(tomlPath, []byte("[package]\nname = \"x\"\nversion = \"0.3.0\"\n"), 0o644))
+
+	read := func(from string) (string, error) {
+		return readUserVersion(Job{UserVersionFrom: from})
+	}
+
+	v, err := read("json:" + jsonPath + "#version")
+	require.NoError(t, err)
+	require.Equal(t, "2.0.1", v)
+
+	v, err = read("json:" + jsonPath + "#build.number")
+	require.NoError(t, err)
+	require.Equal(t, "42", v)
+
+	v, err = read("json:" + jsonPath + "#tags.1")
+	require.NoError(t, err)
+	require.Equal(t, "b", v)
+
+	_, err = read("json:" + jsonPath + "#build")
+	require.Error(t, err, "objects aren't versions")
+
+	_, err = read("json:" + jsonPath + "#nope")
+	require.Error(t, err)
+
+	v, err = read("toml:" + tomlPath + "#package.version")
+	require.NoError(t, err)
+	require.Equal(t, "0.3.0", v)
+
+	t.Setenv("TEST_BUILD_NUMBER", "17")
+	v, err = read("env:1.0.${TEST_BUILD_NUMBER}")
+	require.NoError(t, err)
+	require.Equal(t, "1.0.17", v)
+
+	_, err = read("env:1.0.${TEST_NOT_SET_ANYWHERE}")
+	require.Error(t, err)
+
+	for _, bad := range []string{"svn", "json:package.json", "toml:#version", "env:"} {
+		_, err = parseUserVersionSource(bad)
+		require.Error(t, err, bad)
+	}
+
+	_, err = readUserVersion(Job{UserVersion: "1.0", SemVer: true})
+	require.Error(t, err)
+
+	v, err = readUserVersion(Job{UserVersion: "v1.0.0-rc.1", SemVer: true})
+	require.NoError(t, err)
+	require.Equal(t, "v1.0.0-rc.1", v)
+}
+
+func Test_SemVerCompare(t *testing.T) {
+	// in increasing order, from semver.org
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0-rc.1-2-gabc1234",
+		"1.0.0",
+		"v1.0.0-3-gabc1234-dirty",
+		"v1.0.0-10-gdef5678",
+		"1.0.1",
+		"1.2.0",
+		"v1.10.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, ok := parseSemVer(ordered[i])
+			require.True(t, ok, ordered[i])
+			b, ok := parseSemVer(ordered[j])
+			require.True(t, ok, ordered[j])
+
+			expected := compareUints(uint64(i), uint64(j))
+			require.Equal(t, expected, a.compare(b), "%s vs %s", ordered[i], ordered[j])
+		}
+	}
+
+	a, _ := parseSemVer("1.0.0+build.1")
+	b, _ := parseSemVer("1.0.0+build.2")
+	require.Equal(t, 0, a.compare(b), "build metadata is ignored")
+
+	for _, bad := range []string{"1.0", "01.0.0", "1.0.0-", "1.0.0-01", "release", ""} {
+		_, ok := parseSemVer(bad)
+		require.False(t, ok, bad)
+	}
+}
+
+func Test_CheckNotOlder(t *testing.T) {
+	require.NoError(t, checkNotOlder("1.2.0", "1.1.9", 1))
+	require.NoError(t, checkNotOlder("1.2.0", "1.2.0", 1))
+	require.NoError(t, checkNotOlder("1.2.0", "", 1))
+	require.NoError(t, checkNotOlder("", "1.2.0", 1))
+	require.NoError(t, checkNotOlder("nightly", "1.2.0", 1), "unordered versions go through")
+	require.NoError(t, checkNotOlder("1.2.0", "nightly", 1), "unordered versions go through")
+
+	err := checkNotOlder("1.2.0-rc.1", "1.2.0", 12)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "build 12")
+
+	// git describe output
+	require.NoError(t, checkNotOlder("v1.2.0-3-gabc1234", "v1.2.0", 1))
+	require.NoError(t, checkNotOlder("v1.2.0-dirty", "v1.2.0", 1))
+	require.NoError(t, checkNotOlder("v1.2.0-10-gabc1234", "v1.2.0-9-gdef5678", 1))
+	require.NoError(t, checkNotOlder("v1.2.0-10-gabc1234-dirty", "v1.2.0-10-gabc1234", 1))
+	require.NoError(t, checkNotOlder("abc1234-dirty", "v1.2.0", 1), "untagged commits go through")
+	require.Error(t, checkNotOlder("v1.2.0-9-gdef5678", "v1.2.0-10-gabc1234", 1))
+	require.Error(t, checkNotOlder("v1.2.0", "v1.2.0-3-gabc1234", 1))
+	require.Error(t, checkNotOlder("v1.1.9-30-gabc1234", "v1.2.0", 1))
+}
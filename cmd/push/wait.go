@@ -0,0 +1,95 @@
+package push
+
+import (
+	"context"
+	"time"
+
+	"github.com/itchio/headway/united"
+	"github.com/pkg/errors"
+
+	itchio "github.com/itchio/go-itchio"
+)
+
+// buildPollInterval is how often --wait checks on a build
+var buildPollInterval = 5 * time.Second
+
+// buildPollMaxErrors is how many times in a row looking up a build can
+// fail before --wait gives up
+const buildPollMaxErrors = 5
+
+// getBuild looks up a build for --wait, replaced in tests
+var getBuild = func(ctx context.Context, client *itchio.Client, buildID int64) (*itchio.Build, error) {
+	res, err := client.GetBuild(ctx, itchio.GetBuildParams{
+		BuildID: buildID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Build, nil
+}
+
+// run pushes a job, then waits for its build to be processed if asked to.
+// Failing to wait still returns the outcome, so its state gets reported.
+func (s *session) run(job Job, r *reporter) (*pushOutcome, error) {
+	outcome, err := s.push(job, r)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Wait || outcome.BuildID == 0 || outcome.DryRun || outcome.Skipped {
+		return outcome, nil
+	}
+
+	client, err := s.authenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	outcome.State, err = s.waitForBuild(client, r, outcome.BuildID, job.WaitTimeout)
+	return outcome, err
+}
+
+// waitForBuild polls a build until it's processed, or fails, or timeout
+// elapses (if non-zero). It returns the last state it saw.
+func (s *session) waitForBuild(client *itchio.Client, r *reporter, buildID int64, timeout time.Duration) (itchio.BuildState, error) {
+	startTime := time.Now()
+	if timeout > 0 {
+		r.Opf("Waiting up to %s for build %d to be processed...", united.FormatDuration(timeout), buildID)
+	} else {
+		r.Opf("Waiting for build %d to be processed...", buildID)
+	}
+
+	var lastState itchio.BuildState
+	errCount := 0
+	for {
+		requestCtx, cancel := s.ctx.DefaultCtx()
+		build, err := getBuild(requestCtx, client, buildID)
+		cancel()
+
+		if err != nil {
+			errCount++
+			if errCount >= buildPollMaxErrors {
+				return lastState, errors.Wrapf(err, "looking up build %d", buildID)
+			}
+			r.Logf("Could not look up build %d, will try again: %s", buildID, err.Error())
+		} else {
+			errCount = 0
+			if build.State != lastState {
+				lastState = build.State
+				r.Logf("Build %d is %s", buildID, build.State)
+			}
+
+			switch build.State {
+			case itchio.BuildStateCompleted:
+				r.Statf("Build %d is live, processed in %s", buildID, united.FormatDuration(time.Since(startTime)))
+				return lastState, nil
+			case itchio.BuildStateFailed:
+				return lastState, errors.Errorf("build %d failed processing. Use `butler status` or the itch.io dashboard for details", buildID)
+			}
+		}
+
+		if timeout > 0 && time.Since(startTime)+buildPollInterval > timeout {
+			return lastState, errors.Errorf("timed out after %s waiting for build %d (still %s)", united.FormatDuration(timeout), buildID, lastState)
+		}
+		time.Sleep(buildPollInterval)
+	}
+}
@@ -0,0 +1,88 @@
+package push
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// stubGetBuild makes getBuild go through lookups, one per poll, and
+// repeat the last one forever. It returns a pointer to the number of
+// lookups made.
+func stubGetBuild(t *testing.T, lookups ...func() (*itchio.Build, error)) *int {
+	oldGetBuild, oldInterval := getBuild, buildPollInterval
+	t.Cleanup(func() {
+		getBuild, buildPollInterval = oldGetBuild, oldInterval
+	})
+
+	buildPollInterval = time.Millisecond
+	calls := 0
+	getBuild = func(ctx context.Context, client *itchio.Client, buildID int64) (*itchio.Build, error) {
+		lookup := lookups[min(calls, len(lookups)-1)]
+		calls++
+		return lookup()
+	}
+	return &calls
+}
+
+func buildIn(state itchio.BuildState) func() (*itchio.Build, error) {
+	return func() (*itchio.Build, error) {
+		return &itchio.Build{ID: 123, State: state}, nil
+	}
+}
+
+func lookupFails() (*itchio.Build, error) {
+	return nil, errors.New("server is on fire")
+}
+
+func Test_WaitForBuild(t *testing.T) {
+	s := newSession(&mansion.Context{})
+
+	t.Run("completed", func(t *testing.T) {
+		calls := stubGetBuild(t,
+			buildIn(itchio.BuildStateProcessing),
+			lookupFails,
+			buildIn(itchio.BuildStateCompleted),
+		)
+		state, err := s.waitForBuild(nil, &reporter{}, 123, 0)
+		require.NoError(t, err)
+		require.Equal(t, itchio.BuildStateCompleted, state)
+		require.Equal(t, 3, *calls)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		stubGetBuild(t,
+			buildIn(itchio.BuildStateProcessing),
+			buildIn(itchio.BuildStateFailed),
+		)
+		state, err := s.waitForBuild(nil, &reporter{}, 123, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed processing")
+		require.Equal(t, itchio.BuildStateFailed, state)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		stubGetBuild(t, buildIn(itchio.BuildStateProcessing))
+		state, err := s.waitForBuild(nil, &reporter{}, 123, 20*time.Millisecond)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "timed out")
+		require.Equal(t, itchio.BuildStateProcessing, state)
+	})
+
+	t.Run("errors in a row", func(t *testing.T) {
+		calls := stubGetBuild(t,
+			buildIn(itchio.BuildStateProcessing),
+			lookupFails,
+		)
+		state, err := s.waitForBuild(nil, &reporter{}, 123, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "server is on fire")
+		require.Equal(t, itchio.BuildStateProcessing, state, "the last state seen is kept")
+		require.Equal(t, 1+buildPollMaxErrors, *calls)
+	})
+}
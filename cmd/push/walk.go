@@ -1,6 +1,7 @@
 package push
 
 import (
+	"github.com/itchio/butler/filtering"
 	"github.com/itchio/lake"
 	"github.com/itchio/lake/pools"
 	"github.com/itchio/lake/tlc"
@@ -14,7 +15,7 @@ type walkResult struct {
 }
 
 func doWalk(path string, out chan walkResult, errs chan error, fixPerms bool, walkOpts tlc.WalkOpts) {
-	container, err := tlc.WalkAny(path, walkOpts)
+	container, err := filtering.WalkAny(path, walkOpts)
 	if err != nil {
 		errs <- errors.WithStack(err)
 		return
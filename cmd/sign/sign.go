@@ -44,7 +44,7 @@ func Do(output string, signature string, compression *pwr.CompressionSettings, f
 	comm.Opf("Creating signature for %s", output)
 	startTime := time.Now()
 
-	container, err := tlc.WalkAny(output, tlc.WalkOpts{Filter: filtering.FilterPaths})
+	container, err := filtering.WalkAny(output, tlc.WalkOpts{Filter: filtering.FilterPaths})
 	if err != nil {
 		return errors.Wrap(err, "walking directory to sign")
 	}
@@ -0,0 +1,455 @@
+package validate
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/itchio/butler/cmd/elfprops"
+	"github.com/itchio/butler/cmd/exeprops"
+	"github.com/itchio/butler/endpoints/launch"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/butler/manager"
+	"github.com/itchio/dash"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/httpkit/eos"
+	"github.com/itchio/httpkit/eos/option"
+	"github.com/itchio/hush/manifest"
+	"github.com/itchio/lake/tlc"
+	"github.com/itchio/ox"
+	"github.com/pkg/errors"
+)
+
+// A LintProblem is something wrong with a build, found by Lint.
+type LintProblem struct {
+	// Platform the problem was found for, if any
+	Platform ox.Platform `json:"platform,omitempty"`
+	Message  string      `json:"message"`
+	// Warnings are for builds that can be launched, just maybe not the
+	// way they were meant to be
+	Warning bool `json:"warning,omitempty"`
+}
+
+func (p LintProblem) String() string {
+	if p.Platform == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("%s: %s", p.Platform, p.Message)
+}
+
+type LintParams struct {
+	// Build folder to check
+	Dir string
+	// Files of the build folder, as walked for pushing. Launch targets
+	// that aren't in it (because they're ignored) don't count.
+	Container *tlc.Container
+	// Channel the build is pushed to, its name tells which platforms
+	// the build should launch on, and sometimes which architecture
+	Channel  string
+	Consumer *state.Consumer
+}
+
+// Lint runs the checks of `butler validate` on a build folder for every
+// platform its channel is tagged with: the manifest must parse, and each
+// platform must have a launch target. Native launch targets must also
+// have the same architecture as the libraries they ship with, and as the
+// channel, when its name gives one. Problems are returned, not printed.
+func Lint(params LintParams) ([]LintProblem, error) {
+	l := &linter{
+		params: params,
+		// launch heuristics are chatty, only show them when verbose
+		consumer: &state.Consumer{
+			OnMessage: func(level string, msg string) {
+				params.Consumer.Debugf("%s", msg)
+			},
+		},
+		probes: make(map[string]*probeResult),
+	}
+
+	var appManifest *manifest.Manifest
+	manifestPath := manifest.Path(params.Dir)
+	_, err := os.Stat(manifestPath)
+	if err == nil && !l.pushed(filepath.Base(manifestPath)) {
+		l.warnf("", "%s is ignored, the build will be pushed without it", filepath.Base(manifestPath))
+	} else if err == nil {
+		m, warning, err := parseManifest(l.consumer, manifestPath)
+		if err != nil {
+			l.errorf("", "invalid manifest: %s", err.Error())
+			return l.problems, nil
+		}
+		if warning != "" {
+			l.warnf("", "manifest: %s", warning)
+		}
+		for _, action := range m.Actions {
+			switch action.Platform {
+			case "", ox.PlatformLinux, ox.PlatformOSX, ox.PlatformWindows:
+			default:
+				l.errorf("", "manifest action '%s' has an unknown platform (%s)", action.Name, action.Platform)
+			}
+		}
+		appManifest = m
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "stat'ing manifest file")
+	}
+
+	var verdict *dash.Verdict
+	for _, platform := range ChannelPlatforms(params.Channel) {
+		host := manager.Host{
+			Runtime: ox.Runtime{Platform: platform, Is64: true},
+		}
+
+		if appManifest != nil && len(appManifest.Actions) > 0 {
+			l.lintActions(host, appManifest.Actions)
+			continue
+		}
+
+		if verdict == nil {
+			verdict, err = dash.Configure(params.Dir, dash.ConfigureParams{
+				Consumer: l.consumer,
+				Filter:   filtering.FilterPaths,
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "looking for launch targets in %s", params.Dir)
+			}
+		}
+		l.lintCandidates(host, verdict)
+	}
+
+	return l.problems, nil
+}
+
+// ChannelPlatforms returns the platforms itch.io tags a channel with,
+// based on its name.
+func ChannelPlatforms(channel string) []ox.Platform {
+	name := strings.ToLower(channel)
+
+	var platforms []ox.Platform
+	if strings.Contains(name, "win") {
+		platforms = append(platforms, ox.PlatformWindows)
+	}
+	if strings.Contains(name, "linux") {
+		platforms = append(platforms, ox.PlatformLinux)
+	}
+	if strings.Contains(name, "mac") || strings.Contains(name, "osx") {
+		platforms = append(platforms, ox.PlatformOSX)
+	}
+	return platforms
+}
+
+// channelArch returns the architecture a channel name mentions, like
+// "386" for "linux-32" or "amd64" for "windows-x64", if it mentions
+// exactly one. "win32" is how many people spell "windows", it doesn't
+// count.
+func channelArch(channel string) dash.Arch {
+	name := strings.ToLower(channel)
+	name = strings.NewReplacer("x86_64", "amd64", "x86-64", "amd64").Replace(name)
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	archs := make(map[dash.Arch]bool)
+	for _, word := range words {
+		switch word {
+		case "32", "x86", "i386", "i686", "386", "ia32":
+			archs[dash.Arch386] = true
+		case "64", "x64", "amd64", "win64", "linux64":
+			archs[dash.ArchAmd64] = true
+		}
+	}
+
+	if len(archs) != 1 {
+		return ""
+	}
+	for arch := range archs {
+		return arch
+	}
+	return ""
+}
+
+type probeResult struct {
+	arch    string
+	imports []string
+	err     error
+}
+
+type linter struct {
+	params   LintParams
+	consumer *state.Consumer
+	problems []LintProblem
+	// by slash-separated path relative to the build folder
+	probes map[string]*probeResult
+	// slash-separated paths of the container, see pushed
+	paths map[string]bool
+}
+
+// pushed returns true if a file or folder (by slash-separated path
+// relative to the build folder) is part of the container, i.e. wasn't
+// ignored when walking the build. Without a container, everything is.
+func (l *linter) pushed(relPath string) bool {
+	c := l.params.Container
+	if c == nil {
+		return true
+	}
+
+	if l.paths == nil {
+		l.paths = make(map[string]bool)
+		for _, f := range c.Files {
+			l.paths[f.Path] = true
+		}
+		for _, d := range c.Dirs {
+			l.paths[d.Path] = true
+		}
+		for _, s := range c.Symlinks {
+			l.paths[s.Path] = true
+		}
+	}
+	return l.paths[relPath]
+}
+
+func (l *linter) errorf(platform ox.Platform, msg string, args ...interface{}) {
+	l.problems = append(l.problems, LintProblem{
+		Platform: platform,
+		Message:  fmt.Sprintf(msg, args...),
+	})
+}
+
+func (l *linter) warnf(platform ox.Platform, msg string, args ...interface{}) {
+	l.problems = append(l.problems, LintProblem{
+		Platform: platform,
+		Message:  fmt.Sprintf(msg, args...),
+		Warning:  true,
+	})
+}
+
+func (l *linter) lintActions(host manager.Host, actions []manifest.Action) {
+	platform := host.Runtime.Platform
+
+	found := false
+	archs := make(map[string]string)
+	for _, action := range actions {
+		if action.Platform != "" && action.Platform != platform {
+			continue
+		}
+		found = true
+
+		target, err := launch.ActionToLaunchTarget(l.consumer, host, l.params.Dir, action)
+		if err != nil {
+			l.errorf(platform, "%s", err.Error())
+			continue
+		}
+
+		if target.Strategy.FullTargetPath == "" {
+			continue
+		}
+		rel, err := filepath.Rel(l.params.Dir, target.Strategy.FullTargetPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		exePath := filepath.ToSlash(rel)
+		if !l.pushed(exePath) {
+			l.errorf(platform, "manifest action '%s' launches %s, which is ignored and won't be pushed", action.Name, exePath)
+			continue
+		}
+
+		c := target.Strategy.Candidate
+		if c == nil {
+			continue
+		}
+		if arch := l.lintExecutable(platform, exePath, c.Flavor); arch != "" {
+			archs[exePath] = arch
+		}
+	}
+
+	if !found {
+		l.errorf(platform, "the manifest has no action for %s, players won't be able to launch the build", platform)
+		return
+	}
+	l.lintChannelArch(platform, archs)
+}
+
+func (l *linter) lintCandidates(host manager.Host, verdict *dash.Verdict) {
+	platform := host.Runtime.Platform
+
+	v := verdict.Filter(l.consumer, dash.FilterParams{
+		OS:   host.Runtime.OS(),
+		Arch: host.Runtime.Arch(),
+	})
+
+	// the folder is walked again to find candidates, leave out those
+	// that won't be pushed
+	var candidates []*dash.Candidate
+	var ignored []string
+	for _, c := range v.Candidates {
+		if l.pushed(c.Path) {
+			candidates = append(candidates, c)
+		} else {
+			ignored = append(ignored, c.Path)
+		}
+	}
+	if len(candidates) == 0 {
+		if len(ignored) > 0 {
+			l.errorf(platform, "found nothing to launch on %s: %s is ignored and won't be pushed", platform, strings.Join(ignored, ", "))
+			return
+		}
+		l.errorf(platform, "found nothing to launch on %s, add an executable or a manifest (see `butler validate`)", platform)
+		return
+	}
+
+	archs := make(map[string]string)
+	for _, c := range candidates {
+		if arch := l.lintExecutable(platform, c.Path, c.Flavor); arch != "" {
+			archs[c.Path] = arch
+		}
+	}
+	l.lintChannelArch(platform, archs)
+}
+
+// lintChannelArch checks that, if the channel's name gives an
+// architecture, at least one of the native launch targets (by path, to
+// their architecture) has it.
+func (l *linter) lintChannelArch(platform ox.Platform, archs map[string]string) {
+	want := channelArch(l.params.Channel)
+	if want == "" || len(archs) == 0 {
+		return
+	}
+
+	var exePaths []string
+	for exePath, arch := range archs {
+		if arch == string(want) {
+			return
+		}
+		exePaths = append(exePaths, exePath)
+	}
+	sort.Strings(exePaths)
+	exePath := exePaths[0]
+
+	if want == dash.Arch386 {
+		l.errorf(platform, "channel %s is for 32-bit systems, but %s is %s, it won't start there", l.params.Channel, exePath, archName(archs[exePath]))
+	} else {
+		l.warnf(platform, "channel %s is for 64-bit systems, but %s is %s", l.params.Channel, exePath, archName(archs[exePath]))
+	}
+}
+
+// lintExecutable checks that a native executable has the same
+// architecture as the libraries it loads from the build, and returns
+// that architecture, or an empty string if it's not a native executable
+// (or we can't tell).
+func (l *linter) lintExecutable(platform ox.Platform, exePath string, flavor dash.Flavor) string {
+	if flavor != dash.FlavorNativeWindows && flavor != dash.FlavorNativeLinux {
+		return ""
+	}
+
+	exe := l.probe(exePath, flavor)
+	if exe.err != nil {
+		l.warnf(platform, "could not inspect %s: %s", exePath, exe.err.Error())
+		return ""
+	}
+	if exe.arch == "" {
+		return ""
+	}
+
+	for _, imp := range exe.imports {
+		libs := l.findLibraries(exePath, imp, flavor)
+		if len(libs) == 0 {
+			// a system library, we can't check it
+			continue
+		}
+
+		var mismatch *probeResult
+		var mismatchPath string
+		matched := false
+		for _, libPath := range libs {
+			lib := l.probe(libPath, flavor)
+			if lib.err != nil {
+				continue
+			}
+			if lib.arch == exe.arch {
+				matched = true
+				break
+			}
+			mismatch, mismatchPath = lib, libPath
+		}
+
+		if !matched && mismatch != nil {
+			l.errorf(platform, "%s is %s, but loads %s, which is %s: it won't start", exePath, archName(exe.arch), mismatchPath, archName(mismatch.arch))
+		}
+	}
+	return exe.arch
+}
+
+// findLibraries returns the files of the build a native executable would
+// load when it imports a library: on Windows, DLLs next to it, on Linux,
+// shared objects anywhere in the build, since they're usually found
+// through an rpath or LD_LIBRARY_PATH.
+func (l *linter) findLibraries(exePath string, name string, flavor dash.Flavor) []string {
+	if l.params.Container == nil {
+		return nil
+	}
+
+	exeDir := path.Dir(exePath)
+	var libs []string
+	for _, f := range l.params.Container.Files {
+		switch flavor {
+		case dash.FlavorNativeWindows:
+			if path.Dir(f.Path) == exeDir && strings.EqualFold(path.Base(f.Path), name) {
+				libs = append(libs, f.Path)
+			}
+		case dash.FlavorNativeLinux:
+			if path.Base(f.Path) == name {
+				libs = append(libs, f.Path)
+			}
+		}
+	}
+	return libs
+}
+
+func (l *linter) probe(relPath string, flavor dash.Flavor) *probeResult {
+	if res, ok := l.probes[relPath]; ok {
+		return res
+	}
+
+	res := &probeResult{}
+	l.probes[relPath] = res
+
+	f, err := eos.Open(filepath.Join(l.params.Dir, filepath.FromSlash(relPath)), option.WithConsumer(l.consumer))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer f.Close()
+
+	switch flavor {
+	case dash.FlavorNativeWindows:
+		info, err := exeprops.Do(f, l.consumer)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.arch = string(info.Arch)
+		res.imports = info.Imports
+	case dash.FlavorNativeLinux:
+		info, err := elfprops.Do(f, l.consumer)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.arch = string(info.Arch)
+		res.imports = info.Imports
+	}
+	return res
+}
+
+func archName(arch string) string {
+	switch arch {
+	case string(dash.Arch386):
+		return "32-bit"
+	case string(dash.ArchAmd64):
+		return "64-bit"
+	default:
+		return arch
+	}
+}
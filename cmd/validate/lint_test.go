@@ -0,0 +1,161 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/dash"
+	"github.com/itchio/headway/state"
+	"github.com/itchio/lake/tlc"
+	"github.com/itchio/ox"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ChannelPlatforms(t *testing.T) {
+	require.Equal(t, []ox.Platform{ox.PlatformWindows}, ChannelPlatforms("windows-beta"))
+	require.Equal(t, []ox.Platform{ox.PlatformWindows}, ChannelPlatforms("Win32-final"))
+	require.Equal(t, []ox.Platform{ox.PlatformWindows, ox.PlatformLinux, ox.PlatformOSX}, ChannelPlatforms("win-linux-mac-stable"))
+	require.Equal(t, []ox.Platform{ox.PlatformOSX}, ChannelPlatforms("osx-universal"))
+	require.Empty(t, ChannelPlatforms("html5"))
+	require.Empty(t, ChannelPlatforms("android"))
+}
+
+func Test_ChannelArch(t *testing.T) {
+	for channel, arch := range map[string]dash.Arch{
+		"linux-32":         dash.Arch386,
+		"windows-x86":      dash.Arch386,
+		"linux_i686":       dash.Arch386,
+		"win64":            dash.ArchAmd64,
+		"windows-x64":      dash.ArchAmd64,
+		"linux-x86_64":     dash.ArchAmd64,
+		"linux-amd64-beta": dash.ArchAmd64,
+		"win32-final":      "",
+		"windows":          "",
+		"linux-32-64":      "",
+	} {
+		require.Equal(t, arch, channelArch(channel), channel)
+	}
+}
+
+// lintFixture writes files (by slash-separated path, to their contents)
+// to a temporary build folder and lints it for channel, as pushed with
+// the ignored names left out.
+func lintFixture(t *testing.T, channel string, files map[string]string, ignored ...string) []LintProblem {
+	dir := t.TempDir()
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(contents), 0o644))
+	}
+
+	container, err := tlc.WalkDir(dir, tlc.WalkOpts{
+		Filter: func(name string) tlc.FilterResult {
+			for _, ig := range ignored {
+				if name == ig {
+					return tlc.FilterIgnore
+				}
+			}
+			return tlc.FilterKeep
+		},
+	})
+	require.NoError(t, err)
+
+	problems, err := Lint(LintParams{
+		Dir:       dir,
+		Container: container,
+		Channel:   channel,
+		Consumer:  &state.Consumer{},
+	})
+	require.NoError(t, err)
+	return problems
+}
+
+func Test_Lint(t *testing.T) {
+	t.Run("invalid manifest", func(t *testing.T) {
+		problems := lintFixture(t, "windows", map[string]string{
+			".itch.toml": "[[actions]\nname = ",
+			"index.html": "<html></html>",
+		})
+		require.Len(t, problems, 1)
+		require.Contains(t, problems[0].Message, "invalid manifest")
+		require.False(t, problems[0].Warning)
+	})
+
+	t.Run("unknown manifest platform", func(t *testing.T) {
+		problems := lintFixture(t, "html5", map[string]string{
+			".itch.toml": "[[actions]]\nname = \"play\"\npath = \"index.html\"\nplatform = \"android\"\n",
+			"index.html": "<html></html>",
+		})
+		require.Len(t, problems, 1)
+		require.Contains(t, problems[0].Message, "unknown platform (android)")
+	})
+
+	t.Run("manifest without action for a platform", func(t *testing.T) {
+		problems := lintFixture(t, "windows-linux", map[string]string{
+			".itch.toml": "[[actions]]\nname = \"play\"\npath = \"index.html\"\nplatform = \"windows\"\n",
+			"index.html": "<html></html>",
+		})
+		var linuxProblems []LintProblem
+		for _, p := range problems {
+			if p.Platform == ox.PlatformLinux {
+				linuxProblems = append(linuxProblems, p)
+			}
+		}
+		require.Len(t, linuxProblems, 1)
+		require.Contains(t, linuxProblems[0].Message, "the manifest has no action for linux")
+	})
+
+	t.Run("nothing to launch", func(t *testing.T) {
+		problems := lintFixture(t, "windows-beta", map[string]string{
+			"readme.txt": "have fun!",
+		})
+		require.Len(t, problems, 1)
+		require.Equal(t, ox.PlatformWindows, problems[0].Platform)
+		require.Contains(t, problems[0].Message, "found nothing to launch on windows")
+	})
+
+	t.Run("html build", func(t *testing.T) {
+		problems := lintFixture(t, "windows", map[string]string{
+			"index.html":     "<html></html>",
+			"assets/app.js":  "console.log('hi')",
+			"assets/app.css": "body {}",
+		})
+		require.Empty(t, problems)
+	})
+
+	t.Run("ignored launch target", func(t *testing.T) {
+		problems := lintFixture(t, "windows", map[string]string{
+			"index.html": "<html></html>",
+		}, "index.html")
+		require.Len(t, problems, 1)
+		require.Contains(t, problems[0].Message, "index.html is ignored and won't be pushed")
+		require.False(t, problems[0].Warning)
+	})
+
+	t.Run("manifest action for an ignored file", func(t *testing.T) {
+		problems := lintFixture(t, "windows", map[string]string{
+			".itch.toml":     "[[actions]]\nname = \"play\"\npath = \"web/index.html\"\n",
+			"web/index.html": "<html></html>",
+		}, "web")
+		require.Len(t, problems, 1)
+		require.Contains(t, problems[0].Message, "manifest action 'play' launches web/index.html, which is ignored")
+	})
+
+	t.Run("ignored manifest", func(t *testing.T) {
+		problems := lintFixture(t, "windows", map[string]string{
+			".itch.toml": "[[actions]]\nname = \"play\"\npath = \"missing.html\"\n",
+			"index.html": "<html></html>",
+		}, ".itch.toml")
+		require.Len(t, problems, 1)
+		require.True(t, problems[0].Warning)
+		require.Contains(t, problems[0].Message, ".itch.toml is ignored")
+	})
+
+	t.Run("channel without platforms", func(t *testing.T) {
+		problems := lintFixture(t, "html5", map[string]string{
+			"readme.txt": "have fun!",
+		})
+		require.Empty(t, problems)
+	})
+}
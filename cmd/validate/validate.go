@@ -153,64 +153,15 @@ func Validate(consumer *state.Consumer) error {
 
 	consumer.Opf("Validating %s manifest at (%s)", united.FormatBytes(stats.Size()), manifestPath)
 
-	var intermediate map[string]interface{}
-	_, err = toml.DecodeFile(manifestPath, &intermediate)
-	if err != nil {
-		consumer.Errorf("Parse error:")
-		return errors.Wrap(err, "parsing manifest")
-	}
-
-	jsonIntermediate, err := json.MarshalIndent(intermediate, "", "  ")
-	if err != nil {
-		return errors.Wrap(err, "marshalling manifest as json")
-	}
-	consumer.Debugf("Intermediate:\n%s", string(jsonIntermediate))
-
-	appManifest := &manifest.Manifest{}
-	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		Result:      appManifest,
-		ErrorUnused: true,
-	})
-	if err != nil {
-		consumer.Errorf("Internal error:")
-		return errors.Wrap(err, "decoding manifest from json form")
-	}
-
-	err = decoder.Decode(intermediate)
-	if err != nil {
-		warnOnly := false
-		if mse, ok := err.(*mapstructure.Error); ok {
-			warnOnly = true
-			for _, e := range mse.Errors {
-				if strings.Contains(e, "has invalid keys") {
-					// cool!
-				} else {
-					warnOnly = false
-					break
-				}
-			}
-		}
-
-		if warnOnly {
-			showWarning("%s", err.Error())
-		} else {
-			consumer.Errorf("Decoding error:")
-			return errors.Wrap(err, "decoding manifest")
-		}
-	}
-
-	_, err = toml.DecodeFile(manifestPath, appManifest)
+	appManifest, warning, err := parseManifest(consumer, manifestPath)
 	if err != nil {
-		return errors.Wrap(err, "parsing toml manifest")
+		consumer.Errorf("Invalid manifest:")
+		return err
 	}
-
-	jsonManifest, err := json.MarshalIndent(appManifest, "", "  ")
-	if err != nil {
-		return errors.Wrap(err, "marshalling manifest as json")
+	if warning != "" {
+		showWarning("%s", warning)
 	}
 
-	consumer.Debugf("Manifest:\n%s", string(jsonManifest))
-
 	consumer.Infof("")
 	if len(appManifest.Actions) > 0 {
 		consumer.Statf("Validating %d actions...", len(appManifest.Actions))
@@ -309,3 +260,63 @@ func Validate(consumer *state.Consumer) error {
 
 	return nil
 }
+
+// parseManifest reads an app manifest. Unknown keys aren't fatal, they're
+// returned as a warning.
+func parseManifest(consumer *state.Consumer, manifestPath string) (*manifest.Manifest, string, error) {
+	var intermediate map[string]interface{}
+	_, err := toml.DecodeFile(manifestPath, &intermediate)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "parsing manifest")
+	}
+
+	jsonIntermediate, err := json.MarshalIndent(intermediate, "", "  ")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "marshalling manifest as json")
+	}
+	consumer.Debugf("Intermediate:\n%s", string(jsonIntermediate))
+
+	appManifest := &manifest.Manifest{}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:      appManifest,
+		ErrorUnused: true,
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "decoding manifest from json form")
+	}
+
+	var warning string
+	err = decoder.Decode(intermediate)
+	if err != nil {
+		warnOnly := false
+		if mse, ok := err.(*mapstructure.Error); ok {
+			warnOnly = true
+			for _, e := range mse.Errors {
+				if strings.Contains(e, "has invalid keys") {
+					// cool!
+				} else {
+					warnOnly = false
+					break
+				}
+			}
+		}
+
+		if !warnOnly {
+			return nil, "", errors.Wrap(err, "decoding manifest")
+		}
+		warning = err.Error()
+	}
+
+	_, err = toml.DecodeFile(manifestPath, appManifest)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "parsing toml manifest")
+	}
+
+	jsonManifest, err := json.MarshalIndent(appManifest, "", "  ")
+	if err != nil {
+		return nil, "", errors.Wrap(err, "marshalling manifest as json")
+	}
+	consumer.Debugf("Manifest:\n%s", string(jsonManifest))
+
+	return appManifest, warning, nil
+}
@@ -1,9 +1,13 @@
 package walk
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/itchio/butler/cmd/push"
 	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
 	"github.com/itchio/headway/united"
 	"github.com/itchio/lake/tlc"
@@ -13,16 +17,32 @@ import (
 var args = struct {
 	dir         *string
 	dereference *bool
+	explain     *bool
+	channel     *string
+	config      *string
 }{}
 
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("walk", "Finds all files in a directory").Hidden()
 	args.dir = cmd.Arg("dir", "A dir you want to walk").Required().String()
 	args.dereference = cmd.Flag("dereference", "Follow symlinks").Default("false").Bool()
+	args.explain = cmd.Flag("explain", "Show which entries push would leave out, and which rule excludes them").Bool()
+	args.channel = cmd.Flag("channel", "With --explain, also apply the ignore patterns butler.toml gives this channel").String()
+	args.config = cmd.Flag("config", "Project config to read --channel from (default: butler.toml in the current directory or its parents)").String()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
+	if *args.explain {
+		var ignore []string
+		if *args.channel != "" {
+			var err error
+			ignore, err = channelIgnore(*args.config, *args.channel)
+			ctx.Must(err)
+		}
+		ctx.Must(DoExplain(*args.dir, *args.dereference, ignore))
+		return
+	}
 	ctx.Must(Do(*args.dir, *args.dereference))
 }
 
@@ -67,3 +87,107 @@ func Do(dir string, dereference bool) error {
 
 	return nil
 }
+
+// channelIgnore returns the ignore patterns a project config gives a
+// channel, on top of --ignore.
+func channelIgnore(configPath string, channel string) ([]string, error) {
+	if configPath == "" {
+		var err error
+		configPath, err = push.FindConfig(".")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := push.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := config.Jobs([]string{channel}, push.Job{})
+	if err != nil {
+		return nil, err
+	}
+	return jobs[0].Ignore, nil
+}
+
+// DoExplain walks dir like push would, listing every entry along with
+// the rule that leaves it out, if any: built-in filters, --ignore
+// patterns, ignore patterns from butler.toml (passed as ignore), or
+// .butlerignore files.
+func DoExplain(dir string, dereference bool, ignore []string) error {
+	startTime := time.Now()
+
+	container, err := tlc.WalkDir(dir, tlc.WalkOpts{
+		Dereference: dereference,
+	})
+	if err != nil {
+		return errors.Wrap(err, "walking")
+	}
+
+	rules, err := filtering.LoadIgnoreRules(dir)
+	if err != nil {
+		return err
+	}
+
+	explain := func(entryPath string, isDir bool) string {
+		// ignoring a folder ignores everything in it
+		parts := strings.Split(entryPath, "/")
+		for _, part := range parts {
+			if reason := filtering.ExplainName(part); reason != "" {
+				return reason
+			}
+			if pattern := filtering.MatchName(part, ignore); pattern != "" {
+				return fmt.Sprintf("%s ignore %s", push.ConfigFileName, pattern)
+			}
+		}
+		if rule := rules.Explain(entryPath, isDir); rule != nil {
+			return rule.String()
+		}
+		return ""
+	}
+
+	var keptSize int64
+	send := func(entryPath string, isDir bool, size int64) {
+		reason := explain(entryPath, isDir)
+		if reason == "" {
+			keptSize += size
+		}
+		comm.ResultOrPrint(&mansion.WalkResult{
+			Type:    "entry",
+			Path:    entryPath,
+			Ignored: reason != "",
+			Rule:    reason,
+		}, func() {
+			if reason == "" {
+				comm.Logf("- %s", entryPath)
+			} else {
+				comm.Logf("x %s (%s)", entryPath, reason)
+			}
+		})
+	}
+
+	for _, d := range container.Dirs {
+		send(d.Path, true, 0)
+	}
+
+	for _, f := range container.Files {
+		send(f.Path, false, f.Size)
+	}
+
+	for _, s := range container.Symlinks {
+		send(s.Path, false, 0)
+	}
+
+	comm.ResultOrPrint(&mansion.WalkResult{
+		Type: "totalSize",
+		Size: keptSize,
+	}, func() {
+		comm.Statf("%s kept out of %s, walked in %s",
+			united.FormatBytes(keptSize),
+			container,
+			united.FormatDuration(time.Since(startTime)),
+		)
+	})
+
+	return nil
+}
@@ -58,6 +58,8 @@ func registerCommands(ctx *mansion.Context) {
 
 	push.Register(ctx)
 	push.RegisterPreview(ctx)
+	push.RegisterBundle(ctx)
+	push.RegisterBuilds(ctx)
 	fetch.Register(ctx)
 	status.Register(ctx)
 
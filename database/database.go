@@ -27,5 +27,11 @@ func Prepare(consumer *state.Consumer, conn *sqlite.Conn, justCreated bool) (ret
 		}
 	}
 
+	// the search index's triggers refer to tables migrations may touch
+	err = models.PrepareLibrarySearch(conn)
+	if err != nil {
+		return errors.WithMessage(err, "preparing library search index")
+	}
+
 	return nil
 }
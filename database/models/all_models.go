@@ -30,15 +30,26 @@ var AllModels = []interface{}{
 	&FetchInfo{},
 	&GameUpload{},
 	&CaveHistoricalPlayTime{},
+	&DownloadSchedule{},
+	&Lease{},
+	&PlaySession{},
+	&DownloadHistory{},
 }
 
 // declareIndexes registers secondary indexes for the bundle ownership
 // lookups (Fetch.GameOwnership, AccessForGameID, owned-bundles listing).
 // bundle_games' composite primary key already covers the (bundle_id,
-// game_id) direction.
+// game_id) direction. Play sessions and download history are indexed
+// by start time for statistics.
 func declareIndexes(c *hades.Context) error {
 	if err := c.DeclareIndex(&itchio.BundleKey{}, "owner_id", "bundle_id"); err != nil {
 		return err
 	}
-	return c.DeclareIndex(&itchio.BundleGame{}, "game_id", "bundle_id")
+	if err := c.DeclareIndex(&itchio.BundleGame{}, "game_id", "bundle_id"); err != nil {
+		return err
+	}
+	if err := c.DeclareIndex(&PlaySession{}, "started_at"); err != nil {
+		return err
+	}
+	return c.DeclareIndex(&DownloadHistory{}, "started_at")
 }
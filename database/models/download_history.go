@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"crawshaw.io/sqlite"
+	itchio "github.com/itchio/go-itchio"
+	"xorm.io/builder"
+)
+
+// DownloadHistory keeps statistics about a download after its
+// Download row is gone (cleared, discarded or aborted).
+type DownloadHistory struct {
+	// Same as the download's ID
+	ID string `json:"id" hades:"primary_key"`
+
+	Reason string `json:"reason"`
+	CaveID string `json:"caveId"`
+
+	GameID int64        `json:"gameId"`
+	Game   *itchio.Game `json:"game"`
+
+	UploadID int64 `json:"uploadId"`
+	BuildID  int64 `json:"buildId"`
+
+	// When the first attempt started
+	StartedAt *time.Time `json:"startedAt"`
+	// When the last attempt ended for good
+	FinishedAt *time.Time `json:"finishedAt"`
+	// Empty while the download can still be attempted again
+	Outcome string `json:"outcome"`
+
+	// Bytes transferred, across attempts
+	Bytes int64 `json:"bytes"`
+	// Time spent on attempts, in seconds
+	SecondsElapsed float64 `json:"secondsElapsed"`
+	// Fastest transfer speed seen, in bytes per second
+	PeakBPS float64 `json:"peakBps"`
+	// How many times the download was attempted, not counting
+	// attempts cancelled by pausing downloads
+	Attempts int64 `json:"attempts"`
+
+	// Code and message of the last error, if any
+	ErrorCode    *int64  `json:"errorCode"`
+	ErrorMessage *string `json:"errorMessage"`
+}
+
+// DownloadHistoryFor returns the history of a download,
+// or a blank one if it was never attempted.
+func DownloadHistoryFor(conn *sqlite.Conn, download *Download) *DownloadHistory {
+	var dh DownloadHistory
+	if MustSelectOne(conn, &dh, builder.Eq{"id": download.ID}) {
+		return &dh
+	}
+	return &DownloadHistory{
+		ID:       download.ID,
+		Reason:   download.Reason,
+		CaveID:   download.CaveID,
+		GameID:   download.GameID,
+		UploadID: download.UploadID,
+		BuildID:  download.BuildID,
+	}
+}
+
+func (dh *DownloadHistory) Save(conn *sqlite.Conn) {
+	MustSave(conn, dh)
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"crawshaw.io/sqlite"
+	"xorm.io/builder"
+)
+
+// DownloadSchedule stores the schedule consulted by Downloads.Drive.
+// There's only ever one row, see DownloadScheduleID.
+type DownloadSchedule struct {
+	ID string `hades:"primary_key"`
+
+	// JSON-encoded butlerd.DownloadSchedule
+	Schedule JSON
+}
+
+const DownloadScheduleID = "downloads"
+
+// GetDownloadSchedule returns the stored schedule, or nil if none was ever set.
+func GetDownloadSchedule(conn *sqlite.Conn) *DownloadSchedule {
+	var ds DownloadSchedule
+	if MustSelectOne(conn, &ds, builder.Eq{"id": DownloadScheduleID}) {
+		return &ds
+	}
+	return nil
+}
+
+func (ds *DownloadSchedule) Save(conn *sqlite.Conn) {
+	ds.ID = DownloadScheduleID
+	MustSave(conn, ds)
+}
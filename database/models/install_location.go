@@ -13,6 +13,13 @@ type InstallLocation struct {
 
 	Path string `json:"path"`
 
+	// How many previous builds to keep per cave, so upgrades can be
+	// rolled back. 0 disables rollback snapshots.
+	RollbackKeep int64 `json:"rollbackKeep"`
+	// Snapshots of installs bigger than this (in bytes) aren't kept.
+	// 0 means no limit.
+	RollbackMaxSize int64 `json:"rollbackMaxSize"`
+
 	Caves []*Cave `json:"caves"`
 }
 
@@ -32,6 +39,10 @@ func (il *InstallLocation) GetStagingFolder(installID string) string {
 	return filepath.Join(il.Path, "downloads", installID)
 }
 
+func (il *InstallLocation) GetRollbackFolder(caveID string) string {
+	return filepath.Join(il.Path, "rollback", caveID)
+}
+
 func (il *InstallLocation) GetCaves(conn *sqlite.Conn) []*Cave {
 	MustPreload(conn, il,
 		hades.Assoc("Caves"),
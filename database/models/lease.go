@@ -0,0 +1,102 @@
+package models
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"xorm.io/builder"
+)
+
+// Lease is a named lock held by a single holder at a time. Holders
+// must renew it regularly: a lease whose last heartbeat is too old
+// is considered stale, and can be taken over.
+type Lease struct {
+	ID string `hades:"primary_key"`
+
+	// Opaque identifier of whoever holds the lease
+	Holder string
+	// Process the holder runs in
+	PID int64
+
+	AcquiredAt  *time.Time
+	HeartbeatAt *time.Time
+}
+
+// serializes lease operations within this process, sqlite
+// takes care of the rest.
+var leaseLock sync.Mutex
+
+func LeaseByID(conn *sqlite.Conn, id string) *Lease {
+	var l Lease
+	if MustSelectOne(conn, &l, builder.Eq{"id": id}) {
+		return &l
+	}
+	return nil
+}
+
+// Stale returns true if the lease hasn't been renewed within ttl
+func (l *Lease) Stale(ttl time.Duration) bool {
+	return l.HeartbeatAt == nil || time.Since(*l.HeartbeatAt) > ttl
+}
+
+// TryAcquireLease takes the lease with the given ID for holder, if nobody
+// holds it or if it has gone stale. It returns the lease as it was before
+// the call (nil if there was none), and whether it was acquired.
+func TryAcquireLease(conn *sqlite.Conn, id string, holder string, ttl time.Duration) (previous *Lease, acquired bool, retErr error) {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	defer sqlitex.Save(conn)(&retErr)
+
+	previous = LeaseByID(conn, id)
+	if previous != nil && previous.Holder != holder && !previous.Stale(ttl) {
+		return previous, false, nil
+	}
+
+	now := time.Now().UTC()
+	err := Save(conn, &Lease{
+		ID:          id,
+		Holder:      holder,
+		PID:         int64(os.Getpid()),
+		AcquiredAt:  &now,
+		HeartbeatAt: &now,
+	})
+	if err != nil {
+		return previous, false, err
+	}
+	return previous, true, nil
+}
+
+// RenewLease records a heartbeat for holder. It returns false if the
+// lease is no longer held by holder, ie. if it was taken over.
+func RenewLease(conn *sqlite.Conn, id string, holder string) (renewed bool, retErr error) {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+	defer sqlitex.Save(conn)(&retErr)
+
+	lease := LeaseByID(conn, id)
+	if lease == nil || lease.Holder != holder {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	lease.HeartbeatAt = &now
+	err := Save(conn, lease)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseLease gives up the lease, if it's still held by holder.
+func ReleaseLease(conn *sqlite.Conn, id string, holder string) error {
+	leaseLock.Lock()
+	defer leaseLock.Unlock()
+
+	return Delete(conn, &Lease{}, builder.And(
+		builder.Eq{"id": id},
+		builder.Eq{"holder": holder},
+	))
+}
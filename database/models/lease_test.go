@@ -0,0 +1,71 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/stretchr/testify/require"
+)
+
+func leaseTestConn(t *testing.T) *sqlite.Conn {
+	conn, err := sqlite.OpenConn("file::memory:?mode=memory", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, HadesContext().AutoMigrate(conn))
+	return conn
+}
+
+func Test_LeaseExclusive(t *testing.T) {
+	conn := leaseTestConn(t)
+
+	previous, acquired, err := TryAcquireLease(conn, "test", "alice", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	require.Nil(t, previous)
+
+	previous, acquired, err = TryAcquireLease(conn, "test", "bob", time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.EqualValues(t, "alice", previous.Holder)
+
+	renewed, err := RenewLease(conn, "test", "bob")
+	require.NoError(t, err)
+	require.False(t, renewed)
+
+	renewed, err = RenewLease(conn, "test", "alice")
+	require.NoError(t, err)
+	require.True(t, renewed)
+
+	// releasing someone else's lease is a no-op
+	require.NoError(t, ReleaseLease(conn, "test", "bob"))
+	require.NotNil(t, LeaseByID(conn, "test"))
+
+	require.NoError(t, ReleaseLease(conn, "test", "alice"))
+	require.Nil(t, LeaseByID(conn, "test"))
+
+	_, acquired, err = TryAcquireLease(conn, "test", "bob", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
+
+func Test_LeaseTakeOverStale(t *testing.T) {
+	conn := leaseTestConn(t)
+
+	longAgo := time.Now().UTC().Add(-time.Hour)
+	MustSave(conn, &Lease{
+		ID:          "test",
+		Holder:      "alice",
+		AcquiredAt:  &longAgo,
+		HeartbeatAt: &longAgo,
+	})
+
+	previous, acquired, err := TryAcquireLease(conn, "test", "bob", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	require.EqualValues(t, "alice", previous.Holder)
+
+	renewed, err := RenewLease(conn, "test", "alice")
+	require.NoError(t, err)
+	require.False(t, renewed)
+}
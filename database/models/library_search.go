@@ -0,0 +1,246 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/pkg/errors"
+)
+
+// The library search index is an FTS5 table over locally-cached games,
+// bundles and collections. It's not a hades model: it's kept in sync
+// by triggers on the tables it indexes, so every hades save (and delete)
+// updates it in the same transaction.
+//
+// Rows are keyed by rowid, which encodes both the kind of item and its ID:
+// `id * 4 + kind`.
+const librarySearchTable = "library_search"
+
+type LibrarySearchKind int64
+
+const (
+	LibrarySearchKindGame       LibrarySearchKind = 1
+	LibrarySearchKindBundle     LibrarySearchKind = 2
+	LibrarySearchKindCollection LibrarySearchKind = 3
+
+	librarySearchKindCount = 4
+)
+
+// bm25 weights, in column order: title matches matter most, then developer
+// names, then kinds and collection names, then short texts.
+//
+// A game's kind is its classification and type (say, "game html"): that's
+// what the API gives us locally. Tags aren't cached, so they aren't indexed.
+const librarySearchRank = "bm25(library_search, 10.0, 1.0, 2.0, 4.0, 2.0)"
+
+const librarySearchSchema = `CREATE VIRTUAL TABLE library_search USING fts5(
+	title, short_text, kind, developer, collections,
+	tokenize = 'unicode61 remove_diacritics 2'
+)`
+
+// reindexGamesSQL refreshes the entries of the games whose IDs are
+// returned by the given expression.
+func reindexGamesSQL(ids string) string {
+	return fmt.Sprintf(`
+DELETE FROM library_search WHERE rowid IN (SELECT id * 4 + 1 FROM games WHERE id IN (%[1]s));
+INSERT INTO library_search (rowid, title, short_text, kind, developer, collections)
+	SELECT g.id * 4 + 1, g.title, g.short_text,
+		trim(coalesce(g.classification, '') || ' ' || coalesce(g.type, '')),
+		trim(coalesce(u.display_name, '') || ' ' || coalesce(u.username, '')),
+		(SELECT group_concat(c.title, ' ') FROM collection_games cg
+			INNER JOIN collections c ON c.id = cg.collection_id
+			WHERE cg.game_id = g.id)
+	FROM games g LEFT JOIN users u ON u.id = g.user_id
+	WHERE g.id IN (%[1]s);`, ids)
+}
+
+func reindexCollectionsSQL(ids string) string {
+	return fmt.Sprintf(`
+DELETE FROM library_search WHERE rowid IN (SELECT id * 4 + 3 FROM collections WHERE id IN (%[1]s));
+INSERT INTO library_search (rowid, title, developer)
+	SELECT c.id * 4 + 3, c.title,
+		trim(coalesce(u.display_name, '') || ' ' || coalesce(u.username, ''))
+	FROM collections c LEFT JOIN users u ON u.id = c.user_id
+	WHERE c.id IN (%[1]s);`, ids)
+}
+
+func reindexBundlesSQL(ids string) string {
+	return fmt.Sprintf(`
+DELETE FROM library_search WHERE rowid IN (SELECT id * 4 + 2 FROM bundles WHERE id IN (%[1]s));
+INSERT INTO library_search (rowid, title)
+	SELECT b.id * 4 + 2, b.title FROM bundles b WHERE b.id IN (%[1]s);`, ids)
+}
+
+type librarySearchTrigger struct {
+	table string
+	event string
+	body  string
+}
+
+var librarySearchTriggers = []librarySearchTrigger{
+	{"games", "INSERT", reindexGamesSQL("NEW.id")},
+	{"games", "UPDATE", reindexGamesSQL("NEW.id")},
+	{"games", "DELETE", "DELETE FROM library_search WHERE rowid = OLD.id * 4 + 1;"},
+
+	{"users", "INSERT", reindexGamesSQL("SELECT id FROM games WHERE user_id = NEW.id") +
+		reindexCollectionsSQL("SELECT id FROM collections WHERE user_id = NEW.id")},
+	{"users", "UPDATE", reindexGamesSQL("SELECT id FROM games WHERE user_id = NEW.id") +
+		reindexCollectionsSQL("SELECT id FROM collections WHERE user_id = NEW.id")},
+
+	{"collections", "INSERT", reindexCollectionsSQL("NEW.id") +
+		reindexGamesSQL("SELECT game_id FROM collection_games WHERE collection_id = NEW.id")},
+	{"collections", "UPDATE", reindexCollectionsSQL("NEW.id") +
+		reindexGamesSQL("SELECT game_id FROM collection_games WHERE collection_id = NEW.id")},
+	{"collections", "DELETE", "DELETE FROM library_search WHERE rowid = OLD.id * 4 + 3;" +
+		reindexGamesSQL("SELECT game_id FROM collection_games WHERE collection_id = OLD.id")},
+
+	{"collection_games", "INSERT", reindexGamesSQL("NEW.game_id")},
+	{"collection_games", "UPDATE", reindexGamesSQL("OLD.game_id, NEW.game_id")},
+	{"collection_games", "DELETE", reindexGamesSQL("OLD.game_id")},
+
+	{"bundles", "INSERT", reindexBundlesSQL("NEW.id")},
+	{"bundles", "UPDATE", reindexBundlesSQL("NEW.id")},
+	{"bundles", "DELETE", "DELETE FROM library_search WHERE rowid = OLD.id * 4 + 2;"},
+}
+
+func (t librarySearchTrigger) name() string {
+	return fmt.Sprintf("library_search_%s_%s", t.table, strings.ToLower(t.event))
+}
+
+func (t librarySearchTrigger) schema() string {
+	return fmt.Sprintf("CREATE TRIGGER %s AFTER %s ON %s BEGIN %s END", t.name(), t.event, t.table, t.body)
+}
+
+// PrepareLibrarySearch creates the library search index and its triggers,
+// if needed. Since AutoMigrate drops triggers along with the tables it
+// rebuilds, it must be called after every AutoMigrate. Whenever anything
+// was missing, or created by an older version with another schema, the
+// whole index is rebuilt.
+func PrepareLibrarySearch(conn *sqlite.Conn) (retErr error) {
+	defer sqlitex.Save(conn)(&retErr)
+
+	var table string
+	triggers := make(map[string]string)
+	err := ExecRaw(conn, "SELECT type, name, sql FROM sqlite_master WHERE type IN ('table', 'trigger') AND name LIKE 'library_search%'", func(stmt *sqlite.Stmt) error {
+		switch {
+		case stmt.ColumnText(0) == "trigger":
+			triggers[stmt.ColumnText(1)] = stmt.ColumnText(2)
+		case stmt.ColumnText(1) == librarySearchTable:
+			table = stmt.ColumnText(2)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	stale := false
+	if table != librarySearchSchema {
+		stale = true
+		if table != "" {
+			// triggers write to the old columns, so they go too
+			err = ExecRaw(conn, "DROP TABLE "+librarySearchTable, nil)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for name := range triggers {
+				err = ExecRaw(conn, "DROP TRIGGER "+name, nil)
+				if err != nil {
+					return errors.Wrapf(err, "dropping trigger %s", name)
+				}
+			}
+			triggers = nil
+		}
+		err = ExecRaw(conn, librarySearchSchema, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, t := range librarySearchTriggers {
+		schema := t.schema()
+		if triggers[t.name()] == schema {
+			continue
+		}
+		stale = true
+		if _, ok := triggers[t.name()]; ok {
+			err = ExecRaw(conn, "DROP TRIGGER "+t.name(), nil)
+			if err != nil {
+				return errors.Wrapf(err, "dropping trigger %s", t.name())
+			}
+		}
+		err = ExecRaw(conn, schema, nil)
+		if err != nil {
+			return errors.Wrapf(err, "creating trigger %s", t.name())
+		}
+	}
+
+	if stale {
+		return RebuildLibrarySearch(conn)
+	}
+	return nil
+}
+
+// RebuildLibrarySearch re-indexes every cached game, bundle and collection.
+func RebuildLibrarySearch(conn *sqlite.Conn) error {
+	queries := "DELETE FROM library_search;" +
+		reindexGamesSQL("SELECT id FROM games") +
+		reindexCollectionsSQL("SELECT id FROM collections") +
+		reindexBundlesSQL("SELECT id FROM bundles")
+	return errors.WithStack(sqlitex.ExecScript(conn, queries))
+}
+
+type LibrarySearchHit struct {
+	Kind LibrarySearchKind
+	ID   int64
+}
+
+// LibrarySearchQuery turns user input into an FTS5 query: every word
+// must match, as a prefix, in any column. Returns an empty string if
+// there's nothing to search for.
+func LibrarySearchQuery(input string) string {
+	var terms []string
+	for _, word := range strings.Fields(input) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchLibrary returns matches for the given user input, best first.
+// Games are not scoped to a profile, bundles are limited to those
+// profileID owns, and collections to those in its collection list.
+// A limit of 0 means no limit.
+func SearchLibrary(conn *sqlite.Conn, input string, profileID int64, limit int64, offset int64) ([]LibrarySearchHit, error) {
+	match := LibrarySearchQuery(input)
+	if match == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = -1
+	}
+
+	query := `SELECT library_search.rowid FROM library_search
+	WHERE library_search MATCH ?
+	AND (library_search.rowid % 4 = 1
+		OR (library_search.rowid % 4 = 2 AND EXISTS (SELECT 1 FROM bundle_keys WHERE bundle_keys.bundle_id = library_search.rowid / 4 AND bundle_keys.owner_id = ?))
+		OR (library_search.rowid % 4 = 3 AND EXISTS (SELECT 1 FROM profile_collections WHERE profile_collections.collection_id = library_search.rowid / 4 AND profile_collections.profile_id = ?)))
+	ORDER BY ` + librarySearchRank + `, library_search.rowid
+	LIMIT ? OFFSET ?`
+
+	var hits []LibrarySearchHit
+	err := ExecRaw(conn, query, func(stmt *sqlite.Stmt) error {
+		rowID := stmt.ColumnInt64(0)
+		hits = append(hits, LibrarySearchHit{
+			Kind: LibrarySearchKind(rowID % librarySearchKindCount),
+			ID:   rowID / librarySearchKindCount,
+		})
+		return nil
+	}, match, profileID, profileID, limit, offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return hits, nil
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"testing"
+
+	"crawshaw.io/sqlite"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/stretchr/testify/require"
+	"xorm.io/builder"
+)
+
+func librarySearchConn(t *testing.T) *sqlite.Conn {
+	conn, err := sqlite.OpenConn("file::memory:?mode=memory", 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, HadesContext().AutoMigrate(conn))
+	require.NoError(t, PrepareLibrarySearch(conn))
+	return conn
+}
+
+func searchLibrary(t *testing.T, conn *sqlite.Conn, input string) []LibrarySearchHit {
+	hits, err := SearchLibrary(conn, input, 1, 0, 0)
+	require.NoError(t, err)
+	return hits
+}
+
+func gameHit(id int64) LibrarySearchHit {
+	return LibrarySearchHit{Kind: LibrarySearchKindGame, ID: id}
+}
+
+func Test_LibrarySearchIndexesGames(t *testing.T) {
+	conn := librarySearchConn(t)
+
+	MustSave(conn, &itchio.User{ID: 7, Username: "mattmakesgames", DisplayName: "Maddy Makes Games"})
+	MustSave(conn, &itchio.Game{
+		ID:             1,
+		Title:          "Celeste",
+		ShortText:      "A mountain climbing platformer",
+		Classification: "game",
+		Type:           "html",
+		UserID:         7,
+	})
+	MustSave(conn, &itchio.Game{ID: 2, Title: "Cozy Grove", ShortText: "Haunted island"})
+
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "celeste"))
+	// short text
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "mountain"))
+	// kind
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "html"))
+	// developer, as a prefix
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "maddy"))
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "mattmakes"))
+	// every word must match
+	require.Empty(t, searchLibrary(t, conn, "celeste island"))
+	require.Empty(t, searchLibrary(t, conn, `"`))
+
+	// updates are picked up
+	MustSave(conn, &itchio.Game{ID: 2, Title: "Spooky Grove", ShortText: "Haunted island"})
+	require.Empty(t, searchLibrary(t, conn, "cozy"))
+	require.Equal(t, []LibrarySearchHit{gameHit(2)}, searchLibrary(t, conn, "spooky"))
+
+	// and so are developer renames
+	MustSave(conn, &itchio.User{ID: 7, Username: "exok", DisplayName: "Extremely OK Games"})
+	require.Empty(t, searchLibrary(t, conn, "maddy"))
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "extremely"))
+
+	MustDelete(conn, &itchio.Game{}, builder.Eq{"id": 1})
+	require.Empty(t, searchLibrary(t, conn, "celeste"))
+}
+
+func Test_LibrarySearchCollections(t *testing.T) {
+	conn := librarySearchConn(t)
+
+	MustSave(conn, &itchio.Game{ID: 1, Title: "Celeste"})
+	MustSave(conn, &itchio.Collection{ID: 30, Title: "Cozy favorites"})
+	MustSave(conn, &itchio.Collection{ID: 40, Title: "Cozy things from strangers"})
+	MustSave(conn, &ProfileCollection{CollectionID: 30, ProfileID: 1})
+	MustSave(conn, &itchio.CollectionGame{CollectionID: 30, GameID: 1})
+
+	// games match on the names of collections they're in, and collections
+	// are limited to those in the profile's collection list
+	require.Equal(t, []LibrarySearchHit{
+		{Kind: LibrarySearchKindCollection, ID: 30},
+		gameHit(1),
+	}, searchLibrary(t, conn, "cozy"))
+
+	MustDelete(conn, &itchio.CollectionGame{}, builder.Eq{"collection_id": 30})
+	require.Equal(t, []LibrarySearchHit{
+		{Kind: LibrarySearchKindCollection, ID: 30},
+	}, searchLibrary(t, conn, "cozy"))
+}
+
+func Test_LibrarySearchRankingAndPages(t *testing.T) {
+	conn := librarySearchConn(t)
+
+	MustSave(conn, &itchio.User{ID: 7, Username: "forest", DisplayName: "Forest Folk"})
+	MustSave(conn, &itchio.Game{ID: 1, Title: "Lost Woods", ShortText: "Deep in the forest", UserID: 7})
+	MustSave(conn, &itchio.Game{ID: 2, Title: "Forest"})
+	MustSave(conn, &itchio.Game{ID: 3, Title: "Mines", ShortText: "Far from any forest"})
+
+	// title matches rank above developer matches, which rank above short texts
+	require.Equal(t, []LibrarySearchHit{gameHit(2), gameHit(1), gameHit(3)}, searchLibrary(t, conn, "forest"))
+
+	page, err := SearchLibrary(conn, "forest", 1, 2, 2)
+	require.NoError(t, err)
+	require.Equal(t, []LibrarySearchHit{gameHit(3)}, page)
+}
+
+func Test_PrepareLibrarySearchBackfills(t *testing.T) {
+	conn, err := sqlite.OpenConn("file::memory:?mode=memory", 0)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, HadesContext().AutoMigrate(conn))
+
+	// saved before the index existed
+	MustSave(conn, &itchio.Game{ID: 1, Title: "Celeste"})
+	MustSave(conn, &itchio.Bundle{ID: 10, Title: "Celestial Bundle"})
+	MustSave(conn, &itchio.BundleKey{ID: 100, BundleID: 10, OwnerID: 1})
+
+	require.NoError(t, PrepareLibrarySearch(conn))
+	// idempotent
+	require.NoError(t, PrepareLibrarySearch(conn))
+
+	require.ElementsMatch(t, []LibrarySearchHit{
+		gameHit(1),
+		{Kind: LibrarySearchKindBundle, ID: 10},
+	}, searchLibrary(t, conn, "celest"))
+
+	// bundles are limited to those the profile owns
+	hits, err := SearchLibrary(conn, "celest", 2, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, hits)
+}
+
+func Test_PrepareLibrarySearchRebuildsOldSchema(t *testing.T) {
+	conn, err := sqlite.OpenConn("file::memory:?mode=memory", 0)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, HadesContext().AutoMigrate(conn))
+
+	// as created by an older version, with a trigger writing to its columns
+	MustExecRaw(conn, `CREATE VIRTUAL TABLE library_search USING fts5(title, tags)`, nil)
+	MustExecRaw(conn, `CREATE TRIGGER library_search_games_insert AFTER INSERT ON games BEGIN
+		INSERT INTO library_search (rowid, title, tags) VALUES (NEW.id * 4 + 1, NEW.title, NEW.type);
+	END`, nil)
+	MustSave(conn, &itchio.Game{ID: 1, Title: "Celeste", Classification: "tool"})
+
+	require.NoError(t, PrepareLibrarySearch(conn))
+	require.Equal(t, []LibrarySearchHit{gameHit(1)}, searchLibrary(t, conn, "tool"))
+
+	MustSave(conn, &itchio.Game{ID: 2, Title: "Cozy Grove"})
+	require.Equal(t, []LibrarySearchHit{gameHit(2)}, searchLibrary(t, conn, "cozy"))
+}
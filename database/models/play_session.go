@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// PlaySession is a local record of a game being launched and played,
+// kept whether or not the session could be reported to itch.io.
+// Unlike Cave.SecondsRun, it knows when play time happened, and
+// which profile it happened under.
+type PlaySession struct {
+	ID string `hades:"primary_key"`
+
+	CaveID    string
+	GameID    int64
+	ProfileID int64
+
+	StartedAt  *time.Time
+	SecondsRun int64
+}
+
+func (ps *PlaySession) Save(conn *sqlite.Conn) {
+	MustSave(conn, ps)
+}
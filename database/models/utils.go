@@ -145,3 +145,11 @@ func ColumnTime(col int, stmt *sqlite.Stmt) *time.Time {
 	}
 	return nil
 }
+
+// TimeAfter matches rows where column holds a time later than t. Times
+// are stored as RFC 3339 text with as few fractional digits as
+// needed, which doesn't sort as text, so they're compared as julian
+// days (down to the millisecond).
+func TimeAfter(column string, t time.Time) builder.Cond {
+	return builder.Expr("julianday("+column+") > julianday(?)", t.UTC().Format(time.RFC3339Nano))
+}
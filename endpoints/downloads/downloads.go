@@ -14,4 +14,7 @@ func Register(router *butlerd.Router) {
 	messages.DownloadsClearFinished.Register(router, DownloadsClearFinished)
 	messages.DownloadsDiscard.Register(router, DownloadsDiscard)
 	messages.DownloadsRetry.Register(router, DownloadsRetry)
+	messages.DownloadsHistory.Register(router, DownloadsHistory)
+	messages.DownloadsScheduleGet.Register(router, DownloadsScheduleGet)
+	messages.DownloadsScheduleSet.Register(router, DownloadsScheduleSet)
 }
@@ -0,0 +1,188 @@
+package downloads
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/messages"
+	"github.com/itchio/httpkit/neterr"
+	"github.com/itchio/httpkit/timeout"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultWebAddress = "https://itch.io"
+	defaultAPIAddress = "https://api.itch.io"
+
+	defaultConnectivityTimeout = 120 * time.Second
+	maxConnectivityBackoff     = 30 * time.Second
+)
+
+// connectivityChecker finds out whether we're back online
+// after a download failed because of a network error.
+type connectivityChecker struct {
+	kind    butlerd.ConnectivityCheckKind
+	target  string
+	timeout time.Duration
+	client  *http.Client
+
+	// backoff, when set, doubles the delay between checks
+	backoff bool
+	// accept decides whether a response means we're online
+	accept func(res *http.Response) bool
+}
+
+func newConnectivityChecker(rc *butlerd.RequestContext, params *butlerd.ConnectivityCheck) *connectivityChecker {
+	if params == nil {
+		params = &butlerd.ConnectivityCheck{
+			Kind: butlerd.ConnectivityCheckKindPing,
+		}
+	}
+
+	cc := &connectivityChecker{
+		kind:    params.Kind,
+		timeout: defaultConnectivityTimeout,
+		client:  timeout.NewDefaultClient(),
+		accept:  isSuccess,
+	}
+	if params.Timeout > 0 {
+		cc.timeout = time.Duration(params.Timeout) * time.Second
+	}
+
+	switch params.Kind {
+	case butlerd.ConnectivityCheckKindURL:
+		cc.target = params.URL
+	case butlerd.ConnectivityCheckKindAPI:
+		cc.target = orDefault(rc.APIAddress, defaultAPIAddress)
+		cc.backoff = true
+		cc.accept = func(res *http.Response) bool {
+			// any answer means we got through
+			return true
+		}
+	default:
+		cc.kind = butlerd.ConnectivityCheckKindPing
+		cc.target = strings.TrimSuffix(orDefault(rc.WebAddress, defaultWebAddress), "/") + "/static/ping.txt"
+	}
+	return cc
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func isSuccess(res *http.Response) bool {
+	return res.StatusCode >= 200 && res.StatusCode < 300
+}
+
+// delay returns how long to wait after the given number of failed checks.
+func (cc *connectivityChecker) delay(attempts int64) time.Duration {
+	if !cc.backoff {
+		return 1 * time.Second
+	}
+
+	d := 1 * time.Second
+	for i := int64(1); i < attempts; i++ {
+		d *= 2
+		if d >= maxConnectivityBackoff {
+			return maxConnectivityBackoff
+		}
+	}
+	return d
+}
+
+// check returns a short description of the response if we're online,
+// or an error if we're not.
+func (cc *connectivityChecker) check(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", cc.target, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+
+	res, err := cc.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if !cc.accept(res) {
+		return "", errors.Errorf("%s returned HTTP %d", cc.target, res.StatusCode)
+	}
+
+	payload, _ := io.ReadAll(res.Body)
+	desc := strings.TrimSpace(string(payload))
+	if len(desc) > 80 || cc.kind == butlerd.ConnectivityCheckKindAPI {
+		desc = res.Status
+	}
+	return desc, nil
+}
+
+func (cc *connectivityChecker) notify(rc *butlerd.RequestContext, status butlerd.NetworkStatus, attempts int64, retryIn time.Duration) {
+	messages.DownloadsDriveNetworkStatus.Notify(rc, butlerd.DownloadsDriveNetworkStatusNotification{
+		Status:   status,
+		Check:    cc.kind,
+		Target:   cc.target,
+		Attempts: attempts,
+		RetryIn:  retryIn.Seconds(),
+	})
+}
+
+// waitForInternet blocks until the connectivity check passes,
+// the checker's timeout elapses, or ctx is cancelled.
+func waitForInternet(ctx context.Context, rc *butlerd.RequestContext, status *Status, cc *connectivityChecker, lease *driveLease) error {
+	consumer := rc.Consumer
+
+	// notify always, but only log once
+	cc.notify(rc, butlerd.NetworkStatusOffline, 0, 0)
+	if status.Online {
+		status.Online = false
+		consumer.Opf("Looks like we're offline! Waiting for an internet connection (%s %s)...", cc.kind, cc.target)
+	}
+
+	deadline := time.Now().Add(cc.timeout)
+	var attempts int64
+	for {
+		// keep the lease alive while we wait, losing it
+		// is noticed by the drive loop.
+		_, err := lease.heartbeat(rc)
+		if err != nil {
+			consumer.Warnf("While renewing downloads drive lease: %+v", err)
+		}
+
+		desc, err := cc.check(ctx)
+		if err == nil {
+			consumer.Statf("Looks like we're back online! (%s)", desc)
+			cc.notify(rc, butlerd.NetworkStatusOnline, attempts, 0)
+			status.Online = true
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !neterr.IsNetworkError(err) {
+			consumer.Warnf("Got non-network error while checking connectivity: %+v", err)
+		}
+
+		attempts++
+		delay := cc.delay(attempts)
+		if time.Now().Add(delay).After(deadline) {
+			consumer.Warnf("Still offline after %d checks, retrying downloads anyway", attempts)
+			return nil
+		}
+		cc.notify(rc, butlerd.NetworkStatusOffline, attempts, delay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
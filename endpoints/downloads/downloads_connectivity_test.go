@@ -0,0 +1,88 @@
+package downloads
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ConnectivityCheckerTargets(t *testing.T) {
+	rc := &butlerd.RequestContext{
+		WebAddress: "https://itch.example/",
+		APIAddress: "https://api.itch.example",
+	}
+
+	cc := newConnectivityChecker(rc, nil)
+	require.Equal(t, butlerd.ConnectivityCheckKindPing, cc.kind)
+	require.Equal(t, "https://itch.example/static/ping.txt", cc.target)
+	require.Equal(t, defaultConnectivityTimeout, cc.timeout)
+
+	cc = newConnectivityChecker(rc, &butlerd.ConnectivityCheck{
+		Kind:    butlerd.ConnectivityCheckKindURL,
+		URL:     "http://localhost/health",
+		Timeout: 10,
+	})
+	require.Equal(t, "http://localhost/health", cc.target)
+	require.Equal(t, 10*time.Second, cc.timeout)
+
+	cc = newConnectivityChecker(&butlerd.RequestContext{}, &butlerd.ConnectivityCheck{
+		Kind: butlerd.ConnectivityCheckKindAPI,
+	})
+	require.Equal(t, defaultAPIAddress, cc.target)
+}
+
+func Test_ConnectivityCheckerDelay(t *testing.T) {
+	rc := &butlerd.RequestContext{}
+
+	cc := newConnectivityChecker(rc, nil)
+	require.Equal(t, 1*time.Second, cc.delay(1))
+	require.Equal(t, 1*time.Second, cc.delay(10))
+
+	cc = newConnectivityChecker(rc, &butlerd.ConnectivityCheck{
+		Kind: butlerd.ConnectivityCheckKindAPI,
+	})
+	require.Equal(t, 1*time.Second, cc.delay(1))
+	require.Equal(t, 2*time.Second, cc.delay(2))
+	require.Equal(t, 16*time.Second, cc.delay(5))
+	require.Equal(t, maxConnectivityBackoff, cc.delay(6))
+	require.Equal(t, maxConnectivityBackoff, cc.delay(100))
+}
+
+func Test_ConnectivityCheckerCheck(t *testing.T) {
+	code := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		w.Write([]byte("pong\n"))
+	}))
+	defer server.Close()
+
+	rc := &butlerd.RequestContext{
+		APIAddress: server.URL,
+	}
+	ctx := context.Background()
+
+	urlCC := newConnectivityChecker(rc, &butlerd.ConnectivityCheck{
+		Kind: butlerd.ConnectivityCheckKindURL,
+		URL:  server.URL,
+	})
+	apiCC := newConnectivityChecker(rc, &butlerd.ConnectivityCheck{
+		Kind: butlerd.ConnectivityCheckKindAPI,
+	})
+
+	_, err := urlCC.check(ctx)
+	require.Error(t, err)
+
+	// the API answering at all means we're online
+	_, err = apiCC.check(ctx)
+	require.NoError(t, err)
+
+	code = http.StatusOK
+	desc, err := urlCC.check(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "pong", desc)
+}
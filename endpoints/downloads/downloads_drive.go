@@ -3,21 +3,19 @@ package downloads
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/itchio/wharf/werrors"
 
 	"github.com/itchio/httpkit/neterr"
-	"github.com/itchio/httpkit/timeout"
 
 	"github.com/itchio/butler/butlerd/jsonrpc2"
 
 	"github.com/pkg/errors"
 
 	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/horror"
 	"github.com/itchio/butler/butlerd/messages"
 	"github.com/itchio/butler/cmd/operate"
 	"github.com/itchio/butler/cmd/wipe"
@@ -30,8 +28,6 @@ import (
 
 var downloadsDriveCancelID = "Downloads.Drive"
 
-const pingURL = "https://itch.io/static/ping.txt"
-
 type Status struct {
 	Online bool
 }
@@ -43,16 +39,25 @@ type tempLockfileErr interface {
 func DownloadsDrive(rc *butlerd.RequestContext, params butlerd.DownloadsDriveParams) (*butlerd.DownloadsDriveResult, error) {
 	consumer := rc.Consumer
 
-	// TODO: implement downloads drive lock via the database.
+	lease, err := acquireDriveLease(rc)
+	if err != nil {
+		return nil, err
+	}
+	defer lease.release(rc)
 
-	consumer.Infof("Now driving downloads...")
+	slots := newDownloadSlots(params.GetSlots())
+	consumer.Infof("Now driving downloads (%d slots)...", slots.count())
 
 	ctx, cleanup := rc.MakeCancelable(downloadsDriveCancelID)
 	defer cleanup()
 
+	connectivity := newConnectivityChecker(rc, params.Connectivity)
+
 	status := &Status{
 		Online: true,
 	}
+	schedule := newScheduleWatcher()
+	defer schedule.stop()
 
 poll:
 	for {
@@ -65,70 +70,47 @@ poll:
 			// let's keep going
 		}
 
-		err := cleanDiscarded(rc)
+		held, err := lease.heartbeat(rc)
 		if err != nil {
-			consumer.Warnf("%+v", errors.WithMessage(err, "while cleaning discarded:"))
+			consumer.Warnf("%+v", errors.WithMessage(err, "while renewing downloads drive lease:"))
+		} else if !held {
+			consumer.Warnf("Lost downloads drive lease, somebody else took over. Stopping!")
+			cleanup()
+			break poll
 		}
 
-		err = performOne(ctx, rc)
-		if err != nil {
-			if err == butlerd.CodeNetworkDisconnected {
-				err = waitForInternet(rc, status)
-				if err != nil {
-					consumer.Warnf("%+v", errors.WithMessage(err, "while waiting for internet:"))
-				}
-			} else {
-				consumer.Warnf("%+v", errors.WithMessage(err, "while performing download:"))
+		if slots.takeDisconnected() {
+			err := waitForInternet(ctx, rc, status, connectivity, lease)
+			if err != nil {
+				consumer.Warnf("%+v", errors.WithMessage(err, "while waiting for internet:"))
 			}
 		}
 
-		time.Sleep(1 * time.Second)
-	}
-
-	res := &butlerd.DownloadsDriveResult{}
-	return res, nil
-}
-
-func waitForInternet(rc *butlerd.RequestContext, status *Status) error {
-	consumer := rc.Consumer
-
-	// notify always, but only log once
-	messages.DownloadsDriveNetworkStatus.Notify(rc, butlerd.DownloadsDriveNetworkStatusNotification{
-		Status: butlerd.NetworkStatusOffline,
-	})
-	if status.Online {
-		status.Online = false
-		consumer.Opf("Looks like we're offline! Waiting for an internet connection...")
-	}
-
-	client := timeout.NewDefaultClient()
-
-	// wait up to 120 rounds (2 minutes if tries take 0s,
-	// which they don't), then give up waiting
-	for i := 0; i < 120; i++ {
-		res, err := client.Get(pingURL)
+		err = cleanDiscarded(rc, slots)
 		if err != nil {
-			if neterr.IsNetworkError(err) {
-				// keep going...
-			} else {
-				consumer.Warnf("Got non-network error while pinging: %+v", err)
-			}
+			consumer.Warnf("%+v", errors.WithMessage(err, "while cleaning discarded:"))
+		}
+
+		if schedule.poll(rc).Paused {
+			slots.cancelAll()
 		} else {
-			payload, _ := ioutil.ReadAll(res.Body)
-			consumer.Statf("Looks like we're back online! (%s)", strings.TrimSpace(string(payload)))
-			messages.DownloadsDriveNetworkStatus.Notify(rc, butlerd.DownloadsDriveNetworkStatusNotification{
-				Status: butlerd.NetworkStatusOnline,
-			})
-			status.Online = true
-			return nil
+			err = fillSlots(ctx, rc, slots)
+			if err != nil {
+				consumer.Warnf("%+v", errors.WithMessage(err, "while filling download slots:"))
+			}
 		}
 
 		time.Sleep(1 * time.Second)
 	}
-	return nil
+
+	consumer.Infof("Waiting for download slots to wind down...")
+	slots.wait()
+
+	res := &butlerd.DownloadsDriveResult{}
+	return res, nil
 }
 
-func cleanDiscarded(rc *butlerd.RequestContext) error {
+func cleanDiscarded(rc *butlerd.RequestContext, slots *downloadSlots) error {
 	consumer := rc.Consumer
 
 	var discardedDownloads []*models.Download
@@ -137,6 +119,11 @@ func cleanDiscarded(rc *butlerd.RequestContext) error {
 		models.PreloadDownloads(conn, discardedDownloads)
 	})
 	for _, download := range discardedDownloads {
+		if slots.isActive(download.ID) {
+			// its slot will notice soon enough, clean it up once it's done
+			continue
+		}
+
 		consumer.Opf("Cleaning up download for %s", operate.GameToString(download.Game))
 
 		if download.StagingFolder == "" {
@@ -172,73 +159,105 @@ func cleanDiscarded(rc *butlerd.RequestContext) error {
 	return nil
 }
 
-func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
+var pendingDownloadsCond = builder.And(
+	builder.IsNull{"finished_at"},
+	builder.Not{builder.Expr("discarded")},
+)
+
+// fillSlots starts the highest-priority pending downloads
+// in any free slot, each with its own forked request context.
+func fillSlots(ctx context.Context, rc *butlerd.RequestContext, slots *downloadSlots) error {
 	consumer := rc.Consumer
 
 	var pendingDownloads []*models.Download
-	var download *models.Download
 	rc.WithConn(func(conn *sqlite.Conn) {
 		models.MustSelect(conn, &pendingDownloads,
-			builder.And(
-				builder.IsNull{"finished_at"},
-				builder.Not{builder.Expr("discarded")},
-			),
-			hades.Search{}.OrderBy("position ASC"),
+			pendingDownloadsCond,
+			hades.Search{}.OrderBy("position ASC").Limit(slots.count()),
 		)
-		if len(pendingDownloads) == 0 {
-			return
-		}
-
-		download = pendingDownloads[0]
-		download.Preload(conn)
+		models.PreloadDownloads(conn, pendingDownloads)
 	})
-	if download == nil {
-		return nil
+
+	for _, download := range pendingDownloads {
+		slotCtx, cancel := context.WithCancel(ctx)
+		slot := slots.acquire(download.ID, cancel)
+		if slot < 0 {
+			cancel()
+			continue
+		}
+		consumer.Infof("Performing download for %s in slot %d", operate.GameToString(download.Game), slot)
+
+		slots.add()
+		go func(download *models.Download, slot int) {
+			defer slots.done()
+			defer slots.release(slot)
+			defer cancel()
+
+			err := func() (retErr error) {
+				defer horror.RecoverInto(&retErr)
+				return performOne(slotCtx, rc.Fork(slotCtx), download, slot, slots.count())
+			}()
+			if err != nil {
+				if err == butlerd.CodeNetworkDisconnected {
+					slots.setDisconnected()
+				} else {
+					consumer.Warnf("%+v", errors.WithMessage(err, "while performing download:"))
+				}
+			}
+		}(download, slot)
 	}
-	consumer.Infof("%d pending downloads, performing for %s", len(pendingDownloads), operate.GameToString(download.Game))
+	return nil
+}
+
+func performOne(parentCtx context.Context, rc *butlerd.RequestContext, download *models.Download, slot int, numSlots int) error {
+	consumer := rc.Consumer
 
 	ctx, cancelFunc := context.WithCancel(parentCtx)
 	defer cancelFunc()
 
+	isDiscarded := func() bool {
+		var discarded bool
+		rc.WithConn(func(conn *sqlite.Conn) {
+			models.MustExec(conn,
+				builder.Select("discarded").From("downloads").Where(builder.Eq{"id": download.ID}),
+				func(stmt *sqlite.Stmt) error {
+					discarded = stmt.ColumnInt(0) == 1
+					return nil
+				},
+			)
+		})
+		return discarded
+	}
+
 	wasDiscarded := func() bool {
 		// have we been discarded?
-		{
-			var discarded bool
-			rc.WithConn(func(conn *sqlite.Conn) {
-				models.MustExec(conn,
-					builder.Select("discarded").From("downloads").Where(builder.Eq{"id": download.ID}),
-					func(stmt *sqlite.Stmt) error {
-						discarded = stmt.ColumnInt(0) == 1
-						return nil
-					},
-				)
-			})
-			if discarded {
-				consumer.Infof("Download was cancelled from under us, bailing out!")
-				return true
-			}
+		if isDiscarded() {
+			consumer.Infof("Download was cancelled from under us, bailing out!")
+			return true
 		}
 
-		// has something else been prioritized?
+		// have enough other downloads been prioritized to push us out of the slots?
 		{
-			var priorityDownloadID string
+			var priorityDownloadIDs []string
 			rc.WithConn(func(conn *sqlite.Conn) {
 				models.MustExecWithSearch(conn,
-					builder.Select("id").From("downloads").Where(
-						builder.And(
-							builder.IsNull{"finished_at"},
-							builder.Not{builder.Expr("discarded")},
-						),
-					),
-					hades.Search{}.OrderBy("position ASC").Limit(1),
+					builder.Select("id").From("downloads").Where(pendingDownloadsCond),
+					hades.Search{}.OrderBy("position ASC").Limit(numSlots),
 					func(stmt *sqlite.Stmt) error {
-						priorityDownloadID = stmt.ColumnText(0)
+						priorityDownloadIDs = append(priorityDownloadIDs, stmt.ColumnText(0))
 						return nil
 					},
 				)
 			})
-			if priorityDownloadID != download.ID {
-				consumer.Infof("%s deprioritized (for %s), bailing out!", download.ID, priorityDownloadID)
+			stillPrioritized := false
+			for _, id := range priorityDownloadIDs {
+				if id == download.ID {
+					stillPrioritized = true
+					break
+				}
+			}
+			if !stillPrioritized {
+				consumer.Infof("%s deprioritized (for %v), bailing out!", download.ID, priorityDownloadIDs)
 				return true
 			}
 		}
@@ -258,6 +277,13 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 	}
 	go goGadgetoDiscardWatcher()
 
+	recorder := newDownloadRecorder(time.Now())
+	recorder.begin(rc, download)
+	var outcome butlerd.DownloadOutcome
+	defer func() {
+		recorder.end(rc, download, outcome)
+	}()
+
 	var stage = "prepare"
 	var progress, eta, bps float64
 	const maxSpeedDatapoints = 60
@@ -285,6 +311,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 				BPS:      bps,
 			},
 			SpeedHistory: speedHistory,
+			Slot:         int64(slot),
 		})
 	}
 
@@ -294,6 +321,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 		progress = params.Progress
 		eta = params.ETA
 		bps = params.BPS
+		recorder.sample(progress, bps)
 		return sendProgress()
 	})
 
@@ -301,6 +329,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 	rc.InterceptNotification(messages.TaskStarted.Method(), func(method string, paramsIn interface{}) error {
 		params := paramsIn.(butlerd.TaskStartedNotification)
 		stage = string(params.Type)
+		recorder.startTask(params.Type, params.TotalSize)
 		return sendProgress()
 	})
 
@@ -323,6 +352,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 
 		_ = messages.DownloadsDriveStarted.Notify(rc, butlerd.DownloadsDriveStartedNotification{
 			Download: formatDownload(download),
+			Slot:     int64(slot),
 		})
 
 		_, err = operate.InstallPerform(ctx, rc, butlerd.InstallPerformParams{
@@ -334,6 +364,9 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 	if err != nil {
 		if wasDiscarded() {
 			// download errored, but it was already discarded, ignoring.
+			if isDiscarded() {
+				outcome = butlerd.DownloadOutcomeDiscarded
+			}
 			return nil
 		}
 
@@ -344,9 +377,11 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 				return butlerd.CodeNetworkDisconnected
 			case butlerd.CodeOperationCancelled:
 				// the whole drive was probably cancelled?
+				recorder.cancelled = true
 				return nil
 			case butlerd.CodeOperationAborted:
 				consumer.Warnf("Download aborted, cleaning it out.")
+				outcome = butlerd.DownloadOutcomeAborted
 				rc.WithConn(func(conn *sqlite.Conn) {
 					models.MustDelete(conn, &models.Download{}, builder.Eq{"id": download.ID})
 				})
@@ -364,6 +399,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 				return butlerd.CodeNetworkDisconnected
 			} else if errors.Cause(err) == werrors.ErrCancelled {
 				// just cancelled, nothing to see here
+				recorder.cancelled = true
 				return nil
 			} else {
 				code = int64(jsonrpc2.CodeInternalError)
@@ -380,6 +416,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 		finishedAt := time.Now().UTC()
 		download.FinishedAt = &finishedAt
 		rc.WithConn(download.Save)
+		outcome = butlerd.DownloadOutcomeErrored
 
 		messages.DownloadsDriveErrored.Notify(rc, butlerd.DownloadsDriveErroredNotification{
 			Download: formatDownload(download),
@@ -392,6 +429,7 @@ func performOne(parentCtx context.Context, rc *butlerd.RequestContext) error {
 	finishedAt := time.Now().UTC()
 	download.FinishedAt = &finishedAt
 	rc.WithConn(download.Save)
+	outcome = butlerd.DownloadOutcomeFinished
 
 	messages.DownloadsDriveFinished.Notify(rc, butlerd.DownloadsDriveFinishedNotification{
 		Download: formatDownload(download),
@@ -0,0 +1,116 @@
+package downloads
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/google/uuid"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/horror"
+	"github.com/itchio/butler/database/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	driveLeaseID = "downloads-drive"
+	// a lease that hasn't been renewed in that long is up for grabs
+	driveLeaseTTL = 30 * time.Second
+	// how often the lease is renewed while driving
+	driveLeaseHeartbeatInterval = 5 * time.Second
+)
+
+// holders of drive leases in this process. A lease held by this
+// process but not listed here belonged to a request that died
+// without releasing it, and can be taken over right away.
+var driveLeaseHolders = make(map[string]struct{})
+var driveLeaseHoldersLock sync.Mutex
+
+type driveLease struct {
+	holder        string
+	lastHeartbeat time.Time
+}
+
+func acquireDriveLease(rc *butlerd.RequestContext) (*driveLease, error) {
+	consumer := rc.Consumer
+	holder := uuid.New().String()
+
+	driveLeaseHoldersLock.Lock()
+	defer driveLeaseHoldersLock.Unlock()
+
+	var previous *models.Lease
+	var acquired bool
+	var err error
+	rc.WithConn(func(conn *sqlite.Conn) {
+		if orphan := models.LeaseByID(conn, driveLeaseID); orphan != nil && orphan.PID == int64(os.Getpid()) {
+			if _, ok := driveLeaseHolders[orphan.Holder]; !ok {
+				consumer.Infof("Clearing downloads drive lease left behind by an earlier request")
+				models.Must(models.ReleaseLease(conn, driveLeaseID, orphan.Holder))
+			}
+		}
+
+		previous, acquired, err = models.TryAcquireLease(conn, driveLeaseID, holder, driveLeaseTTL)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !acquired {
+		var heartbeatAge time.Duration
+		if previous.HeartbeatAt != nil {
+			heartbeatAge = time.Since(*previous.HeartbeatAt)
+		}
+		consumer.Warnf("Downloads are already being driven by PID %d (last heartbeat %s ago)", previous.PID, heartbeatAge)
+		return nil, errors.WithStack(butlerd.CodeDownloadsDriveAlreadyRunning)
+	}
+	if previous != nil {
+		consumer.Infof("Took over stale downloads drive lease from PID %d", previous.PID)
+	}
+
+	driveLeaseHolders[holder] = struct{}{}
+	return &driveLease{
+		holder:        holder,
+		lastHeartbeat: time.Now(),
+	}, nil
+}
+
+// heartbeat renews the lease if it's due. It returns false if
+// the lease was lost, in which case we must stop driving.
+func (dl *driveLease) heartbeat(rc *butlerd.RequestContext) (bool, error) {
+	if time.Since(dl.lastHeartbeat) < driveLeaseHeartbeatInterval {
+		return true, nil
+	}
+
+	var renewed bool
+	var err error
+	rc.WithConn(func(conn *sqlite.Conn) {
+		renewed, err = models.RenewLease(conn, driveLeaseID, dl.holder)
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if renewed {
+		dl.lastHeartbeat = time.Now()
+	}
+	return renewed, nil
+}
+
+// release gives up the lease. If the database can't be reached
+// (e.g. because the connection is gone), the lease goes stale instead.
+func (dl *driveLease) release(rc *butlerd.RequestContext) {
+	driveLeaseHoldersLock.Lock()
+	delete(driveLeaseHolders, dl.holder)
+	driveLeaseHoldersLock.Unlock()
+
+	err := func() (retErr error) {
+		defer horror.RecoverInto(&retErr)
+		rc.WithConn(func(conn *sqlite.Conn) {
+			retErr = models.ReleaseLease(conn, driveLeaseID, dl.holder)
+		})
+		return
+	}()
+	if err != nil {
+		rc.Consumer.Warnf("Could not release downloads drive lease: %+v", err)
+	}
+}
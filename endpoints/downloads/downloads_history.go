@@ -0,0 +1,231 @@
+package downloads
+
+import (
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/horror"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/endpoints/fetch/pager"
+	"github.com/itchio/hades"
+	"xorm.io/builder"
+)
+
+func DownloadsHistory(rc *butlerd.RequestContext, params butlerd.DownloadsHistoryParams) (*butlerd.DownloadsHistoryResult, error) {
+	var cond builder.Cond = builder.NewCond()
+	if params.GameID != 0 {
+		cond = cond.And(builder.Eq{"game_id": params.GameID})
+	}
+	if params.CaveID != "" {
+		cond = cond.And(builder.Eq{"cave_id": params.CaveID})
+	}
+	if params.Since != nil {
+		cond = cond.And(models.TimeAfter("started_at", *params.Since))
+	}
+
+	res := &butlerd.DownloadsHistoryResult{
+		Entries: []*butlerd.DownloadHistoryEntry{},
+		Totals:  &butlerd.DownloadHistoryTotals{},
+	}
+	rc.WithConn(func(conn *sqlite.Conn) {
+		var histories []*models.DownloadHistory
+		search := hades.Search{}.OrderBy("started_at DESC")
+		res.NextCursor = pager.New(params).Fetch(conn, &histories, cond, search)
+		models.MustPreload(conn, histories, hades.Assoc("Game"))
+		for _, dh := range histories {
+			res.Entries = append(res.Entries, formatDownloadHistory(dh))
+		}
+
+		totals := res.Totals
+		models.MustExec(conn,
+			builder.Select(
+				"count(*)",
+				"coalesce(sum(bytes), 0)",
+				"coalesce(sum(seconds_elapsed), 0)",
+				"coalesce(sum(outcome = 'errored'), 0)",
+				"coalesce(sum(max(attempts - 1, 0)), 0)",
+			).From("download_histories").Where(cond),
+			func(stmt *sqlite.Stmt) error {
+				totals.Count = stmt.ColumnInt64(0)
+				totals.Bytes = stmt.ColumnInt64(1)
+				totals.Duration = stmt.ColumnFloat(2)
+				totals.Errored = stmt.ColumnInt64(3)
+				totals.Retries = stmt.ColumnInt64(4)
+				return nil
+			},
+		)
+		totals.AverageBPS = averageBPS(totals.Bytes, totals.Duration)
+	})
+	return res, nil
+}
+
+func formatDownloadHistory(dh *models.DownloadHistory) *butlerd.DownloadHistoryEntry {
+	entry := &butlerd.DownloadHistoryEntry{
+		ID:           dh.ID,
+		Reason:       butlerd.DownloadReason(dh.Reason),
+		CaveID:       dh.CaveID,
+		Game:         dh.Game,
+		UploadID:     dh.UploadID,
+		BuildID:      dh.BuildID,
+		StartedAt:    dh.StartedAt,
+		FinishedAt:   dh.FinishedAt,
+		Outcome:      butlerd.DownloadOutcome(dh.Outcome),
+		Bytes:        dh.Bytes,
+		Duration:     dh.SecondsElapsed,
+		AverageBPS:   averageBPS(dh.Bytes, dh.SecondsElapsed),
+		PeakBPS:      dh.PeakBPS,
+		ErrorCode:    dh.ErrorCode,
+		ErrorMessage: dh.ErrorMessage,
+	}
+	if dh.Attempts > 1 {
+		entry.RetryCount = dh.Attempts - 1
+	}
+	return entry
+}
+
+func averageBPS(bytes int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) / seconds
+}
+
+// downloadRecorder measures one attempt at a download, and adds
+// it to the download's history when it ends.
+type downloadRecorder struct {
+	startedAt time.Time
+	// bytes transferred by the tasks this attempt is done with
+	bytes   int64
+	peakBPS float64
+
+	// the task in progress, if it transfers anything
+	task *recordedTask
+	// whether this attempt downloaded the install source, the
+	// install task then reads it from disk
+	downloaded bool
+	// whether this attempt was cancelled, because downloads were paused
+	// or the drive stopped: it's resumed later, that's not a retry
+	cancelled bool
+}
+
+// recordedTask is the progress made on a task during one attempt.
+// Progress doesn't start at 0 when a task resumes from a previous
+// attempt.
+type recordedTask struct {
+	size          int64
+	firstProgress float64
+	lastProgress  float64
+	seenProgress  bool
+}
+
+func (rt *recordedTask) bytes() int64 {
+	if rt.lastProgress <= rt.firstProgress {
+		return 0
+	}
+	return int64((rt.lastProgress - rt.firstProgress) * float64(rt.size))
+}
+
+func newDownloadRecorder(now time.Time) *downloadRecorder {
+	return &downloadRecorder{
+		startedAt: now,
+	}
+}
+
+// startTask accounts for a task starting. Only download tasks and
+// installs streamed from the network transfer bytes, other tasks (and
+// the prepare or verify phases, which aren't tasks) don't count.
+func (dr *downloadRecorder) startTask(typ butlerd.TaskType, totalSize int64) {
+	dr.endTask()
+
+	switch typ {
+	case butlerd.TaskTypeDownload:
+		dr.downloaded = true
+	case butlerd.TaskTypeInstall:
+		if dr.downloaded {
+			return
+		}
+	default:
+		return
+	}
+	if totalSize > 0 {
+		dr.task = &recordedTask{size: totalSize}
+	}
+}
+
+func (dr *downloadRecorder) endTask() {
+	if dr.task != nil {
+		dr.bytes += dr.task.bytes()
+		dr.task = nil
+	}
+}
+
+// sample accounts for the progress (between 0 and 1) of the current
+// task, and the transfer speed reported along with it, which is only
+// used for the peak speed.
+func (dr *downloadRecorder) sample(progress float64, bps float64) {
+	if bps > dr.peakBPS {
+		dr.peakBPS = bps
+	}
+
+	rt := dr.task
+	if rt == nil {
+		return
+	}
+	if !rt.seenProgress {
+		rt.seenProgress = true
+		rt.firstProgress = progress
+	}
+	rt.lastProgress = progress
+}
+
+// fold adds this attempt to a download's history. Outcome is empty
+// if the download may still be attempted again.
+func (dr *downloadRecorder) fold(dh *models.DownloadHistory, download *models.Download, outcome butlerd.DownloadOutcome, now time.Time) {
+	if outcome == butlerd.DownloadOutcomeFinished && dr.task != nil {
+		// progress isn't always reported up to the very end
+		dr.task.seenProgress = true
+		dr.task.lastProgress = 1
+	}
+	dr.endTask()
+
+	if !dr.cancelled {
+		dh.Attempts++
+	}
+	dh.Bytes += dr.bytes
+	dr.bytes = 0
+	dh.SecondsElapsed += now.Sub(dr.startedAt).Seconds()
+	if dr.peakBPS > dh.PeakBPS {
+		dh.PeakBPS = dr.peakBPS
+	}
+	if download.ErrorCode != nil {
+		dh.ErrorCode = download.ErrorCode
+		dh.ErrorMessage = download.ErrorMessage
+	}
+	if outcome != "" {
+		dh.Outcome = string(outcome)
+		finishedAt := now.UTC()
+		dh.FinishedAt = &finishedAt
+	}
+}
+
+func (dr *downloadRecorder) begin(rc *butlerd.RequestContext, download *models.Download) {
+	defer horror.RecoverAndLog(rc.Consumer)
+	rc.WithConn(func(conn *sqlite.Conn) {
+		dh := models.DownloadHistoryFor(conn, download)
+		if dh.StartedAt == nil {
+			startedAt := dr.startedAt.UTC()
+			dh.StartedAt = &startedAt
+		}
+		dh.Save(conn)
+	})
+}
+
+func (dr *downloadRecorder) end(rc *butlerd.RequestContext, download *models.Download, outcome butlerd.DownloadOutcome) {
+	defer horror.RecoverAndLog(rc.Consumer)
+	rc.WithConn(func(conn *sqlite.Conn) {
+		dh := models.DownloadHistoryFor(conn, download)
+		dr.fold(dh, download, outcome, time.Now())
+		dh.Save(conn)
+	})
+}
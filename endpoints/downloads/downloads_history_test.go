@@ -0,0 +1,84 @@
+package downloads
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DownloadRecorder(t *testing.T) {
+	start := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+	download := &models.Download{ID: "dl"}
+	dh := &models.DownloadHistory{ID: "dl"}
+
+	// first attempt downloads the install source, loses connectivity
+	dr := newDownloadRecorder(start)
+	dr.sample(0, 0)
+	dr.startTask(butlerd.TaskTypeDownload, 10000)
+	dr.sample(0.1, 1000)
+	dr.sample(0.2, 3000)
+	dr.sample(0.2, 0)
+	dr.fold(dh, download, "", start.Add(4*time.Second))
+	require.EqualValues(t, 1000, dh.Bytes)
+	require.EqualValues(t, 4, dh.SecondsElapsed)
+	require.EqualValues(t, 3000, dh.PeakBPS)
+	require.Empty(t, dh.Outcome)
+	require.Nil(t, dh.FinishedAt)
+
+	// second attempt resumes, and gets paused
+	start = start.Add(time.Minute)
+	dr = newDownloadRecorder(start)
+	dr.startTask(butlerd.TaskTypeDownload, 10000)
+	dr.sample(0.2, 0)
+	dr.sample(0.3, 1000)
+	dr.cancelled = true
+	dr.fold(dh, download, "", start.Add(time.Second))
+	require.EqualValues(t, 1, dh.Attempts)
+	require.EqualValues(t, 2000, dh.Bytes)
+
+	// third attempt resumes, extracts from disk, then errors out
+	code := int64(butlerd.CodeNoCompatibleUploads)
+	msg := "no compatible uploads"
+	download.ErrorCode = &code
+	download.ErrorMessage = &msg
+	start = start.Add(time.Minute)
+	dr = newDownloadRecorder(start)
+	dr.startTask(butlerd.TaskTypeDownload, 10000)
+	dr.sample(0.5, 500)
+	dr.sample(0.9, 500)
+	dr.startTask(butlerd.TaskTypeInstall, 10000)
+	dr.sample(0.5, 2000000)
+	dr.fold(dh, download, butlerd.DownloadOutcomeErrored, start.Add(2*time.Second))
+	require.EqualValues(t, 2, dh.Attempts)
+	require.EqualValues(t, 6000, dh.Bytes)
+	require.EqualValues(t, 7, dh.SecondsElapsed)
+	require.EqualValues(t, 2000000, dh.PeakBPS)
+	require.EqualValues(t, butlerd.DownloadOutcomeErrored, dh.Outcome)
+	require.NotNil(t, dh.FinishedAt)
+
+	entry := formatDownloadHistory(dh)
+	require.EqualValues(t, 1, entry.RetryCount)
+	require.InDelta(t, 6000.0/7.0, entry.AverageBPS, 0.001)
+	require.Equal(t, &code, entry.ErrorCode)
+}
+
+func Test_DownloadRecorderFinished(t *testing.T) {
+	start := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+	download := &models.Download{ID: "dl"}
+	dh := &models.DownloadHistory{ID: "dl"}
+
+	// installing straight from the network, the last progress
+	// notifications don't always make it
+	dr := newDownloadRecorder(start)
+	dr.startTask(butlerd.TaskTypeInstall, 10000)
+	dr.sample(0, 0)
+	dr.sample(0.75, 1000)
+	dr.fold(dh, download, butlerd.DownloadOutcomeFinished, start.Add(10*time.Second))
+	require.EqualValues(t, 10000, dh.Bytes)
+	require.EqualValues(t, 1000, dh.PeakBPS)
+	require.EqualValues(t, butlerd.DownloadOutcomeFinished, dh.Outcome)
+	require.EqualValues(t, 1, dh.Attempts)
+}
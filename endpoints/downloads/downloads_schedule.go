@@ -0,0 +1,213 @@
+package downloads
+
+import (
+	"strconv"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/bandwidth"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/messages"
+	"github.com/itchio/butler/database/models"
+	"github.com/pkg/errors"
+)
+
+func DownloadsScheduleGet(rc *butlerd.RequestContext, params butlerd.DownloadsScheduleGetParams) (*butlerd.DownloadsScheduleGetResult, error) {
+	schedule, err := loadSchedule(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &butlerd.DownloadsScheduleGetResult{
+		Schedule: schedule,
+	}
+	return res, nil
+}
+
+func DownloadsScheduleSet(rc *butlerd.RequestContext, params butlerd.DownloadsScheduleSetParams) (*butlerd.DownloadsScheduleSetResult, error) {
+	var opErr error
+	rc.WithConn(func(conn *sqlite.Conn) {
+		ds := &models.DownloadSchedule{}
+		err := models.MarshalJSON(params.Schedule, &ds.Schedule, "download schedule")
+		if err != nil {
+			opErr = errors.WithStack(err)
+			return
+		}
+		ds.Save(conn)
+	})
+	if opErr != nil {
+		return nil, opErr
+	}
+
+	rc.Consumer.Statf("Download schedule now has %d windows", len(params.Schedule.Windows))
+
+	res := &butlerd.DownloadsScheduleSetResult{}
+	return res, nil
+}
+
+func loadSchedule(rc *butlerd.RequestContext) (*butlerd.DownloadSchedule, error) {
+	schedule := &butlerd.DownloadSchedule{}
+
+	var ds *models.DownloadSchedule
+	rc.WithConn(func(conn *sqlite.Conn) {
+		ds = models.GetDownloadSchedule(conn)
+	})
+	if ds == nil {
+		return schedule, nil
+	}
+
+	err := models.UnmarshalJSONAllowEmpty(ds.Schedule, schedule, "download schedule")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return schedule, nil
+}
+
+// schedulePolicy is what the download schedule says
+// should happen at a given point in time.
+type schedulePolicy struct {
+	// index of the active window, -1 if none
+	Window int
+	Paused bool
+	// in kbps, 0 means unlimited
+	Rate int64
+}
+
+var noSchedulePolicy = schedulePolicy{Window: -1}
+
+// evaluateSchedule returns the policy in effect at the given local time.
+func evaluateSchedule(schedule *butlerd.DownloadSchedule, now time.Time) schedulePolicy {
+	for i, w := range schedule.Windows {
+		if windowContains(w, now) {
+			return schedulePolicy{
+				Window: i,
+				Paused: w.Paused,
+				Rate:   w.Rate,
+			}
+		}
+	}
+
+	return schedulePolicy{
+		Window: -1,
+		Paused: schedule.PausedOutsideWindows,
+	}
+}
+
+func windowContains(w *butlerd.DownloadScheduleWindow, now time.Time) bool {
+	start, err := parseScheduleTime(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseScheduleTime(w.End)
+	if err != nil {
+		return false
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	day := int64(now.Weekday())
+
+	if start < end {
+		return minute >= start && minute < end && windowAppliesOn(w, day)
+	}
+
+	// spans midnight (or the whole day, if start == end)
+	if minute >= start {
+		return windowAppliesOn(w, day)
+	}
+	if minute < end {
+		return windowAppliesOn(w, (day+6)%7)
+	}
+	return false
+}
+
+func windowAppliesOn(w *butlerd.DownloadScheduleWindow, day int64) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScheduleTime turns `HH:MM` into a number of minutes since midnight
+func parseScheduleTime(s string) (int, error) {
+	if len(s) != 5 || s[2] != ':' {
+		return 0, errors.Errorf("invalid schedule time %q, expected HH:MM", s)
+	}
+	hours, err := strconv.Atoi(s[:2])
+	if err != nil {
+		return 0, errors.Errorf("invalid schedule time %q, expected HH:MM", s)
+	}
+	minutes, err := strconv.Atoi(s[3:])
+	if err != nil {
+		return 0, errors.Errorf("invalid schedule time %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// scheduleWatcher applies the download schedule while driving downloads,
+// and lets the client know whenever the active window changes. A window's
+// rate caps the user's bandwidth throttle (see package bandwidth), it
+// never raises it. Call stop when done driving to lift the cap.
+type scheduleWatcher struct {
+	policy schedulePolicy
+}
+
+func newScheduleWatcher() *scheduleWatcher {
+	return &scheduleWatcher{
+		policy: noSchedulePolicy,
+	}
+}
+
+func (sw *scheduleWatcher) stop() {
+	if sw.policy.Rate > 0 {
+		bandwidth.SetCap(bandwidth.Unlimited)
+	}
+	sw.policy = noSchedulePolicy
+}
+
+func (sw *scheduleWatcher) poll(rc *butlerd.RequestContext) schedulePolicy {
+	consumer := rc.Consumer
+
+	schedule, err := loadSchedule(rc)
+	if err != nil {
+		consumer.Warnf("Could not load download schedule, ignoring it: %+v", err)
+		schedule = &butlerd.DownloadSchedule{}
+	}
+
+	policy := evaluateSchedule(schedule, time.Now())
+	if policy == sw.policy {
+		return policy
+	}
+
+	if policy.Rate != sw.policy.Rate {
+		if policy.Rate > 0 {
+			consumer.Infof("Schedule: capping bandwidth to %d kbps", policy.Rate)
+		} else {
+			consumer.Infof("Schedule: lifting bandwidth cap")
+		}
+		bandwidth.SetCap(policy.Rate)
+	}
+	if policy.Paused != sw.policy.Paused {
+		if policy.Paused {
+			consumer.Infof("Schedule: pausing downloads")
+		} else {
+			consumer.Infof("Schedule: resuming downloads")
+		}
+	}
+	sw.policy = policy
+
+	notif := butlerd.DownloadsDriveScheduleWindowChangedNotification{
+		Paused: policy.Paused,
+		Rate:   policy.Rate,
+	}
+	if policy.Window >= 0 {
+		notif.Window = schedule.Windows[policy.Window]
+	}
+	messages.DownloadsDriveScheduleWindowChanged.Notify(rc, notif)
+
+	return policy
+}
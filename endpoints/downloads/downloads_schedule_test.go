@@ -0,0 +1,77 @@
+package downloads
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/stretchr/testify/require"
+)
+
+// 2024-01-01 was a Monday
+func at(day int, hour int, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+}
+
+func Test_EvaluateScheduleNightOnly(t *testing.T) {
+	schedule := &butlerd.DownloadSchedule{
+		Windows: []*butlerd.DownloadScheduleWindow{
+			{Start: "01:00", End: "07:00"},
+		},
+		PausedOutsideWindows: true,
+	}
+
+	require.Equal(t, schedulePolicy{Window: 0}, evaluateSchedule(schedule, at(1, 1, 0)))
+	require.Equal(t, schedulePolicy{Window: 0}, evaluateSchedule(schedule, at(1, 6, 59)))
+	require.Equal(t, schedulePolicy{Window: -1, Paused: true}, evaluateSchedule(schedule, at(1, 7, 0)))
+	require.Equal(t, schedulePolicy{Window: -1, Paused: true}, evaluateSchedule(schedule, at(1, 0, 59)))
+}
+
+func Test_EvaluateScheduleWorkHours(t *testing.T) {
+	schedule := &butlerd.DownloadSchedule{
+		Windows: []*butlerd.DownloadScheduleWindow{
+			{Start: "09:00", End: "17:00", Days: []int64{1, 2, 3, 4, 5}, Rate: 16000},
+		},
+	}
+
+	// Monday afternoon
+	require.Equal(t, schedulePolicy{Window: 0, Rate: 16000}, evaluateSchedule(schedule, at(1, 14, 30)))
+	// Saturday afternoon
+	require.Equal(t, noSchedulePolicy, evaluateSchedule(schedule, at(6, 14, 30)))
+}
+
+func Test_EvaluateScheduleSpansMidnight(t *testing.T) {
+	schedule := &butlerd.DownloadSchedule{
+		Windows: []*butlerd.DownloadScheduleWindow{
+			{Start: "22:00", End: "02:00", Days: []int64{5}, Paused: true},
+		},
+	}
+
+	// Friday night
+	require.Equal(t, schedulePolicy{Window: 0, Paused: true}, evaluateSchedule(schedule, at(5, 23, 0)))
+	// early Saturday counts as Friday's window
+	require.Equal(t, schedulePolicy{Window: 0, Paused: true}, evaluateSchedule(schedule, at(6, 1, 0)))
+	// early Friday counts as Thursday's window, which doesn't exist
+	require.Equal(t, noSchedulePolicy, evaluateSchedule(schedule, at(5, 1, 0)))
+}
+
+func Test_EvaluateScheduleFirstWindowWins(t *testing.T) {
+	schedule := &butlerd.DownloadSchedule{
+		Windows: []*butlerd.DownloadScheduleWindow{
+			{Start: "12:00", End: "13:00", Paused: true},
+			{Start: "08:00", End: "18:00", Rate: 1000},
+		},
+	}
+
+	require.Equal(t, schedulePolicy{Window: 0, Paused: true}, evaluateSchedule(schedule, at(1, 12, 30)))
+	require.Equal(t, schedulePolicy{Window: 1, Rate: 1000}, evaluateSchedule(schedule, at(1, 10, 0)))
+}
+
+func Test_ParseScheduleTime(t *testing.T) {
+	minutes, err := parseScheduleTime("07:45")
+	require.NoError(t, err)
+	require.Equal(t, 7*60+45, minutes)
+
+	_, err = parseScheduleTime("7:45")
+	require.Error(t, err)
+}
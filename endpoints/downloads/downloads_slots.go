@@ -0,0 +1,110 @@
+package downloads
+
+import (
+	"context"
+	"sync"
+)
+
+// downloadSlots keeps track of which download each slot of
+// Downloads.Drive is currently performing.
+type downloadSlots struct {
+	ids          []string
+	cancels      []context.CancelFunc
+	disconnected bool
+	lock         sync.Mutex
+	wg           sync.WaitGroup
+}
+
+func newDownloadSlots(numSlots int) *downloadSlots {
+	return &downloadSlots{
+		ids:     make([]string, numSlots),
+		cancels: make([]context.CancelFunc, numSlots),
+	}
+}
+
+func (ds *downloadSlots) count() int {
+	return len(ds.ids)
+}
+
+// acquire assigns a free slot to the given download and returns its index,
+// or -1 if the download is already active or all slots are busy.
+// cancel is called if the slot gets interrupted, see cancelAll.
+func (ds *downloadSlots) acquire(downloadID string, cancel context.CancelFunc) int {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	free := -1
+	for i, id := range ds.ids {
+		if id == downloadID {
+			return -1
+		}
+		if id == "" && free == -1 {
+			free = i
+		}
+	}
+	if free != -1 {
+		ds.ids[free] = downloadID
+		ds.cancels[free] = cancel
+	}
+	return free
+}
+
+func (ds *downloadSlots) release(slot int) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.ids[slot] = ""
+	ds.cancels[slot] = nil
+}
+
+// cancelAll interrupts all active slots. Their downloads
+// stay queued and will be resumed later.
+func (ds *downloadSlots) cancelAll() {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	for _, cancel := range ds.cancels {
+		if cancel != nil {
+			cancel()
+		}
+	}
+}
+
+func (ds *downloadSlots) isActive(downloadID string) bool {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	for _, id := range ds.ids {
+		if id == downloadID {
+			return true
+		}
+	}
+	return false
+}
+
+// setDisconnected records that a slot gave up because we're offline.
+func (ds *downloadSlots) setDisconnected() {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.disconnected = true
+}
+
+// takeDisconnected returns true if any slot went offline since
+// the last call, and resets that state.
+func (ds *downloadSlots) takeDisconnected() bool {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	res := ds.disconnected
+	ds.disconnected = false
+	return res
+}
+
+func (ds *downloadSlots) add() {
+	ds.wg.Add(1)
+}
+
+func (ds *downloadSlots) done() {
+	ds.wg.Done()
+}
+
+// wait blocks until all slots are idle.
+func (ds *downloadSlots) wait() {
+	ds.wg.Wait()
+}
@@ -0,0 +1,49 @@
+package downloads
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DownloadSlots(t *testing.T) {
+	slots := newDownloadSlots(2)
+	require.Equal(t, 2, slots.count())
+
+	require.Equal(t, 0, slots.acquire("a", nil))
+	// already active downloads don't get a second slot
+	require.Equal(t, -1, slots.acquire("a", nil))
+	require.Equal(t, 1, slots.acquire("b", nil))
+	// all slots busy
+	require.Equal(t, -1, slots.acquire("c", nil))
+
+	require.True(t, slots.isActive("a"))
+	require.False(t, slots.isActive("c"))
+
+	slots.release(0)
+	require.False(t, slots.isActive("a"))
+	require.Equal(t, 0, slots.acquire("c", nil))
+}
+
+func Test_DownloadSlotsCancelAll(t *testing.T) {
+	slots := newDownloadSlots(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.Equal(t, 0, slots.acquire("a", cancel))
+
+	slots.cancelAll()
+	require.Error(t, ctx.Err())
+	// cancelling doesn't free the slot, the download does when it winds down
+	require.True(t, slots.isActive("a"))
+}
+
+func Test_DownloadSlotsDisconnected(t *testing.T) {
+	slots := newDownloadSlots(1)
+	require.False(t, slots.takeDisconnected())
+
+	slots.setDisconnected()
+	slots.setDisconnected()
+	require.True(t, slots.takeDisconnected())
+	require.False(t, slots.takeDisconnected())
+}
@@ -26,4 +26,5 @@ func Register(router *butlerd.Router) {
 	messages.FetchDownloadKey.Register(router, FetchDownloadKey)
 	messages.FetchDownloadKeys.Register(router, FetchDownloadKeys)
 	messages.FetchGameRecords.Register(router, FetchGameRecords)
+	messages.FetchPlayTime.Register(router, FetchPlayTime)
 }
@@ -0,0 +1,307 @@
+package fetch
+
+import (
+	"sort"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/hades"
+	"xorm.io/builder"
+)
+
+const defaultPlayTimeDays = 28
+
+// reportedPlayTime is a per-game total as last reported by itch.io,
+// stored in caves (and historical play times of caves since uninstalled).
+type reportedPlayTime struct {
+	GameID       int64
+	SecondsRun   int64
+	LastPlayedAt *time.Time
+}
+
+// recordedPlayTime is the sum of the play sessions recorded locally for
+// a game, under a profile.
+type recordedPlayTime struct {
+	GameID       int64
+	ProfileID    int64
+	SecondsRun   int64
+	LastPlayedAt *time.Time
+}
+
+// dailyPlayTime is the sum of the play sessions recorded locally on a
+// day (local time, formatted as 2006-01-02).
+type dailyPlayTime struct {
+	Day        string
+	SecondsRun int64
+}
+
+// unixSecondsSQL converts a time column to (fractional) seconds since
+// the epoch. Times are stored as text, see models.TimeAfter.
+func unixSecondsSQL(column string) string {
+	return "(julianday(" + column + ") - 2440587.5) * 86400.0"
+}
+
+func columnUnixTime(col int, stmt *sqlite.Stmt) *time.Time {
+	if stmt.ColumnType(col) == sqlite.SQLITE_NULL {
+		return nil
+	}
+	t := time.Unix(0, int64(stmt.ColumnFloat(col)*1e9)).Round(time.Millisecond).UTC()
+	return &t
+}
+
+func FetchPlayTime(rc *butlerd.RequestContext, params butlerd.FetchPlayTimeParams) (*butlerd.FetchPlayTimeResult, error) {
+	now := time.Now()
+
+	var reported []reportedPlayTime
+	var recorded []recordedPlayTime
+	var daily []dailyPlayTime
+
+	rc.WithConn(func(conn *sqlite.Conn) {
+		models.MustExecRaw(conn, `
+			SELECT game_id, max(seconds_run), max(`+unixSecondsSQL("last_touched_at")+`)
+			FROM (
+				SELECT game_id, seconds_run, last_touched_at FROM caves
+				UNION ALL
+				SELECT game_id, seconds_run, last_touched_at FROM cave_historical_play_times
+			)
+			GROUP BY game_id
+		`, func(stmt *sqlite.Stmt) error {
+			reported = append(reported, reportedPlayTime{
+				GameID:       stmt.ColumnInt64(0),
+				SecondsRun:   stmt.ColumnInt64(1),
+				LastPlayedAt: columnUnixTime(2, stmt),
+			})
+			return nil
+		})
+
+		// sessions of other profiles tell how much of the reported
+		// totals is accounted for, so they're all needed
+		models.MustExecRaw(conn, `
+			SELECT game_id, profile_id, sum(seconds_run), max(`+unixSecondsSQL("started_at")+` + seconds_run)
+			FROM play_sessions
+			WHERE started_at IS NOT NULL
+			GROUP BY game_id, profile_id
+		`, func(stmt *sqlite.Stmt) error {
+			recorded = append(recorded, recordedPlayTime{
+				GameID:       stmt.ColumnInt64(0),
+				ProfileID:    stmt.ColumnInt64(1),
+				SecondsRun:   stmt.ColumnInt64(2),
+				LastPlayedAt: columnUnixTime(3, stmt),
+			})
+			return nil
+		})
+
+		// sqlite's local time is the same as ours
+		query := `
+			SELECT date(started_at, 'localtime') AS day, sum(seconds_run)
+			FROM play_sessions
+			WHERE julianday(started_at) >= julianday(?)
+		`
+		args := []interface{}{firstPeriod(params, now).UTC().Format(time.RFC3339Nano)}
+		if params.ProfileID != 0 {
+			query += " AND profile_id = ?"
+			args = append(args, params.ProfileID)
+		}
+		query += " GROUP BY day"
+		models.MustExecRaw(conn, query, func(stmt *sqlite.Stmt) error {
+			daily = append(daily, dailyPlayTime{
+				Day:        stmt.ColumnText(0),
+				SecondsRun: stmt.ColumnInt64(1),
+			})
+			return nil
+		}, args...)
+	})
+
+	res := aggregatePlayTime(reported, recorded, daily, params, now)
+
+	var gameIDs []int64
+	for _, gpt := range res.MostPlayed {
+		gameIDs = append(gameIDs, gpt.GameID)
+	}
+	for _, gpt := range res.RecentlyPlayed {
+		gameIDs = append(gameIDs, gpt.GameID)
+	}
+	var games []*itchio.Game
+	rc.WithConn(func(conn *sqlite.Conn) {
+		models.MustSelect(conn, &games, builder.In("id", gameIDs), hades.Search{})
+	})
+	gamesByID := make(map[int64]*itchio.Game)
+	for _, g := range games {
+		gamesByID[g.ID] = g
+	}
+	for _, gpt := range res.MostPlayed {
+		gpt.Game = gamesByID[gpt.GameID]
+	}
+	for _, gpt := range res.RecentlyPlayed {
+		gpt.Game = gamesByID[gpt.GameID]
+	}
+
+	return res, nil
+}
+
+func aggregatePlayTime(reported []reportedPlayTime, recorded []recordedPlayTime, daily []dailyPlayTime, params butlerd.FetchPlayTimeParams, now time.Time) *butlerd.FetchPlayTimeResult {
+	res := &butlerd.FetchPlayTimeResult{
+		MostPlayed:     []*butlerd.GamePlayTime{},
+		RecentlyPlayed: []*butlerd.GamePlayTime{},
+		Periods:        []*butlerd.PeriodPlayTime{},
+		Profiles:       []*butlerd.ProfilePlayTime{},
+	}
+
+	// per game: the largest reported total vs. the sum of local sessions
+	reportedByGame := make(map[int64]*butlerd.GamePlayTime)
+	gameTime := func(m map[int64]*butlerd.GamePlayTime, gameID int64) *butlerd.GamePlayTime {
+		gpt, ok := m[gameID]
+		if !ok {
+			gpt = &butlerd.GamePlayTime{GameID: gameID}
+			m[gameID] = gpt
+		}
+		return gpt
+	}
+
+	for _, r := range reported {
+		gpt := gameTime(reportedByGame, r.GameID)
+		if r.SecondsRun > gpt.SecondsRun {
+			gpt.SecondsRun = r.SecondsRun
+		}
+		gpt.LastPlayedAt = latest(gpt.LastPlayedAt, r.LastPlayedAt)
+	}
+
+	// sessions of the profile asked for, or of all profiles
+	recordedByGame := make(map[int64]int64)
+	secondsByProfile := make(map[int64]int64)
+	profileGames := make(map[int64]*butlerd.GamePlayTime)
+	for _, r := range recorded {
+		recordedByGame[r.GameID] += r.SecondsRun
+		if params.ProfileID == 0 || r.ProfileID == params.ProfileID {
+			secondsByProfile[r.ProfileID] += r.SecondsRun
+			gpt := gameTime(profileGames, r.GameID)
+			gpt.SecondsRun += r.SecondsRun
+			gpt.LastPlayedAt = latest(gpt.LastPlayedAt, r.LastPlayedAt)
+		}
+	}
+
+	// whatever sessions don't account for was played before they
+	// were recorded, or on another computer
+	for gameID, r := range reportedByGame {
+		if r.SecondsRun > recordedByGame[gameID] {
+			res.UnattributedSecondsRun += r.SecondsRun - recordedByGame[gameID]
+		}
+	}
+
+	gamesByID := make(map[int64]*butlerd.GamePlayTime)
+	if params.ProfileID == 0 {
+		gamesByID = reportedByGame
+	}
+	for gameID, s := range profileGames {
+		gpt := gameTime(gamesByID, gameID)
+		if s.SecondsRun > gpt.SecondsRun {
+			gpt.SecondsRun = s.SecondsRun
+		}
+		gpt.LastPlayedAt = latest(gpt.LastPlayedAt, s.LastPlayedAt)
+	}
+
+	var games []*butlerd.GamePlayTime
+	for _, gpt := range gamesByID {
+		if gpt.SecondsRun <= 0 {
+			continue
+		}
+		res.TotalSecondsRun += gpt.SecondsRun
+		games = append(games, gpt)
+	}
+
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].SecondsRun != games[j].SecondsRun {
+			return games[i].SecondsRun > games[j].SecondsRun
+		}
+		return games[i].GameID < games[j].GameID
+	})
+	res.MostPlayed = append(res.MostPlayed, limitPlayTimes(games, params.Limit)...)
+
+	var recent []*butlerd.GamePlayTime
+	for _, gpt := range games {
+		if gpt.LastPlayedAt != nil {
+			recent = append(recent, gpt)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].LastPlayedAt.After(*recent[j].LastPlayedAt)
+	})
+	res.RecentlyPlayed = append(res.RecentlyPlayed, limitPlayTimes(recent, params.Limit)...)
+
+	// per period, local sessions only
+	periodIndex := make(map[time.Time]*butlerd.PeriodPlayTime)
+	for start := firstPeriod(params, now); !start.After(now); start = nextPeriod(params.Period, start) {
+		ppt := &butlerd.PeriodPlayTime{StartsAt: start}
+		periodIndex[start] = ppt
+		res.Periods = append(res.Periods, ppt)
+	}
+	for _, d := range daily {
+		day, err := time.ParseInLocation("2006-01-02", d.Day, now.Location())
+		if err != nil {
+			continue
+		}
+		if ppt, ok := periodIndex[periodStart(params.Period, day)]; ok {
+			ppt.SecondsRun += d.SecondsRun
+		}
+	}
+
+	for profileID, secondsRun := range secondsByProfile {
+		res.Profiles = append(res.Profiles, &butlerd.ProfilePlayTime{
+			ProfileID:  profileID,
+			SecondsRun: secondsRun,
+		})
+	}
+	sort.Slice(res.Profiles, func(i, j int) bool {
+		if res.Profiles[i].SecondsRun != res.Profiles[j].SecondsRun {
+			return res.Profiles[i].SecondsRun > res.Profiles[j].SecondsRun
+		}
+		return res.Profiles[i].ProfileID < res.Profiles[j].ProfileID
+	})
+
+	return res
+}
+
+func latest(a *time.Time, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func limitPlayTimes(gpts []*butlerd.GamePlayTime, limit int64) []*butlerd.GamePlayTime {
+	if limit > 0 && int64(len(gpts)) > limit {
+		return gpts[:limit]
+	}
+	return gpts
+}
+
+// firstPeriod returns the start of the oldest period asked for
+func firstPeriod(params butlerd.FetchPlayTimeParams, now time.Time) time.Time {
+	days := params.Days
+	if days <= 0 {
+		days = defaultPlayTimeDays
+	}
+	return periodStart(params.Period, now.AddDate(0, 0, -int(days-1)))
+}
+
+// periodStart returns local midnight at the start of the day
+// (or week, starting on Monday) t falls in.
+func periodStart(period butlerd.PlayTimePeriod, t time.Time) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == butlerd.PlayTimePeriodWeek {
+		// Sunday is 0, make it 6
+		sinceMonday := (int(start.Weekday()) + 6) % 7
+		start = start.AddDate(0, 0, -sinceMonday)
+	}
+	return start
+}
+
+func nextPeriod(period butlerd.PlayTimePeriod, start time.Time) time.Time {
+	if period == butlerd.PlayTimePeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
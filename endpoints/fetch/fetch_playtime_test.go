@@ -0,0 +1,111 @@
+package fetch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/stretchr/testify/require"
+)
+
+func playTimeGameIDs(gpts []*butlerd.GamePlayTime) []int64 {
+	var ids []int64
+	for _, gpt := range gpts {
+		ids = append(ids, gpt.GameID)
+	}
+	return ids
+}
+
+func Test_AggregatePlayTimeGames(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+	longAgo := now.AddDate(-1, 0, 0)
+	yesterday := now.AddDate(0, 0, -1)
+	lastHour := now.Add(-time.Hour)
+
+	reported := []reportedPlayTime{
+		{GameID: 1, SecondsRun: 3600, LastPlayedAt: &longAgo},
+		{GameID: 2, SecondsRun: 600},
+		// installed, never played
+		{GameID: 3},
+	}
+	recorded := []recordedPlayTime{
+		// played offline, not reported yet: more than reported total
+		{GameID: 2, ProfileID: 1, SecondsRun: 1000, LastPlayedAt: &yesterday},
+		{GameID: 4, ProfileID: 2, SecondsRun: 60, LastPlayedAt: &lastHour},
+	}
+
+	res := aggregatePlayTime(reported, recorded, nil, butlerd.FetchPlayTimeParams{}, now)
+	require.EqualValues(t, 3600+1000+60, res.TotalSecondsRun)
+	require.Equal(t, []int64{1, 2, 4}, playTimeGameIDs(res.MostPlayed))
+	require.EqualValues(t, 1000, res.MostPlayed[1].SecondsRun)
+	require.Equal(t, []int64{4, 2, 1}, playTimeGameIDs(res.RecentlyPlayed))
+
+	// game 1 was only played before sessions were recorded
+	require.EqualValues(t, 3600, res.UnattributedSecondsRun)
+
+	require.Len(t, res.Profiles, 2)
+	require.Equal(t, butlerd.ProfilePlayTime{ProfileID: 1, SecondsRun: 1000}, *res.Profiles[0])
+	require.Equal(t, butlerd.ProfilePlayTime{ProfileID: 2, SecondsRun: 60}, *res.Profiles[1])
+
+	res = aggregatePlayTime(reported, recorded, nil, butlerd.FetchPlayTimeParams{ProfileID: 2}, now)
+	require.EqualValues(t, 60, res.TotalSecondsRun)
+	require.Equal(t, []int64{4}, playTimeGameIDs(res.MostPlayed))
+	require.EqualValues(t, 3600, res.UnattributedSecondsRun, "reported totals aren't dropped")
+	require.Len(t, res.Profiles, 1)
+	require.Equal(t, butlerd.ProfilePlayTime{ProfileID: 2, SecondsRun: 60}, *res.Profiles[0])
+
+	res = aggregatePlayTime(reported, recorded, nil, butlerd.FetchPlayTimeParams{Limit: 1}, now)
+	require.Equal(t, []int64{1}, playTimeGameIDs(res.MostPlayed))
+	require.Equal(t, []int64{4}, playTimeGameIDs(res.RecentlyPlayed))
+}
+
+func Test_AggregatePlayTimeUnattributed(t *testing.T) {
+	now := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+
+	reported := []reportedPlayTime{{GameID: 1, SecondsRun: 1000}}
+	// sessions of all profiles account for the reported total
+	recorded := []recordedPlayTime{
+		{GameID: 1, ProfileID: 1, SecondsRun: 700},
+		{GameID: 1, ProfileID: 2, SecondsRun: 200},
+	}
+
+	res := aggregatePlayTime(reported, recorded, nil, butlerd.FetchPlayTimeParams{ProfileID: 1}, now)
+	require.EqualValues(t, 700, res.TotalSecondsRun)
+	require.EqualValues(t, 100, res.UnattributedSecondsRun)
+}
+
+func Test_AggregatePlayTimePeriods(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)
+	daily := []dailyPlayTime{
+		{Day: "2024-01-10", SecondsRun: 105},
+		{Day: "2024-01-08", SecondsRun: 20},
+		{Day: "2024-01-07", SecondsRun: 3},
+		// before the first period, in case it's asked for
+		{Day: "2023-12-01", SecondsRun: 1000},
+	}
+
+	res := aggregatePlayTime(nil, nil, daily, butlerd.FetchPlayTimeParams{Days: 3}, now)
+	require.Len(t, res.Periods, 3)
+	require.Equal(t, time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), res.Periods[0].StartsAt)
+	require.EqualValues(t, 20, res.Periods[0].SecondsRun)
+	require.EqualValues(t, 0, res.Periods[1].SecondsRun)
+	require.EqualValues(t, 105, res.Periods[2].SecondsRun)
+
+	res = aggregatePlayTime(nil, nil, daily, butlerd.FetchPlayTimeParams{
+		Period: butlerd.PlayTimePeriodWeek,
+		Days:   14,
+	}, now)
+	require.Len(t, res.Periods, 3)
+	// weeks start on Monday
+	require.Equal(t, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), res.Periods[0].StartsAt)
+	require.EqualValues(t, 0, res.Periods[0].SecondsRun)
+	require.EqualValues(t, 3, res.Periods[1].SecondsRun)
+	require.EqualValues(t, 125, res.Periods[2].SecondsRun)
+
+	require.Equal(t, time.Date(2023, 12, 25, 0, 0, 0, 0, time.UTC), firstPeriod(butlerd.FetchPlayTimeParams{
+		Period: butlerd.PlayTimePeriodWeek,
+		Days:   14,
+	}, now))
+}
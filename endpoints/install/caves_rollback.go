@@ -0,0 +1,93 @@
+package install
+
+import (
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/endpoints/fetch"
+	"github.com/itchio/butler/manager"
+	"github.com/itchio/ox"
+	"github.com/pkg/errors"
+	"xorm.io/builder"
+)
+
+func CavesRollback(rc *butlerd.RequestContext, params butlerd.CavesRollbackParams) (*butlerd.CavesRollbackResult, error) {
+	consumer := rc.Consumer
+
+	var cave *models.Cave
+	var installFolder string
+	var rollbackFolder string
+	var numPending int64
+	rc.WithConn(func(conn *sqlite.Conn) {
+		cave = models.CaveByID(conn, params.CaveID)
+		if cave == nil {
+			return
+		}
+		cave.Preload(conn)
+		installFolder = cave.GetInstallFolder(conn)
+		if il := cave.GetInstallLocation(conn); il != nil {
+			rollbackFolder = il.GetRollbackFolder(cave.ID)
+		}
+		numPending = models.MustCount(conn, &models.Download{}, builder.And(
+			builder.Eq{"cave_id": cave.ID},
+			builder.IsNull{"finished_at"},
+		))
+	})
+	if cave == nil {
+		return nil, errors.Errorf("cave (%s) not found", params.CaveID)
+	}
+	if numPending > 0 {
+		return nil, errors.Errorf("cave (%s) has a download in progress, refusing to roll back", cave.ID)
+	}
+	if rollbackFolder == "" {
+		return nil, errors.WithStack(butlerd.CodeNoRollbackSnapshot)
+	}
+
+	snapshots, err := operate.ListRollbackSnapshots(rollbackFolder)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot *operate.RollbackSnapshot
+	for _, s := range snapshots {
+		if params.BuildID == 0 || s.BuildID == params.BuildID {
+			snapshot = s
+			break
+		}
+	}
+	if snapshot == nil {
+		return nil, errors.WithStack(butlerd.CodeNoRollbackSnapshot)
+	}
+
+	receipt, err := operate.RestoreRollbackSnapshot(consumer, snapshot, installFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict, err := manager.Configure(consumer, installFolder, ox.CurrentRuntime())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	consumer.Opf("Saving cave...")
+	cave.SetVerdict(verdict)
+	cave.InstalledSize = verdict.TotalSize
+	if receipt.Upload != nil {
+		cave.Upload = receipt.Upload
+		cave.UploadID = receipt.Upload.ID
+	}
+	if receipt.Build != nil {
+		cave.Build = receipt.Build
+		cave.BuildID = receipt.Build.ID
+	}
+	// otherwise, the update we just undid would be offered (or applied) again
+	cave.Pinned = true
+	cave.UpdateInstallTime()
+
+	res := &butlerd.CavesRollbackResult{}
+	rc.WithConn(func(conn *sqlite.Conn) {
+		cave.SaveWithAssocs(conn)
+		res.Cave = fetch.FormatCave(conn, cave)
+	})
+	return res, nil
+}
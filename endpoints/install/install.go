@@ -19,10 +19,12 @@ func Register(router *butlerd.Router) {
 	messages.InstallLocationsList.Register(router, InstallLocationsList)
 	messages.InstallLocationsAdd.Register(router, InstallLocationsAdd)
 	messages.InstallLocationsRemove.Register(router, InstallLocationsRemove)
+	messages.InstallLocationsSetRollbackRetention.Register(router, InstallLocationsSetRollbackRetention)
 	messages.InstallLocationsScan.Register(router, InstallLocationsScan)
 	messages.InstallCreateShortcut.Register(router, InstallCreateShortcut)
 
 	messages.CavesGetSettings.Register(router, CavesGetSettings)
 	messages.CavesSetSettings.Register(router, CavesSetSettings)
 	messages.CavesSetPinned.Register(router, CavesSetPinned)
+	messages.CavesRollback.Register(router, CavesRollback)
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/cmd/operate"
 	"github.com/itchio/butler/database/models"
 	"github.com/itchio/butler/endpoints/fetch"
 	"github.com/itchio/hades"
@@ -148,3 +149,30 @@ func InstallLocationsRemove(rc *butlerd.RequestContext, params butlerd.InstallLo
 	res := &butlerd.InstallLocationsRemoveResult{}
 	return res, nil
 }
+
+func InstallLocationsSetRollbackRetention(rc *butlerd.RequestContext, params butlerd.InstallLocationsSetRollbackRetentionParams) (*butlerd.InstallLocationsSetRollbackRetentionResult, error) {
+	conn := rc.GetConn()
+	defer rc.PutConn(conn)
+	consumer := rc.Consumer
+
+	il := models.InstallLocationByID(conn, params.ID)
+	if il == nil {
+		return nil, errors.Errorf("install location (%s) not found", params.ID)
+	}
+
+	il.RollbackKeep = params.Keep
+	il.RollbackMaxSize = params.MaxSize
+	models.MustSave(conn, il)
+
+	for _, cave := range il.GetCaves(conn) {
+		err := operate.PruneRollbackSnapshots(consumer, il.GetRollbackFolder(cave.ID), params.Keep)
+		if err != nil {
+			consumer.Warnf("Could not prune rollback snapshots for cave (%s): %+v", cave.ID, err)
+		}
+	}
+
+	res := &butlerd.InstallLocationsSetRollbackRetentionResult{
+		InstallLocation: fetch.FormatInstallLocation(conn, consumer, il),
+	}
+	return res, nil
+}
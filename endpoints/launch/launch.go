@@ -169,6 +169,13 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 		sessionCtx, sessionCancel := context.WithCancel(rc.Ctx)
 		defer sessionCancel()
 
+		playSession := newPlaySessionRecorder(rc, cave, access.ProfileID)
+
+		// the session watcher can outlive the request (when the game crashes,
+		// the final session update happens after we return), so it gets a
+		// context that isn't cancelled along with rc.Ctx
+		watcherRC := rc.Fork(context.WithoutCancel(rc.Ctx))
+
 		sessionWatcher := func() {
 			defer close(sessionWatcherDone)
 			defer horror.RecoverAndLog(consumer)
@@ -177,17 +184,17 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 			sessionStartedAt := time.Now().UTC()
 			var secondsRun int64 = 0
 
-			conn := rc.GetConn()
-			defer rc.PutConn(conn)
+			conn := watcherRC.GetConn()
+			defer watcherRC.PutConn(conn)
 			access := operate.AccessForGameID(conn, cave.GameID)
-			client := rc.Client(access.APIKey)
+			client := watcherRC.Client(access.APIKey)
 
 			var session *itchio.UserGameSession
 
 			createSession := func() (retErr error) {
 				defer horror.RecoverInto(&retErr)
 
-				res, err := client.CreateUserGameSession(rc.Ctx, itchio.CreateUserGameSessionParams{
+				res, err := client.CreateUserGameSession(watcherRC.Ctx, itchio.CreateUserGameSessionParams{
 					GameID:       cave.GameID,
 					UploadID:     cave.UploadID,
 					BuildID:      cave.BuildID,
@@ -204,7 +211,7 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 				session = res.UserGameSession
 
 				cave.UpdateInteractions(res.Summary)
-				rc.WithConn(cave.Save)
+				watcherRC.WithConn(cave.Save)
 
 				return
 			}
@@ -214,7 +221,7 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 
 				lastRunAt = time.Now().UTC()
 				secondsRun = int64(lastRunAt.Sub(sessionStartedAt).Seconds())
-				res, err := client.UpdateUserGameSession(rc.Ctx, itchio.UpdateUserGameSessionParams{
+				res, err := client.UpdateUserGameSession(watcherRC.Ctx, itchio.UpdateUserGameSessionParams{
 					SessionID: session.ID,
 
 					SecondsRun: secondsRun,
@@ -227,7 +234,7 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 				session = res.UserGameSession
 
 				cave.UpdateInteractions(res.Summary)
-				rc.WithConn(cave.Save)
+				watcherRC.WithConn(cave.Save)
 
 				return
 			}
@@ -302,12 +309,14 @@ func Launch(rc *butlerd.RequestContext, params butlerd.LaunchParams) (*butlerd.L
 			SessionStarted: func() {
 				startSessionOnce.Do(func() {
 					close(sessionStartedChan)
+					playSession.start()
 				})
 			},
 		}
 
 		err = launcher.Do(launcherParams)
 		close(sessionEndedChan)
+		playSession.finish()
 		if err != nil {
 			crashed = true
 			return err
@@ -0,0 +1,65 @@
+package launch
+
+import (
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/google/uuid"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/horror"
+	"github.com/itchio/butler/database/models"
+)
+
+// playSessionRecorder keeps a local record of a launch, for play time
+// statistics. It doesn't depend on itch.io being reachable, so offline
+// play counts too.
+type playSessionRecorder struct {
+	rc      *butlerd.RequestContext
+	session *models.PlaySession
+	lock    sync.Mutex
+}
+
+func newPlaySessionRecorder(rc *butlerd.RequestContext, cave *models.Cave, profileID int64) *playSessionRecorder {
+	return &playSessionRecorder{
+		rc: rc,
+		session: &models.PlaySession{
+			ID:        uuid.New().String(),
+			CaveID:    cave.ID,
+			GameID:    cave.GameID,
+			ProfileID: profileID,
+		},
+	}
+}
+
+// start marks the beginning of play. Only the first call counts.
+func (psr *playSessionRecorder) start() {
+	psr.lock.Lock()
+	defer psr.lock.Unlock()
+
+	if psr.session.StartedAt != nil {
+		return
+	}
+	startedAt := time.Now().UTC()
+	psr.session.StartedAt = &startedAt
+	psr.save()
+}
+
+// finish records how long the game ran, if it ever started.
+func (psr *playSessionRecorder) finish() {
+	psr.lock.Lock()
+	defer psr.lock.Unlock()
+
+	if psr.session.StartedAt == nil {
+		return
+	}
+	psr.session.SecondsRun = int64(time.Since(*psr.session.StartedAt).Seconds())
+	psr.save()
+}
+
+func (psr *playSessionRecorder) save() {
+	defer horror.RecoverAndLog(psr.rc.Consumer)
+	psr.rc.WithConn(func(conn *sqlite.Conn) {
+		psr.session.Save(conn)
+	})
+}
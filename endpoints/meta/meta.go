@@ -47,4 +47,22 @@ func Register(router *butlerd.Router) {
 		rc.Shutdown()
 		return &butlerd.MetaShutdownResult{}, nil
 	})
+	messages.MetaSubscribe.Register(router, func(rc *butlerd.RequestContext, params butlerd.MetaSubscribeParams) (*butlerd.MetaSubscribeResult, error) {
+		rc.Subscriptions.Set(rc.Conn, params.Topics)
+		return &butlerd.MetaSubscribeResult{
+			Topics: rc.Subscriptions.Topics(rc.Conn),
+		}, nil
+	})
+	messages.MetaInspect.Register(router, func(rc *butlerd.RequestContext, params butlerd.MetaInspectParams) (*butlerd.MetaInspectResult, error) {
+		return router.Inspect(), nil
+	})
+	messages.MetaCancel.Register(router, func(rc *butlerd.RequestContext, params butlerd.MetaCancelParams) (*butlerd.MetaCancelResult, error) {
+		didCancel := router.CancelInFlight(params.ID)
+		if didCancel {
+			rc.Consumer.Infof("Cancelled %s", params.ID)
+		}
+		return &butlerd.MetaCancelResult{
+			DidCancel: didCancel,
+		}, nil
+	})
 }
@@ -23,6 +23,17 @@ func SearchLocal(rc *butlerd.RequestContext, params butlerd.SearchLocalParams) (
 		return res, nil
 	}
 
+	if params.Ranked {
+		var err error
+		rc.WithConn(func(conn *sqlite.Conn) {
+			err = searchLocalRanked(conn, params, res)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
 	rc.WithConn(func(conn *sqlite.Conn) {
 		res.Games = searchLocalGames(conn, params.Query)
 		res.Bundles = searchLocalBundles(conn, params.ProfileID, params.Query)
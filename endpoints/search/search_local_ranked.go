@@ -0,0 +1,80 @@
+package search
+
+import (
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/endpoints/fetch/pager"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/hades"
+	"xorm.io/builder"
+)
+
+// searchLocalRanked fills res.Items with a page of full-text matches,
+// in the order the library search index ranks them.
+func searchLocalRanked(conn *sqlite.Conn, params butlerd.SearchLocalParams, res *butlerd.SearchLocalResult) error {
+	cur := &pager.CursorInfo{}
+	cur.Decode(params.GetCursor())
+	limit := params.GetLimit()
+
+	hits, err := models.SearchLibrary(conn, params.Query, params.ProfileID, limit+1, cur.Offset)
+	if err != nil {
+		return err
+	}
+	if int64(len(hits)) > limit {
+		hits = hits[:limit]
+		next := &pager.CursorInfo{Offset: cur.Offset + limit}
+		res.NextCursor = next.Encode()
+	}
+
+	var gameIDs, bundleIDs, collectionIDs []int64
+	for _, hit := range hits {
+		switch hit.Kind {
+		case models.LibrarySearchKindGame:
+			gameIDs = append(gameIDs, hit.ID)
+		case models.LibrarySearchKindBundle:
+			bundleIDs = append(bundleIDs, hit.ID)
+		case models.LibrarySearchKindCollection:
+			collectionIDs = append(collectionIDs, hit.ID)
+		}
+	}
+
+	var games []*itchio.Game
+	models.MustSelect(conn, &games, builder.In("id", gameIDs), hades.Search{})
+	gamesByID := make(map[int64]*itchio.Game)
+	for _, g := range games {
+		gamesByID[g.ID] = g
+	}
+
+	var bundles []*itchio.Bundle
+	models.MustSelect(conn, &bundles, builder.In("id", bundleIDs), hades.Search{})
+	bundlesByID := make(map[int64]*itchio.Bundle)
+	for _, b := range bundles {
+		bundlesByID[b.ID] = b
+	}
+
+	var collections []*itchio.Collection
+	models.MustSelect(conn, &collections, builder.In("id", collectionIDs), hades.Search{})
+	collectionsByID := make(map[int64]*itchio.Collection)
+	for _, c := range collections {
+		collectionsByID[c.ID] = c
+	}
+
+	res.Items = []*butlerd.SearchLocalItem{}
+	for _, hit := range hits {
+		item := &butlerd.SearchLocalItem{}
+		switch hit.Kind {
+		case models.LibrarySearchKindGame:
+			item.Game = gamesByID[hit.ID]
+		case models.LibrarySearchKindBundle:
+			item.Bundle = bundlesByID[hit.ID]
+		case models.LibrarySearchKindCollection:
+			item.Collection = collectionsByID[hit.ID]
+		}
+		if item.Game == nil && item.Bundle == nil && item.Collection == nil {
+			continue
+		}
+		res.Items = append(res.Items, item)
+	}
+	return nil
+}
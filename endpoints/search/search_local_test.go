@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
 	"github.com/itchio/butler/database/models"
 	itchio "github.com/itchio/go-itchio"
 	"github.com/stretchr/testify/require"
@@ -125,3 +126,47 @@ func Test_SearchLocalCollectionsScopedToProfile(t *testing.T) {
 	require.Empty(t, searchLocalCollections(conn, 3, "cozy"))
 	require.Empty(t, searchLocalCollections(conn, 1, "foreign"))
 }
+
+func searchLocalItemKey(item *butlerd.SearchLocalItem) string {
+	switch {
+	case item.Game != nil:
+		return fmt.Sprintf("game-%d", item.Game.ID)
+	case item.Bundle != nil:
+		return fmt.Sprintf("bundle-%d", item.Bundle.ID)
+	default:
+		return fmt.Sprintf("collection-%d", item.Collection.ID)
+	}
+}
+
+func Test_SearchLocalRanked(t *testing.T) {
+	conn := searchLocalTestConn(t)
+	require.NoError(t, models.PrepareLibrarySearch(conn))
+	seedSearchLocal(t, conn)
+	models.MustSave(conn, &itchio.Game{ID: 3, Title: "Mountain Climb", ShortText: "A cozy hike"})
+
+	params := butlerd.SearchLocalParams{
+		ProfileID: 1,
+		Query:     "cozy",
+		Ranked:    true,
+		Limit:     2,
+	}
+
+	var keys []string
+	for {
+		res := &butlerd.SearchLocalResult{}
+		require.NoError(t, searchLocalRanked(conn, params, res))
+		require.True(t, len(res.Items) <= 2)
+		for _, item := range res.Items {
+			keys = append(keys, searchLocalItemKey(item))
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		params.Cursor = res.NextCursor
+	}
+
+	// title matches first, short text matches last, nothing from profile 2
+	require.Len(t, keys, 4)
+	require.ElementsMatch(t, []string{"game-1", "bundle-10", "collection-30"}, keys[:3])
+	require.Equal(t, "game-3", keys[3])
+}
@@ -0,0 +1,142 @@
+package update
+
+import (
+	"time"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/butlerd/horror"
+	"github.com/itchio/butler/cmd/operate/memorylogger"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/endpoints/install"
+	"github.com/itchio/hades"
+	"github.com/itchio/headway/state"
+	"xorm.io/builder"
+)
+
+const (
+	autoUpdateFirstDelay = 5 * time.Minute
+	autoUpdateInterval   = 6 * time.Hour
+)
+
+// AutoUpdate periodically checks caves whose settings opt into
+// `download-and-apply`, and queues their updates as downloads.
+// It runs until the router shuts down.
+func AutoUpdate() butlerd.BackgroundTask {
+	return butlerd.BackgroundTask{
+		Desc: "auto-update opted-in caves",
+		Do: func(rc *butlerd.RequestContext) error {
+			delay := autoUpdateFirstDelay
+			for {
+				select {
+				case <-time.After(delay):
+					autoUpdateOnce(rc)
+				case <-rc.Ctx.Done():
+					return nil
+				}
+				delay = autoUpdateInterval
+			}
+		},
+	}
+}
+
+func autoUpdateOnce(rc *butlerd.RequestContext) {
+	consumer := rc.Consumer
+	defer horror.RecoverAndLog(consumer)
+
+	var caves []*models.Cave
+	rc.WithConn(func(conn *sqlite.Conn) {
+		models.MustSelect(conn, &caves, builder.Eq{"pinned": false}, hades.Search{})
+		models.PreloadCaves(conn, caves)
+	})
+
+	updateParams := checkUpdateCaveParams{
+		rc: rc,
+	}
+
+	var numQueued int
+	for _, cave := range caves {
+		if rc.Ctx.Err() != nil {
+			return
+		}
+		if autoUpdatePolicy(consumer, cave) != butlerd.AutoUpdatePolicyDownloadAndApply {
+			continue
+		}
+		if hasPendingDownload(rc, cave) {
+			consumer.Debugf("Cave (%s) already has a pending download, skipping auto-update", cave.ID)
+			continue
+		}
+
+		ml := memorylogger.New()
+		update, err := checkUpdateCave(updateParams, ml.Consumer(), cave)
+		if err != nil {
+			consumer.Warnf("Auto-update check failed for cave (%s): %+v", cave.ID, err)
+			consumer.Warnf("Log follows ====================")
+			ml.Copy(consumer)
+			consumer.Warnf("Log ends here ==================")
+			continue
+		}
+
+		choice := pickAutoUpdateChoice(update)
+		if choice == nil {
+			if update != nil {
+				consumer.Infof("Update for cave (%s) is ambiguous, leaving it to the user", cave.ID)
+			}
+			continue
+		}
+
+		_, err = install.InstallQueue(rc, butlerd.InstallQueueParams{
+			CaveID:        cave.ID,
+			Reason:        butlerd.DownloadReasonUpdate,
+			Upload:        choice.Upload,
+			Build:         choice.Build,
+			QueueDownload: true,
+		})
+		if err != nil {
+			consumer.Warnf("Could not queue auto-update for cave (%s): %+v", cave.ID, err)
+			continue
+		}
+		numQueued++
+	}
+
+	if numQueued > 0 {
+		consumer.Infof("Queued %d auto-updates", numQueued)
+	}
+}
+
+// autoUpdatePolicy returns the update policy from a cave's settings,
+// defaulting to notify.
+func autoUpdatePolicy(consumer *state.Consumer, cave *models.Cave) butlerd.AutoUpdatePolicy {
+	settings := caveSettings(consumer, cave)
+	if settings.AutoUpdate == "" {
+		return butlerd.AutoUpdatePolicyNotify
+	}
+	return settings.AutoUpdate
+}
+
+// pickAutoUpdateChoice returns the choice to apply without asking,
+// or nil if a human should pick: only direct (same channel) updates
+// and lone, confidently-matched uploads qualify.
+func pickAutoUpdateChoice(update *butlerd.GameUpdate) *butlerd.GameUpdateChoice {
+	if update == nil || len(update.Choices) == 0 {
+		return nil
+	}
+	if update.Direct {
+		return update.Choices[0]
+	}
+	if len(update.Choices) == 1 && update.Choices[0].Confidence >= 1 {
+		return update.Choices[0]
+	}
+	return nil
+}
+
+func hasPendingDownload(rc *butlerd.RequestContext, cave *models.Cave) bool {
+	var pending bool
+	rc.WithConn(func(conn *sqlite.Conn) {
+		pending = models.MustCount(conn, &models.Download{}, builder.And(
+			builder.Eq{"cave_id": cave.ID},
+			builder.IsNull{"finished_at"},
+		)) > 0
+	})
+	return pending
+}
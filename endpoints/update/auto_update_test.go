@@ -0,0 +1,58 @@
+package update
+
+import (
+	"testing"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AutoUpdatePolicy(t *testing.T) {
+	consumer := &state.Consumer{}
+
+	require.Equal(t, butlerd.AutoUpdatePolicyNotify, autoUpdatePolicy(consumer, &models.Cave{}))
+	require.Equal(t, butlerd.AutoUpdatePolicyNotify, autoUpdatePolicy(consumer, &models.Cave{
+		Settings: `{"launchTarget":"game.exe"}`,
+	}))
+	require.Equal(t, butlerd.AutoUpdatePolicyDownloadAndApply, autoUpdatePolicy(consumer, &models.Cave{
+		Settings: `{"autoUpdate":"download-and-apply"}`,
+	}))
+	// unreadable settings don't opt anything in
+	require.Equal(t, butlerd.AutoUpdatePolicyNotify, autoUpdatePolicy(consumer, &models.Cave{
+		Settings: `{"autoUpdate":`,
+	}))
+}
+
+func Test_PickAutoUpdateChoice(t *testing.T) {
+	choice := func(uploadID int64, confidence float64) *butlerd.GameUpdateChoice {
+		return &butlerd.GameUpdateChoice{
+			Upload:     &itchio.Upload{ID: uploadID},
+			Confidence: confidence,
+		}
+	}
+
+	require.Nil(t, pickAutoUpdateChoice(nil))
+	require.Nil(t, pickAutoUpdateChoice(&butlerd.GameUpdate{}))
+
+	direct := choice(1, 1)
+	require.Equal(t, direct, pickAutoUpdateChoice(&butlerd.GameUpdate{
+		Direct:  true,
+		Choices: []*butlerd.GameUpdateChoice{direct},
+	}))
+
+	exact := choice(2, 1)
+	require.Equal(t, exact, pickAutoUpdateChoice(&butlerd.GameUpdate{
+		Choices: []*butlerd.GameUpdateChoice{exact},
+	}))
+
+	// a fuzzy match, or several candidates, need a human
+	require.Nil(t, pickAutoUpdateChoice(&butlerd.GameUpdate{
+		Choices: []*butlerd.GameUpdateChoice{choice(3, 0.5)},
+	}))
+	require.Nil(t, pickAutoUpdateChoice(&butlerd.GameUpdate{
+		Choices: []*butlerd.GameUpdateChoice{choice(4, 1), choice(5, 1)},
+	}))
+}
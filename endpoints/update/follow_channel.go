@@ -0,0 +1,61 @@
+package update
+
+import (
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/butler/database/models"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/headway/state"
+	"github.com/pkg/errors"
+)
+
+// checkFollowedChannel looks for an update on the wharf channel a cave
+// was told to follow in its settings. The channel's head build is the
+// only candidate: there's no guessing involved, even if it means moving
+// to another upload (e.g. from `linux` to `linux-beta`, or back).
+func checkFollowedChannel(consumer *state.Consumer, cave *models.Cave, uploads []*itchio.Upload, channelName string) (*butlerd.GameUpdate, error) {
+	consumer.Infof("Following channel (%s)", channelName)
+
+	var channelUpload *itchio.Upload
+	for _, u := range uploads {
+		if u.ChannelName == channelName {
+			channelUpload = u
+			break
+		}
+	}
+	if channelUpload == nil {
+		return nil, errors.Errorf("Followed channel (%s) not found for %s", channelName, operate.GameToString(cave.Game))
+	}
+	if channelUpload.Build == nil {
+		return nil, errors.Errorf("Followed channel (%s) has no build yet", channelName)
+	}
+
+	res := &butlerd.GameUpdate{
+		CaveID: cave.ID,
+		Game:   cave.Game,
+		Choices: []*butlerd.GameUpdateChoice{
+			{
+				Upload:     channelUpload,
+				Build:      channelUpload.Build,
+				Confidence: 1,
+			},
+		},
+	}
+
+	if channelUpload.ID == cave.UploadID {
+		if channelUpload.Build.ID == cave.BuildID {
+			consumer.Statf("The head build of the followed channel is installed.")
+			return nil, nil
+		}
+		consumer.Statf("↑ Head build (#%d) of the followed channel differs from ours (#%d), it's an update!",
+			channelUpload.Build.ID,
+			cave.BuildID,
+		)
+		res.Direct = true
+		return res, nil
+	}
+
+	consumer.Statf("↑ Switching to followed channel:")
+	operate.LogUpload(consumer, channelUpload, channelUpload.Build)
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package update
+
+import (
+	"testing"
+
+	"github.com/itchio/butler/database/models"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckFollowedChannel(t *testing.T) {
+	consumer := &state.Consumer{}
+	uploads := []*itchio.Upload{
+		{ID: 1, ChannelName: "linux", Build: &itchio.Build{ID: 100}},
+		{ID: 2, ChannelName: "linux-beta", Build: &itchio.Build{ID: 120}},
+		{ID: 3, ChannelName: "windows"},
+	}
+	cave := &models.Cave{ID: "cave", UploadID: 1, BuildID: 100}
+
+	// already on the head build
+	update, err := checkFollowedChannel(consumer, cave, uploads, "linux")
+	require.NoError(t, err)
+	require.Nil(t, update)
+
+	// switching channels is a single, certain choice
+	update, err = checkFollowedChannel(consumer, cave, uploads, "linux-beta")
+	require.NoError(t, err)
+	require.NotNil(t, update)
+	require.False(t, update.Direct)
+	require.Len(t, update.Choices, 1)
+	require.EqualValues(t, 2, update.Choices[0].Upload.ID)
+	require.EqualValues(t, 120, update.Choices[0].Build.ID)
+	require.EqualValues(t, 1, update.Choices[0].Confidence)
+
+	// the head build is followed even if it went backwards
+	cave = &models.Cave{ID: "cave", UploadID: 2, BuildID: 130}
+	update, err = checkFollowedChannel(consumer, cave, uploads, "linux-beta")
+	require.NoError(t, err)
+	require.NotNil(t, update)
+	require.True(t, update.Direct)
+	require.EqualValues(t, 120, update.Choices[0].Build.ID)
+
+	_, err = checkFollowedChannel(consumer, cave, uploads, "mac")
+	require.Error(t, err)
+	_, err = checkFollowedChannel(consumer, cave, uploads, "windows")
+	require.Error(t, err)
+}
@@ -44,6 +44,7 @@ func CheckUpdate(rc *butlerd.RequestContext, params butlerd.CheckUpdateParams) (
 	cond := builder.NewCond()
 	if len(params.CaveIDs) > 0 {
 		updateParams.ignoreSnooze = true
+		updateParams.ignorePolicy = true
 
 		var caveIDs []interface{}
 		for _, cid := range params.CaveIDs {
@@ -163,6 +164,7 @@ func CheckUpdate(rc *butlerd.RequestContext, params butlerd.CheckUpdateParams) (
 
 type checkUpdateCaveParams struct {
 	ignoreSnooze bool
+	ignorePolicy bool
 	rc           *butlerd.RequestContext
 }
 
@@ -174,6 +176,11 @@ func checkUpdateCave(params checkUpdateCaveParams, consumer *state.Consumer, cav
 		return nil, nil
 	}
 
+	if !params.ignorePolicy && autoUpdatePolicy(consumer, cave) == butlerd.AutoUpdatePolicyNever {
+		consumer.Statf("Cave opted out of updates, skipping")
+		return nil, nil
+	}
+
 	var access *operate.GameAccess
 	rc.WithConn(func(conn *sqlite.Conn) {
 		access = operate.AccessForGameID(conn, cave.GameID)
@@ -291,6 +298,10 @@ func checkUpdateCave(params checkUpdateCaveParams, consumer *state.Consumer, cav
 		return nil, errors.WithStack(err)
 	}
 
+	if channelName := caveSettings(consumer, cave).Channel; channelName != "" {
+		return checkFollowedChannel(consumer, cave, listUploadsRes.Uploads, channelName)
+	}
+
 	var currentUpload = cave.Upload
 	var freshUpload *itchio.Upload
 	var newerUploads []*itchio.Upload
@@ -445,6 +456,18 @@ func checkUpdateCave(params checkUpdateCaveParams, consumer *state.Consumer, cav
 	return res, nil
 }
 
+// caveSettings parses a cave's settings, falling back to defaults
+// if they're unreadable.
+func caveSettings(consumer *state.Consumer, cave *models.Cave) butlerd.CaveSettings {
+	var settings butlerd.CaveSettings
+	err := models.UnmarshalJSONAllowEmpty(cave.Settings, &settings, "cave settings")
+	if err != nil {
+		consumer.Warnf("Could not parse cave settings: %v", err)
+		return butlerd.CaveSettings{}
+	}
+	return settings
+}
+
 func moreRecentThan(lhs *time.Time, rhs *time.Time) bool {
 	if lhs == nil {
 		// always less recent if we lack a timestamp
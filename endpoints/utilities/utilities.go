@@ -1,7 +1,7 @@
 package utilities
 
 import (
-	"github.com/efarrer/iothrottler"
+	"github.com/itchio/butler/bandwidth"
 	"github.com/itchio/butler/buildinfo"
 	"github.com/itchio/butler/butlerd"
 	"github.com/itchio/butler/butlerd/messages"
@@ -32,9 +32,9 @@ func Register(router *butlerd.Router) {
 
 	messages.NetworkSetBandwidthThrottle.Register(router, func(rc *butlerd.RequestContext, params butlerd.NetworkSetBandwidthThrottleParams) (*butlerd.NetworkSetBandwidthThrottleResult, error) {
 		if params.Enabled {
-			timeout.ThrottlerPool.SetBandwidth(iothrottler.Bandwidth(params.Rate) * iothrottler.Kbps)
+			bandwidth.SetThrottle(params.Rate)
 		} else {
-			timeout.ThrottlerPool.SetBandwidth(iothrottler.Unlimited)
+			bandwidth.SetThrottle(bandwidth.Unlimited)
 		}
 		res := &butlerd.NetworkSetBandwidthThrottleResult{}
 		return res, nil
@@ -0,0 +1,445 @@
+package filtering
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/itchio/lake/tlc"
+	"github.com/pkg/errors"
+)
+
+// IgnoreFileName is the name of files listing paths to leave out of
+// a build, with the same syntax and semantics as `.gitignore` files:
+// patterns apply to the folder the file is in and everything below it,
+// later patterns win over earlier ones (and deeper files over shallower
+// ones), `!` re-includes, a trailing `/` only matches folders, a
+// leading or inner `/` anchors the pattern, and `**` matches any number
+// of folders.
+const IgnoreFileName = ".butlerignore"
+
+// An IgnoreRule is a single pattern from a .butlerignore file
+type IgnoreRule struct {
+	// Slash-separated path of the file the rule is from, relative to the walked folder
+	Source string
+	// 1-based line number in Source
+	Line int
+	// Pattern as written in Source
+	Pattern string
+
+	negate  bool
+	dirOnly bool
+	// slash-separated folder the pattern is relative to, "" for the root
+	base string
+	re   *regexp.Regexp
+}
+
+func (r *IgnoreRule) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+}
+
+// ignoreFileRule explains why .butlerignore files themselves are left out
+var ignoreFileRule = &IgnoreRule{
+	Source:  "(built-in)",
+	Pattern: IgnoreFileName,
+}
+
+// IgnoreRules are all the rules from .butlerignore files in a folder
+type IgnoreRules struct {
+	rules []*IgnoreRule
+}
+
+// LoadIgnoreRules reads all .butlerignore files in dir and its subfolders.
+// Folders ignored by FilterPaths (version control metadata, etc.) or by
+// the rules read so far aren't searched, their rules couldn't re-include
+// anything anyway.
+func LoadIgnoreRules(dir string) (*IgnoreRules, error) {
+	ir := &IgnoreRules{}
+
+	err := filepath.Walk(dir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+
+		rel := ""
+		if fullPath != dir {
+			if FilterPaths(info.Name()) == tlc.FilterIgnore {
+				return filepath.SkipDir
+			}
+			r, err := filepath.Rel(dir, fullPath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			rel = filepath.ToSlash(r)
+			if ir.Ignored(rel, true) {
+				return filepath.SkipDir
+			}
+		}
+
+		// read it now rather than when walking past it, entries
+		// sorting before it may be folders it ignores
+		ignorePath := filepath.Join(fullPath, IgnoreFileName)
+		if stats, err := os.Stat(ignorePath); err != nil || stats.IsDir() {
+			return nil
+		}
+		rules, err := readIgnoreFile(ignorePath, path.Join(rel, IgnoreFileName))
+		if err != nil {
+			return err
+		}
+		ir.rules = append(ir.rules, rules...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s files", IgnoreFileName)
+	}
+
+	// deeper files take precedence, and rules are applied in order
+	sort.SliceStable(ir.rules, func(i, j int) bool {
+		return depth(ir.rules[i].base) < depth(ir.rules[j].base)
+	})
+	return ir, nil
+}
+
+func readIgnoreFile(fullPath string, source string) ([]*IgnoreRule, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	base := path.Dir(source)
+	if base == "." {
+		base = ""
+	}
+
+	var rules []*IgnoreRule
+	s := bufio.NewScanner(f)
+	lineNumber := 0
+	for s.Scan() {
+		lineNumber++
+		rule, err := ParseIgnoreRule(s.Text(), base)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", source, lineNumber)
+		}
+		if rule == nil {
+			continue
+		}
+		rule.Source = source
+		rule.Line = lineNumber
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return rules, nil
+}
+
+// ParseIgnoreRule parses one line of a .butlerignore file found in the
+// (slash-separated) base folder. It returns nil for blank lines and comments.
+func ParseIgnoreRule(line string, base string) (*IgnoreRule, error) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	rule := &IgnoreRule{
+		Pattern: line,
+		base:    base,
+	}
+
+	pattern := line
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+
+	// a slash anywhere but at the end anchors the pattern to the base folder
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(?:.*/)?"
+	}
+	expr += globToRegexp(pattern) + "$"
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern (%s)", line)
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// globToRegexp translates gitignore-style wildcards to a regular expression
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				atStart := i == 0 || pattern[i-1] == '/'
+				rest := pattern[i+2:]
+				if atStart && rest == "" {
+					// trailing "/**": everything inside
+					sb.WriteString(".*")
+					i++
+					continue
+				}
+				if atStart && strings.HasPrefix(rest, "/") {
+					// "**/": zero or more folders
+					sb.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if end == 0 {
+				// "[]...]" includes a literal ]
+				more := strings.IndexByte(pattern[i+2:], ']')
+				if more < 0 {
+					sb.WriteString(`\[`)
+					continue
+				}
+				class = pattern[i+1 : i+2+more]
+				end = more + 1
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			class = strings.ReplaceAll(class, `\`, `\\`)
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return strings.ReplaceAll(line, `\ `, " ")
+}
+
+func depth(dir string) int {
+	if dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// match returns the last rule matching p (a file, or a folder if isDir),
+// or nil if none do. The rule may be a negation.
+func (ir *IgnoreRules) match(p string, isDir bool) *IgnoreRule {
+	var matched *IgnoreRule
+	for _, rule := range ir.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := p
+		if rule.base != "" {
+			if !strings.HasPrefix(p, rule.base+"/") {
+				continue
+			}
+			rel = p[len(rule.base)+1:]
+		}
+		if rule.re.MatchString(rel) {
+			matched = rule
+		}
+	}
+	return matched
+}
+
+// Explain returns the rule excluding p (a slash-separated path relative to
+// the walked folder), or nil if it's kept. Like git, a path can't be
+// re-included if one of its parent folders is excluded.
+func (ir *IgnoreRules) Explain(p string, isDir bool) *IgnoreRule {
+	if path.Base(p) == IgnoreFileName && !isDir {
+		return ignoreFileRule
+	}
+
+	parts := strings.Split(p, "/")
+	for i := 1; i <= len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		prefixIsDir := isDir || i < len(parts)
+		if rule := ir.match(prefix, prefixIsDir); rule != nil && !rule.negate {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Ignored returns true if p is excluded by a .butlerignore rule
+func (ir *IgnoreRules) Ignored(p string, isDir bool) bool {
+	return ir.Explain(p, isDir) != nil
+}
+
+// Apply removes ignored entries from a container walked from the folder
+// the rules were loaded from, and recomputes file offsets and its size.
+func (ir *IgnoreRules) Apply(container *tlc.Container) {
+	var dirs []*tlc.Dir
+	for _, d := range container.Dirs {
+		if !ir.Ignored(d.Path, true) {
+			dirs = append(dirs, d)
+		}
+	}
+	container.Dirs = dirs
+
+	var symlinks []*tlc.Symlink
+	for _, s := range container.Symlinks {
+		if !ir.Ignored(s.Path, false) {
+			symlinks = append(symlinks, s)
+		}
+	}
+	container.Symlinks = symlinks
+
+	var files []*tlc.File
+	var offset int64
+	for _, f := range container.Files {
+		if ir.Ignored(f.Path, false) {
+			continue
+		}
+		f.Offset = offset
+		offset += f.Size
+		files = append(files, f)
+	}
+	container.Files = files
+	container.Size = offset
+}
+
+// walkDir walks dir (the folder the rules were loaded from) like
+// tlc.WalkDir, leaving out what opts.Filter and the rules exclude. It
+// knows the path of each entry, so excluded folders aren't walked at all.
+// It doesn't dereference symlinks.
+func (ir *IgnoreRules) walkDir(dir string, opts tlc.WalkOpts) (*tlc.Container, error) {
+	filter := opts.GetFilter()
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	root := dir
+	if opts.WrappedDir != "" {
+		root = filepath.Join(dir, opts.WrappedDir)
+	}
+
+	container := &tlc.Container{}
+	err = filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsPermission(err) {
+				// like tlc, skip what we can't read
+				log.Printf("Permission error: %s\n", err.Error())
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		rel, err := filepath.Rel(dir, fullPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		p := filepath.ToSlash(rel)
+		if p == "." {
+			return nil
+		}
+
+		// don't end up with files we (the patcher) can't modify
+		mode := info.Mode() | tlc.ModeMask
+
+		if filter(info.Name()) == tlc.FilterIgnore || ir.Ignored(p, mode.IsDir()) {
+			if mode.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case mode.IsDir():
+			container.Dirs = append(container.Dirs, &tlc.Dir{Path: p, Mode: uint32(mode)})
+		case mode.IsRegular():
+			container.Files = append(container.Files, &tlc.File{Path: p, Mode: uint32(mode), Size: info.Size(), Offset: container.Size})
+			container.Size += info.Size()
+		case mode&os.ModeSymlink != 0:
+			dest, err := os.Readlink(fullPath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			container.Symlinks = append(container.Symlinks, &tlc.Symlink{Path: p, Mode: uint32(mode), Dest: filepath.ToSlash(dest)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// WalkAny is tlc.WalkAny, but when walking a folder, it also leaves out
+// paths excluded by .butlerignore files in it, without walking excluded
+// folders.
+func WalkAny(containerPath string, opts tlc.WalkOpts) (*tlc.Container, error) {
+	stats, err := os.Stat(containerPath)
+	if err != nil || !stats.IsDir() {
+		// archives and single files don't get .butlerignore treatment
+		return tlc.WalkAny(containerPath, opts)
+	}
+
+	ir, err := LoadIgnoreRules(containerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Dereference {
+		return ir.walkDir(containerPath, opts)
+	}
+
+	// dereferenced symlinks are walked from wherever they point to, only
+	// tlc knows how to do that
+	container, err := tlc.WalkAny(containerPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	ir.Apply(container)
+	return container, nil
+}
@@ -0,0 +1,225 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/lake/tlc"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_IgnoreRulePatterns(t *testing.T) {
+	type testCase struct {
+		pattern string
+		base    string
+		path    string
+		isDir   bool
+		match   bool
+	}
+
+	cases := []testCase{
+		{"*.pdb", "", "game.pdb", false, true},
+		{"*.pdb", "", "bin/x64/game.pdb", false, true},
+		{"*.pdb", "", "game.exe", false, false},
+		{"/notes.txt", "", "notes.txt", false, true},
+		{"/notes.txt", "", "docs/notes.txt", false, false},
+		{"docs/*.md", "", "docs/readme.md", false, true},
+		{"docs/*.md", "", "docs/api/readme.md", false, false},
+		{"docs/*.md", "", "other/docs/readme.md", false, false},
+		{"logs/", "", "logs", true, true},
+		{"logs/", "", "logs", false, false},
+		{"logs/", "", "sub/logs", true, true},
+		{"**/cache", "", "cache", true, true},
+		{"**/cache", "", "a/b/cache", false, true},
+		{"assets/**", "", "assets/a/b.png", false, true},
+		{"assets/**", "", "assets", true, false},
+		{"a/**/b", "", "a/b", false, true},
+		{"a/**/b", "", "a/x/y/b", false, true},
+		{"a/**/b", "", "ab", false, false},
+		{"save?.dat", "", "save1.dat", false, true},
+		{"save?.dat", "", "save10.dat", false, false},
+		{"[!a]*.txt", "", "b.txt", false, true},
+		{"[!a]*.txt", "", "a.txt", false, false},
+		{`\#hash`, "", "#hash", false, true},
+		{"*.tmp", "sub", "sub/x.tmp", false, true},
+		{"*.tmp", "sub", "x.tmp", false, false},
+		{"/x.tmp", "sub", "sub/x.tmp", false, true},
+		{"/x.tmp", "sub", "sub/deeper/x.tmp", false, false},
+	}
+
+	for _, c := range cases {
+		rule, err := ParseIgnoreRule(c.pattern, c.base)
+		require.NoError(t, err)
+		require.NotNil(t, rule)
+		ir := &IgnoreRules{rules: []*IgnoreRule{rule}}
+		require.Equal(t, c.match, ir.match(c.path, c.isDir) != nil, "%q (in %q) vs %q", c.pattern, c.base, c.path)
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		rule, err := ParseIgnoreRule(line, "")
+		require.NoError(t, err)
+		require.Nil(t, rule, "%q", line)
+	}
+}
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(contents), 0o644))
+	}
+}
+
+func Test_WalkAnyButlerignore(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".butlerignore":          "*.pdb\n!keep.pdb\nlogs/\n/debug\n",
+		"game.exe":               "exe",
+		"game.pdb":               "pdb",
+		"keep.pdb":               "keep",
+		"logs/today.log":         "log",
+		"debug/trace.txt":        "trace",
+		"data/debug/ok.txt":      "ok",
+		"data/.butlerignore":     "*.bak\n!important.pdb\n",
+		"data/level.bak":         "bak",
+		"data/important.pdb":     "important",
+		"data/level.dat":         "level",
+		".git/config":            "git",
+		"notlogs/a.txt":          "a",
+		"nested/logs/inner.log":  "inner",
+		"nested/logs.txt/ok.txt": "ok",
+	})
+
+	container, err := WalkAny(dir, tlc.WalkOpts{Filter: FilterPaths})
+	require.NoError(t, err)
+
+	var paths []string
+	var size int64
+	for _, f := range container.Files {
+		require.EqualValues(t, size, f.Offset)
+		size += f.Size
+		paths = append(paths, f.Path)
+	}
+	require.EqualValues(t, size, container.Size)
+	require.ElementsMatch(t, []string{
+		"game.exe",
+		"keep.pdb",
+		"data/debug/ok.txt",
+		"data/important.pdb",
+		"data/level.dat",
+		"notlogs/a.txt",
+		"nested/logs.txt/ok.txt",
+	}, paths)
+
+	for _, d := range container.Dirs {
+		require.NotEqual(t, "logs", d.Path)
+		require.NotEqual(t, "nested/logs", d.Path)
+	}
+
+	ir, err := LoadIgnoreRules(dir)
+	require.NoError(t, err)
+
+	rule := ir.Explain("logs/today.log", false)
+	require.NotNil(t, rule)
+	require.Equal(t, ".butlerignore:3: logs/", rule.String())
+
+	rule = ir.Explain("data/level.bak", false)
+	require.NotNil(t, rule)
+	require.Equal(t, "data/.butlerignore", rule.Source)
+	require.Equal(t, 1, rule.Line)
+
+	require.Nil(t, ir.Explain("keep.pdb", false))
+	require.Equal(t, ignoreFileRule, ir.Explain("data/.butlerignore", false))
+}
+
+func Test_WalkPrunes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".butlerignore":        "node_modules/\n-old\n*.log\n",
+		"-old/.butlerignore":   "!*.log\n",
+		"-old/stale.txt":       "stale",
+		"a/node_modules/x.js":  "x",
+		"a/b/c/deep.txt":       "deep",
+		"a/b/c/deep.log":       "log",
+		"a/z.txt":              "z",
+		"node_modules/y/y.js":  "y",
+		"z/.git/HEAD":          "git",
+		"z/last.txt":           "last",
+		"z/sub/.butlerignore":  "*.txt\n",
+		"z/sub/ignored.txt":    "ignored",
+		"z/sub/kept.dat":       "kept",
+		"zz/after-subdirs.txt": "after",
+	})
+
+	ir, err := LoadIgnoreRules(dir)
+	require.NoError(t, err)
+	for _, rule := range ir.rules {
+		require.NotEqual(t, "-old/.butlerignore", rule.Source, "ignored folders aren't searched for rules")
+	}
+
+	var visited []string
+	filter := func(name string) tlc.FilterResult {
+		visited = append(visited, name)
+		return FilterPaths(name)
+	}
+	container, err := ir.walkDir(dir, tlc.WalkOpts{Filter: filter})
+	require.NoError(t, err)
+
+	var paths []string
+	var offset int64
+	for _, f := range container.Files {
+		paths = append(paths, f.Path)
+		require.Equal(t, offset, f.Offset)
+		offset += f.Size
+	}
+	require.Equal(t, offset, container.Size)
+	require.ElementsMatch(t, []string{
+		"a/b/c/deep.txt",
+		"a/z.txt",
+		"z/last.txt",
+		"z/sub/kept.dat",
+		"zz/after-subdirs.txt",
+	}, paths)
+
+	require.NotContains(t, visited, "stale.txt")
+	require.NotContains(t, visited, "x.js")
+	require.NotContains(t, visited, "y")
+	require.NotContains(t, visited, "HEAD")
+
+	// same result as walking everything and applying the rules after
+	full, err := tlc.WalkDir(dir, tlc.WalkOpts{Filter: FilterPaths})
+	require.NoError(t, err)
+	ir.Apply(full)
+	require.Equal(t, full.Files, container.Files)
+	require.Equal(t, full.Dirs, container.Dirs)
+	require.Equal(t, full.Size, container.Size)
+}
+
+func Test_WalkPrunesWrapped(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".butlerignore":                  "*.log\n",
+		"Sample.app/Contents/Info.plist": "plist",
+		"Sample.app/Contents/crash.log":  "log",
+		"other/file.txt":                 "other",
+	})
+
+	ir, err := LoadIgnoreRules(dir)
+	require.NoError(t, err)
+	container, err := ir.walkDir(dir, tlc.WalkOpts{WrappedDir: "Sample.app"})
+	require.NoError(t, err)
+
+	var paths []string
+	for _, d := range container.Dirs {
+		paths = append(paths, d.Path)
+	}
+	for _, f := range container.Files {
+		paths = append(paths, f.Path)
+	}
+	require.Equal(t, []string{
+		"Sample.app",
+		"Sample.app/Contents",
+		"Sample.app/Contents/Info.plist",
+	}, paths)
+}
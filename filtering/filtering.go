@@ -1,6 +1,7 @@
 package filtering
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/itchio/lake/tlc"
@@ -11,16 +12,52 @@ var CustomIgnorePatterns = []string{}
 // FilterPaths filters out known bad folder/files
 // which butler should just ignore
 var FilterPaths tlc.FilterFunc = func(name string) tlc.FilterResult {
-	if tlc.PresetFilter(name) == tlc.FilterIgnore {
+	if ExplainName(name) != "" {
 		return tlc.FilterIgnore
 	}
+	return tlc.FilterKeep
+}
 
-	for _, pattern := range CustomIgnorePatterns {
-		match, _ := filepath.Match(pattern, name)
-		if match {
+// FilterPathsWith behaves like FilterPaths, but also ignores
+// names matching any of the given patterns.
+func FilterPathsWith(patterns []string) tlc.FilterFunc {
+	if len(patterns) == 0 {
+		return FilterPaths
+	}
+
+	return func(name string) tlc.FilterResult {
+		if FilterPaths(name) == tlc.FilterIgnore {
+			return tlc.FilterIgnore
+		}
+		if MatchName(name, patterns) != "" {
 			return tlc.FilterIgnore
 		}
+		return tlc.FilterKeep
 	}
+}
 
-	return tlc.FilterKeep
+// ExplainName returns why FilterPaths ignores a file or folder name,
+// or an empty string if it doesn't.
+func ExplainName(name string) string {
+	if tlc.PresetFilter(name) == tlc.FilterIgnore {
+		return "(built-in filter)"
+	}
+
+	if pattern := MatchName(name, CustomIgnorePatterns); pattern != "" {
+		return fmt.Sprintf("--ignore %s", pattern)
+	}
+
+	return ""
+}
+
+// MatchName returns the first of patterns a file or folder name
+// matches, or an empty string if it matches none.
+func MatchName(name string, patterns []string) string {
+	for _, pattern := range patterns {
+		match, _ := filepath.Match(pattern, name)
+		if match {
+			return pattern
+		}
+	}
+	return ""
 }
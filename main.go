@@ -12,8 +12,7 @@ import (
 	"runtime/pprof"
 	"time"
 
-	"github.com/efarrer/iothrottler"
-
+	"github.com/itchio/butler/bandwidth"
 	"github.com/itchio/butler/buildinfo"
 	"github.com/itchio/butler/cmd/elevate"
 	"github.com/itchio/butler/comm"
@@ -219,9 +218,9 @@ func doMain(args []string) {
 
 	if *appArgs.throttle > 0 {
 		throttle := *appArgs.throttle
-		bwKiloBytes := throttle / 8 * 1024
+		bwKiloBytes := throttle * 1024 / 8
 		comm.Logf("Throttling to %s/s bandwidth", united.FormatBytes(bwKiloBytes))
-		timeout.ThrottlerPool.SetBandwidth(iothrottler.Bandwidth(throttle) * iothrottler.Kbps)
+		bandwidth.SetThrottle(throttle)
 	}
 
 	if *appArgs.simulateOffline {
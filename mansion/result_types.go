@@ -7,6 +7,10 @@ type WalkResult struct {
 	Type string `json:"type"`
 	Path string `json:"path,omitempty"`
 	Size int64  `json:"size,omitempty"`
+
+	// With --explain: whether the entry would be left out of a push, and why
+	Ignored bool   `json:"ignored,omitempty"`
+	Rule    string `json:"rule,omitempty"`
 }
 
 // A ContainerResult is sent in json mode by the file command